@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/aoeu/audio/midi"
+	_ "github.com/aoeu/audio/midi/portmidi"
 )
 
 var devices, err = midi.GetDevices()
@@ -17,6 +20,7 @@ var r = bufio.NewReader(os.Stdin)
 
 func main() {
 	deviceName := flag.String("device", "", "The MIDI device name to monitor.")
+	recordPath := flag.String("record", "", "A Standard MIDI File to record the monitored session to.")
 	flag.Parse()
 	if err != nil {
 		log.Fatal(err)
@@ -35,13 +39,36 @@ func main() {
 		}
 	}()
 
-	go device.Connect()
+	out := device.Out
+	if *recordPath == "" {
+		go device.Connect()
+	} else {
+		recorder := midi.NewRecorder(*recordPath)
+		source := recorder.Source(device.Name)
+		pipe := midi.NewPipe(device, source)
+		if err := pipe.Open(); err != nil {
+			panic(err)
+		}
+		go pipe.Connect()
+		recorder.Start()
+		defer func() {
+			if err := recorder.Stop(); err != nil {
+				log.Println(err)
+			}
+		}()
+		out = source.Out
+	}
 
 	in := make(chan string, 1)
 	go scanStdin(in)
+	// Return on interrupt so that deferred calls close the device and write any recording.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	for {
 		select {
-		case msg := <-device.Out:
+		case <-signals:
+			return
+		case msg := <-out:
 			log.Printf("%+v\n", msg)
 		case msg := <-device.In:
 			log.Printf("%+v\n", msg)
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aoeu/audio"
+	"github.com/aoeu/audio/midi"
+	_ "github.com/aoeu/audio/midi/portmidi"
+	"github.com/aoeu/audio/midi/route"
+)
+
+// A sampler that plays the NoteOns it receives, configured with the FileName
+// of a sampler configuration file and a Volume that defaults to 0.5.
+const sampler = "sampler"
+
+func newSampler(e route.DeviceEntry) (midi.Wirer, func() error, error) {
+	s, err := audio.NewLoadedSampler(e.FileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.Run(); err != nil {
+		return nil, nil, err
+	}
+	volume := e.Volume
+	if volume == 0 {
+		volume = 0.5
+	}
+	close := func() error {
+		if err := s.Stop(); err != nil {
+			return err
+		}
+		return s.Close()
+	}
+	return s.Device(volume), close, nil
+}
+
+var usage = `
+` + os.Args[0] + ` -config route.json
+
+Routes MIDI data between devices as per a configuration file,
+reloading the configuration upon receiving SIGHUP.
+`
+
+func main() {
+	configPath := flag.String("config", "", "The JSON file of devices and connections to route.")
+	flag.Parse()
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	route.Register(sampler, newSampler)
+	devices, err := midi.GetDevices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	config, err := route.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	graph, err := route.NewGraph(config, devices)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := graph.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+	log.Println("Routing MIDI as per " + *configPath)
+
+	// Unplugged devices carry on routing once they are plugged back in.
+	watcher := midi.NewWatcher(devices)
+	go watcher.Watch()
+	defer watcher.Stop()
+	go func() {
+		for e := range watcher.Events {
+			if e.Added {
+				log.Println("Device plugged in: " + e.Device.Name)
+			} else {
+				log.Println("Device unplugged: " + e.Device.Name)
+			}
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for s := range signals {
+		if s != syscall.SIGHUP {
+			return
+		}
+		config, err := route.LoadConfig(*configPath)
+		if err == nil {
+			err = graph.Load(config)
+		}
+		if err != nil {
+			log.Printf("Could not reload %v: %v\n", *configPath, err)
+			continue
+		}
+		log.Println("Reloaded " + *configPath)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/aoeu/audio/midi"
+	_ "github.com/aoeu/audio/midi/portmidi"
 	"fmt"
 )
 
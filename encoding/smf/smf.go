@@ -0,0 +1,439 @@
+// Package smf reads and writes Standard MIDI Files (SMF).
+package smf
+
+// Relevant specification:
+// https://www.midi.org/specifications/file-format-specifications/standard-midi-files
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Equivalent to enums for SMF formats in C.
+const (
+	FormatSingleTrack  = 0 // A single multi-channel track.
+	FormatMultiTrack   = 1 // Simultaneous tracks of a single sequence.
+	FormatMultiPattern = 2 // Sequentially independent single-track patterns.
+)
+
+// Status bytes of events that are not channel messages.
+const (
+	StatusSysEx       byte = 0xF0
+	StatusSysExEscape byte = 0xF7
+	StatusMeta        byte = 0xFF
+)
+
+// Types of meta events, as found in Event.Meta.
+const (
+	MetaSequenceNumber byte = 0x00
+	MetaText           byte = 0x01
+	MetaCopyright      byte = 0x02
+	MetaTrackName      byte = 0x03
+	MetaInstrumentName byte = 0x04
+	MetaLyric          byte = 0x05
+	MetaMarker         byte = 0x06
+	MetaCuePoint       byte = 0x07
+	MetaChannelPrefix  byte = 0x20
+	MetaEndOfTrack     byte = 0x2F
+	MetaTempo          byte = 0x51
+	MetaSMPTEOffset    byte = 0x54
+	MetaTimeSignature  byte = 0x58
+	MetaKeySignature   byte = 0x59
+)
+
+const (
+	DefaultTempo         = 500000   // Microseconds per quarter note (120 BPM) when a file sets no tempo.
+	DefaultDivision      = 480      // Ticks per quarter note used by NewFile.
+	BytesToReadThreshold = 16777216 // Only read files into RAM that are 16 MB or smaller.
+)
+
+// Meta-data of a MIDI file (the "MThd" chunk) represented as a structure.
+type Header struct {
+	ChunkID   [4]byte
+	ChunkSize int32
+	Format    int16 // Format code, refer to constants for enum values.
+	NumTracks int16
+	Division  int16 // Ticks per quarter note, or SMPTE timing if negative.
+}
+
+// Creates meta-data for a new multi-track file with default settings.
+func NewHeader() (h Header) {
+	h.ChunkID = [4]byte{'M', 'T', 'h', 'd'}
+	h.ChunkSize = 6
+	h.Format = FormatMultiTrack
+	h.NumTracks = 0
+	h.Division = DefaultDivision
+	return h
+}
+
+// Represents a single timed event within a track.
+type Event struct {
+	Delta  uint32 // Ticks elapsed since the previous event of the track.
+	Status byte   // Status byte of a channel message, or one of the Status constants.
+	Meta   byte   // The type of a meta event; only meaningful when Status is StatusMeta.
+	Data   []byte // Data bytes following the status (and meta type / length) bytes.
+}
+
+// Returns true if the event is a channel voice or mode message (e.g. a note or control change).
+func (e Event) IsChannelMessage() bool {
+	return e.Status >= 0x80 && e.Status < 0xF0
+}
+
+// Returns the tempo, in microseconds per quarter note, set by a tempo meta event.
+func (e Event) Tempo() (int, bool) {
+	if e.Status != StatusMeta || e.Meta != MetaTempo || len(e.Data) != 3 {
+		return 0, false
+	}
+	return int(e.Data[0])<<16 | int(e.Data[1])<<8 | int(e.Data[2]), true
+}
+
+// Creates a tempo meta event, in microseconds per quarter note.
+func NewTempoEvent(delta uint32, microsecondsPerQuarter int) Event {
+	t := microsecondsPerQuarter
+	return Event{
+		Delta:  delta,
+		Status: StatusMeta,
+		Meta:   MetaTempo,
+		Data:   []byte{byte(t >> 16), byte(t >> 8), byte(t)},
+	}
+}
+
+// Creates a track name meta event.
+func NewTrackNameEvent(delta uint32, name string) Event {
+	return Event{Delta: delta, Status: StatusMeta, Meta: MetaTrackName, Data: []byte(name)}
+}
+
+// Creates the end of track meta event that every track must finish with.
+func NewEndOfTrackEvent(delta uint32) Event {
+	return Event{Delta: delta, Status: StatusMeta, Meta: MetaEndOfTrack}
+}
+
+// A Track is a list of events in chronological order.
+type Track []Event
+
+// Represents an entire MIDI file, including meta-data and every track.
+type File struct {
+	FileName string
+	Header   *Header
+	Tracks   []Track
+}
+
+// Creates new, empty MIDI file structure.
+func NewFile(fileName string) *File {
+	header := NewHeader()
+	return &File{FileName: fileName, Header: &header}
+}
+
+// Opens and reads an existing MIDI file.
+func OpenFile(fileName string) (*File, error) {
+	f := NewFile(fileName)
+	if err := f.Read(); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// Read reads a MIDI file in entirety into the structure.
+func (f *File) Read() error {
+	h, err := os.Open(f.FileName)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	if info, err := h.Stat(); err != nil || info.Size() > BytesToReadThreshold {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("More bytes in MIDI file (%v) than allowed threshold (%v)",
+			info.Size(), BytesToReadThreshold)
+	}
+	return f.Decode(bufio.NewReader(h))
+}
+
+// Write writes the MIDI file in entirety to disk.
+func (f *File) Write() error {
+	h, err := os.OpenFile(f.FileName, (os.O_WRONLY | os.O_CREATE | os.O_TRUNC), 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(h)
+	if err := f.Encode(w); err != nil {
+		h.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		h.Close()
+		return err
+	}
+	return h.Close()
+}
+
+type chunkHeader struct {
+	ChunkID   [4]byte
+	ChunkSize int32
+}
+
+// Decode reads the header and tracks of a MIDI file from r.
+func (f *File) Decode(r io.Reader) error {
+	var header Header
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if string(header.ChunkID[:]) != "MThd" || header.ChunkSize < 6 {
+		return errors.New("Not a Standard MIDI File: missing MThd chunk.")
+	}
+	// Skip any header fields from future revisions of the spec.
+	if _, err := io.CopyN(io.Discard, r, int64(header.ChunkSize-6)); err != nil {
+		return err
+	}
+	tracks := make([]Track, 0, header.NumTracks)
+	for len(tracks) < int(header.NumTracks) {
+		var chunk chunkHeader
+		if err := binary.Read(r, binary.BigEndian, &chunk); err != nil {
+			return fmt.Errorf("Could not read track %d of %d: %v",
+				len(tracks)+1, header.NumTracks, err)
+		}
+		if chunk.ChunkSize < 0 || chunk.ChunkSize > BytesToReadThreshold {
+			return fmt.Errorf("Bad chunk size %v in MIDI file (beyond threshold %v)",
+				chunk.ChunkSize, BytesToReadThreshold)
+		}
+		data := make([]byte, chunk.ChunkSize)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		if string(chunk.ChunkID[:]) != "MTrk" {
+			continue // Alien chunks are to be ignored as per the spec.
+		}
+		track, err := decodeTrack(data)
+		if err != nil {
+			return fmt.Errorf("Could not decode track %d: %v", len(tracks)+1, err)
+		}
+		tracks = append(tracks, track)
+	}
+	f.Header = &header
+	f.Tracks = tracks
+	return nil
+}
+
+func decodeTrack(data []byte) (Track, error) {
+	r := bytes.NewReader(data)
+	var track Track
+	var runningStatus byte
+	for r.Len() > 0 {
+		delta, err := readVarLen(r)
+		if err != nil {
+			return track, err
+		}
+		status, err := r.ReadByte()
+		if err != nil {
+			return track, err
+		}
+		e := Event{Delta: delta, Status: status}
+		switch {
+		case status == StatusMeta:
+			if e.Meta, err = r.ReadByte(); err != nil {
+				return track, err
+			}
+			if e.Data, err = readVarLenData(r); err != nil {
+				return track, err
+			}
+		case status == StatusSysEx || status == StatusSysExEscape:
+			if e.Data, err = readVarLenData(r); err != nil {
+				return track, err
+			}
+		case status < 0x80: // Running status: the byte read is the first data byte.
+			if runningStatus == 0 {
+				return track, errors.New("Data byte found without a running status.")
+			}
+			e.Status = runningStatus
+			e.Data = make([]byte, DataLen(runningStatus))
+			e.Data[0] = status
+			if _, err := io.ReadFull(r, e.Data[1:]); err != nil {
+				return track, err
+			}
+		case status < 0xF0:
+			runningStatus = status
+			e.Data = make([]byte, DataLen(status))
+			if _, err := io.ReadFull(r, e.Data); err != nil {
+				return track, err
+			}
+		default:
+			return track, fmt.Errorf("Unexpected status byte 0x%X in track.", status)
+		}
+		track = append(track, e)
+		if e.Status == StatusMeta && e.Meta == MetaEndOfTrack {
+			break
+		}
+	}
+	return track, nil
+}
+
+// Returns the number of data bytes that follow a channel message status byte.
+func DataLen(status byte) int {
+	switch status & 0xF0 {
+	case 0xC0, 0xD0: // Program Change and Channel Pressure.
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Encode writes the header and tracks of the MIDI file to w.
+// Running status is used for consecutive channel messages of the same status.
+func (f *File) Encode(w io.Writer) error {
+	header := *f.Header
+	header.ChunkSize = 6
+	header.NumTracks = int16(len(f.Tracks))
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	for _, track := range f.Tracks {
+		data := encodeTrack(track)
+		chunk := chunkHeader{[4]byte{'M', 'T', 'r', 'k'}, int32(len(data))}
+		if err := binary.Write(w, binary.BigEndian, chunk); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeTrack(track Track) []byte {
+	var b bytes.Buffer
+	var runningStatus byte
+	endsTrack := false
+	for _, e := range track {
+		writeVarLen(&b, e.Delta)
+		switch {
+		case e.Status == StatusMeta:
+			b.WriteByte(e.Status)
+			b.WriteByte(e.Meta)
+			writeVarLen(&b, uint32(len(e.Data)))
+			runningStatus = 0
+			endsTrack = e.Meta == MetaEndOfTrack
+		case e.Status == StatusSysEx || e.Status == StatusSysExEscape:
+			b.WriteByte(e.Status)
+			writeVarLen(&b, uint32(len(e.Data)))
+			runningStatus = 0
+		case e.Status != runningStatus:
+			b.WriteByte(e.Status)
+			runningStatus = e.Status
+		}
+		b.Write(e.Data)
+		if endsTrack {
+			return b.Bytes()
+		}
+	}
+	writeVarLen(&b, 0)
+	b.Write([]byte{StatusMeta, MetaEndOfTrack, 0})
+	return b.Bytes()
+}
+
+func readVarLen(r io.ByteReader) (uint32, error) {
+	var n uint32
+	for i := 0; i < 4; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = (n << 7) | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			return n, nil
+		}
+	}
+	return 0, errors.New("Variable-length quantity is longer than 4 bytes.")
+}
+
+func readVarLenData(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarLen(r)
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > r.Len() {
+		return nil, fmt.Errorf("Event length %d exceeds the %d bytes left in track.", n, r.Len())
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+func writeVarLen(w *bytes.Buffer, n uint32) {
+	var buf [4]byte
+	i := len(buf) - 1
+	buf[i] = byte(n & 0x7F)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		buf[i] = byte(n&0x7F) | 0x80
+	}
+	w.Write(buf[i:])
+}
+
+// A TimedEvent is an event of a file at an absolute position from the start of playback.
+type TimedEvent struct {
+	Event
+	Track int           // Index of the track the event was found in.
+	Tick  uint64        // Absolute position in ticks.
+	Time  time.Duration // Absolute position in real-time, respecting tempo changes.
+}
+
+// Merges the tracks of the file into one chronological list of events,
+// resolving delta ticks into absolute ticks and real-time offsets
+// according to the division of the file and every tempo change within it.
+// Events at the same tick keep the order of their tracks.
+func (f *File) Timeline() ([]TimedEvent, error) {
+	if f.Header.Format == FormatMultiPattern {
+		return nil, errors.New("Timeline of format 2 (multi-pattern) MIDI files is not supported.")
+	}
+	var events []TimedEvent
+	for i, track := range f.Tracks {
+		var tick uint64
+		for _, e := range track {
+			tick += uint64(e.Delta)
+			events = append(events, TimedEvent{Event: e, Track: i, Tick: tick})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Tick < events[j].Tick })
+
+	division := int64(f.Header.Division)
+	smpte := division < 0
+	var tickLen time.Duration // Only used for SMPTE timing.
+	if smpte {
+		framesPerSecond := -int64(int8(uint16(f.Header.Division) >> 8))
+		ticksPerFrame := int64(f.Header.Division & 0xFF)
+		if framesPerSecond == 0 || ticksPerFrame == 0 {
+			return nil, fmt.Errorf("Invalid SMPTE division 0x%X.", uint16(f.Header.Division))
+		}
+		tickLen = time.Second / time.Duration(framesPerSecond*ticksPerFrame)
+	} else if division == 0 {
+		return nil, errors.New("Invalid division of 0 ticks per quarter note.")
+	}
+
+	// Offsets are measured from the last tempo change to avoid accumulating rounding errors.
+	tempo := int64(DefaultTempo)
+	var anchorTick uint64
+	var anchorTime time.Duration
+	for i := range events {
+		ticks := int64(events[i].Tick - anchorTick)
+		if smpte {
+			events[i].Time = anchorTime + time.Duration(ticks)*tickLen
+		} else {
+			events[i].Time = anchorTime +
+				time.Duration(ticks*tempo*int64(time.Microsecond)/division)
+		}
+		if t, ok := events[i].Tempo(); ok && t > 0 && !smpte {
+			tempo = int64(t)
+			anchorTick = events[i].Tick
+			anchorTime = events[i].Time
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,97 @@
+package smf
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// A format 0 file with one track that relies upon running status.
+var runningStatusFile = []byte{
+	'M', 'T', 'h', 'd', 0, 0, 0, 6, 0, 0, 0, 1, 0, 96,
+	'M', 'T', 'r', 'k', 0, 0, 0, 18,
+	0x00, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20, // Tempo of 500000 microseconds.
+	0x00, 0x90, 60, 100, // Note On.
+	0x60, 60, 0, // Note On (running status) with a velocity of 0.
+	0x00, 0xFF, 0x2F, 0x00, // End of track.
+}
+
+func TestDecode(t *testing.T) {
+	f := NewFile("")
+	if err := f.Decode(bytes.NewReader(runningStatusFile)); err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Format != FormatSingleTrack || f.Header.Division != 96 {
+		t.Errorf("Decoded header %+v instead of format 0 with division 96", *f.Header)
+	}
+	if len(f.Tracks) != 1 || len(f.Tracks[0]) != 4 {
+		t.Fatalf("Decoded tracks %+v instead of 1 track with 4 events", f.Tracks)
+	}
+	e := f.Tracks[0][2]
+	if e.Delta != 96 || e.Status != 0x90 || !bytes.Equal(e.Data, []byte{60, 0}) {
+		t.Errorf("Decoded %+v instead of a running status Note On", e)
+	}
+	if tempo, ok := f.Tracks[0][0].Tempo(); !ok || tempo != DefaultTempo {
+		t.Errorf("Decoded tempo %v instead of %v", tempo, DefaultTempo)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	f := NewFile("")
+	if err := f.Decode(bytes.NewReader(runningStatusFile)); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := f.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), runningStatusFile) {
+		t.Errorf("Encoded\n%v\ninstead of\n%v", b.Bytes(), runningStatusFile)
+	}
+}
+
+func TestEncodeAddsEndOfTrack(t *testing.T) {
+	f := NewFile("")
+	f.Tracks = []Track{{{Delta: 200, Status: 0x80, Data: []byte{64, 0}}}}
+	var b bytes.Buffer
+	if err := f.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	g := NewFile("")
+	if err := g.Decode(&b); err != nil {
+		t.Fatal(err)
+	}
+	track := g.Tracks[0]
+	if len(track) != 2 || track[0].Delta != 200 || track[1].Meta != MetaEndOfTrack {
+		t.Errorf("Decoded %+v instead of a note followed by the end of track", track)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	f := NewFile("")
+	f.Header.Division = 100
+	f.Tracks = []Track{
+		{
+			NewTempoEvent(0, 1000000),
+			NewTempoEvent(100, 500000), // After 1 second, twice as fast.
+			NewEndOfTrackEvent(100),
+		},
+		{
+			{Delta: 50, Status: 0x90, Data: []byte{60, 100}},
+			{Delta: 100, Status: 0x80, Data: []byte{60, 0}},
+		},
+	}
+	events, err := f.Timeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Duration{0, 500 * time.Millisecond, time.Second, 1250 * time.Millisecond, 1500 * time.Millisecond}
+	if len(events) != len(expected) {
+		t.Fatalf("Timeline has %d events instead of %d", len(events), len(expected))
+	}
+	for i, e := range events {
+		if e.Time != expected[i] {
+			t.Errorf("Event %d at %v instead of %v", i, e.Time, expected[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/midi/controller"
+	_ "github.com/aoeu/audio/midi/portmidi"
+)
+
+var (
+	rootColor    = controller.Color{G: 255}
+	scaleColor   = controller.Color{R: 85, G: 85}
+	outColor     = controller.Off
+	pressedColor = controller.Color{R: 255}
+)
+
+var isomorphisms = map[string]controller.Isomorphism{
+	"fourths":       controller.Fourths,
+	"fifths":        controller.Fifths,
+	"wickihayden":   controller.WickiHayden,
+	"harmonictable": controller.HarmonicTable,
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	var layoutName, scaleName, deviceName string
+	var root, low, rowDegrees, channel int
+	flag.StringVar(&layoutName, "layout", "fourths", "The layout of the grid: fourths, fifths, wickihayden, harmonictable, scale or drums.")
+	flag.StringVar(&scaleName, "scale", "major", "The scale that is lit, or that the scale layout plays, such as major, minor, dorian or pentatonic.")
+	flag.StringVar(&deviceName, "device", "", "The name of the MIDI device to send the notes to.")
+	flag.IntVar(&root, "root", 48, "The root key of the scale.")
+	flag.IntVar(&low, "low", 36, "The key of the bottom left button, of isomorphic and drum layouts.")
+	flag.IntVar(&rowDegrees, "rows", 3, "The number of degrees between the rows of the scale layout.")
+	flag.IntVar(&channel, "channel", 0, "The MIDI channel of the notes.")
+	flag.Parse()
+
+	scale, ok := midi.Scales[scaleName]
+	if !ok {
+		check(fmt.Errorf("There is no scale named %v.", scaleName))
+	}
+	devices, err := midi.GetDevices()
+	check(err)
+	device, ok := devices[deviceName]
+	if !ok {
+		check(fmt.Errorf("There is no MIDI device named %v.", deviceName))
+	}
+
+	grid, err := controller.OpenGrid()
+	check(err)
+	go grid.Connect()
+	defer grid.Close()
+	width, height := grid.Size()
+
+	var layout controller.Layout
+	switch layoutName {
+	case "scale":
+		layout = controller.ScaleLayout(width, height, scale, root, rowDegrees)
+	case "drums":
+		layout = controller.DrumLayout(width, height, low)
+	default:
+		i, ok := isomorphisms[layoutName]
+		if !ok {
+			check(fmt.Errorf("There is no layout named %v.", layoutName))
+		}
+		layout = controller.IsomorphicLayout(width, height, low, i, scale, root)
+	}
+
+	notes := midi.NewThruDevice()
+	pipe := midi.NewPipe(notes, device)
+	check(pipe.Open())
+	go pipe.Connect()
+	defer pipe.Close()
+
+	// Buttons of the same key are lit together as it is played.
+	colors := layout.Colors(rootColor, scaleColor, outColor)
+	frame := controller.NewGridFrame(grid)
+	draw := func(held map[int]bool) {
+		for y := range frame {
+			for x := range frame[y] {
+				frame[y][x] = colors[y][x]
+				if key, ok := layout.Key(x, y); ok && held[key] {
+					frame[y][x] = pressedColor
+				}
+			}
+		}
+		grid.SetFrame(frame)
+	}
+	held := make(map[int]bool)
+	draw(held)
+	for m := range grid.Wire().Out {
+		k, ok := m.(controller.Key)
+		if !ok {
+			continue
+		}
+		key, ok := layout.Key(k.X, k.Y)
+		if !ok {
+			continue
+		}
+		if k.Pressed {
+			notes.In <- midi.NoteOn{Channel: channel, Key: key, Velocity: 100}
+		} else {
+			notes.In <- midi.NoteOff{Channel: channel, Key: key}
+		}
+		held[key] = k.Pressed
+		draw(held)
+	}
+}
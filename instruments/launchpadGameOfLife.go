@@ -1,289 +1,168 @@
 package main
 
 import (
-	"audio/midi"
-	"audio/midi/controller"
+	"flag"
 	"fmt"
-	"time"
+	"os"
+	"strings"
+
+	"github.com/aoeu/audio"
+	"github.com/aoeu/audio/life"
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/midi/controller"
+	_ "github.com/aoeu/audio/midi/portmidi"
+	"github.com/aoeu/audio/transport"
 )
 
-var programMode bool = true
+const monochrome bool = false
 
-type Board [][]bool
-
-const (
-	monochrome bool = false
-	ON_COLOR   int  = controller.Green
-	OFF_COLOR  int  = controller.AmberLow
+var (
+	onColor     = controller.Color{G: 255}
+	offColor    = controller.Color{R: 85, G: 85}
+	columnColor = controller.Color{R: 255, G: 255}
 )
 
-func newBoard(x, y int) Board {
-	board := make([][]bool, x)
-	for i := range board {
-		board[i] = make([]bool, y)
+// The patterns that the buttons of the right column (of a Launchpad) start, run length encoded.
+var patterns = []string{
+	"bo$2bo$3o!",                          // A glider.
+	"$o2bo$4bo$o3bo$b4o!",                 // A lightweight spaceship.
+	"$2o$2bo$3bo$3bo$3bo$2bo$2o!",         // A queen bee.
+	"4bo$2bobo$6bo$2o$6b2o$bo$3bobo$3bo!", // A phoenix.
+	"3$3bo$2b3o$4bo!",                     // A tetromino.
+	"$b3obo$bo$4b2o$2b2obo$bobobo!",       // A pattern of infinite growth.
+	"$3o!",                                // A blinker.
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
 	}
-	return board
 }
 
-func (b Board) checkNeighbors(x, y int) (numNeighbors int) {
-	for row_offset := -1; row_offset < 2; row_offset++ {
-		for column_offset := -1; column_offset < 2; column_offset++ {
-			dx := row_offset + x
-			if dx >= len(b) {
-				dx -= len(b)
-			}
-			if dx < 0 {
-				dx += len(b)
-			}
-			dy := column_offset + y
-			if dy >= len(b[x]) {
-				dy -= len(b[x])
-			}
-			if dy < 0 {
-				dy += len(b)
-			}
-			if dx == x && dy == y {
-				continue
-			}
-			if b[dx][dy] {
-				numNeighbors++
-			}
-		}
-	}
-	return
+func pattern(i int) life.Board {
+	b, _, err := life.ReadRLE(strings.NewReader(patterns[i]))
+	check(err)
+	return b
 }
 
-func (b Board) step() Board {
-	nextBoard := make(Board, len(b))
-	for x := 0; x < len(b); x++ {
-		nextBoard[x] = make([]bool, len(b[x]))
-		for y := 0; y < len(b); y++ {
-			numNeighbors := b.checkNeighbors(x, y)
-			switch {
-			case b[x][y] && numNeighbors < 2:
-				nextBoard[x][y] = false // under-population.
-			case b[x][y] && (numNeighbors == 2 || numNeighbors == 3):
-				nextBoard[x][y] = true // keep-alive.
-			case b[x][y] && numNeighbors > 3:
-				nextBoard[x][y] = false // over-crowding.
-			case b[x][y] == false && numNeighbors == 3:
-				nextBoard[x][y] = true // reproduction.
-			}
-		}
+func colorOf(alive, playing bool) controller.Color {
+	switch {
+	case alive:
+		return onColor
+	case playing:
+		return columnColor
+	case monochrome:
+		return controller.Off
 	}
-	return nextBoard
+	return offColor
 }
 
-func (b Board) print() {
-	for x := 0; x < len(b); x++ {
-		for y := 0; y < len(b[x]); y++ {
-			if b[x][y] {
-				fmt.Printf("%s", "•")
-			} else {
-				fmt.Printf("%s", "-")
-			}
+// Draws a generation as a frame, which grids show without tearing.
+func draw(g life.Generation, frame [][]controller.Color, grid controller.Grid) {
+	for row := range g.Board {
+		for column, alive := range g.Board[row] {
+			frame[row][column] = colorOf(alive, column == g.Column)
 		}
-		fmt.Printf("\n")
 	}
-	fmt.Printf("\n")
+	grid.SetFrame(frame)
 }
 
-func draw(board Board, l *controller.Launchpad) {
-	if monochrome == false {
-		l.AllGridLightsOn(OFF_COLOR)
+func main() {
+	var seedPath, ruleText, mode, configPath, deviceName, scaleName string
+	var root, stepsPerBeat int
+	var tempo float64
+	flag.StringVar(&seedPath, "seed", "", "A run length encoded (RLE) file of the board to start with, rather than a glider.")
+	flag.StringVar(&ruleText, "rule", "", "The rule in B/S notation, such as B36/S23 for HighLife, rather than the seed's.")
+	flag.StringVar(&mode, "mode", "births", "Whether to play the births of each generation, or the live cells of each column in turn.")
+	flag.StringVar(&configPath, "config", "config/launchpad_drums.json", "A config file mapping notes to sound file paths, for the sampler to play.")
+	flag.StringVar(&deviceName, "device", "", "The name of a MIDI device to send the notes to, rather than playing them with the sampler.")
+	flag.StringVar(&scaleName, "scale", "chromatic", "The scale of the rows, such as chromatic, major, minor, dorian, pentatonic or blues.")
+	flag.IntVar(&root, "root", 0, "The key of the bottom row.")
+	flag.Float64Var(&tempo, "tempo", 120, "The tempo in beats per minute.")
+	flag.IntVar(&stepsPerBeat, "steps", 2, "The number of generations (or columns) played per beat.")
+	flag.Parse()
+
+	grid, err := controller.OpenGrid()
+	check(err)
+	go grid.Connect()
+	defer grid.Close()
+	width, height := grid.Size()
+
+	seed, rule := pattern(0), life.Conway
+	if seedPath != "" {
+		seed, rule, err = life.LoadRLE(seedPath)
+		check(err)
 	}
-	for x := 0; x < len(board); x++ {
-		for y := 0; y < len(board[x]); y++ {
-			if board[x][y] {
-				l.LightOnXY(x, y, ON_COLOR)
-			}
-		}
+	if ruleText != "" {
+		rule, err = life.ParseRule(ruleText)
+		check(err)
+	}
+	scale, ok := midi.Scales[scaleName]
+	if !ok {
+		check(fmt.Errorf("There is no scale named %v.", scaleName))
+	}
+	game := life.New(seed.Center(height, width), rule)
+	game.SetScale(scale, root)
+	if mode == "columns" {
+		game.SetMode(life.Columns)
 	}
-}
 
-func redraw(board, nextBoard Board, l *controller.Launchpad) {
-	for x := 0; x < len(board); x++ {
-		for y := 0; y < len(board[x]); y++ {
-			if board[x][y] != nextBoard[x][y] {
-				if nextBoard[x][y] {
-					l.LightOnXY(x, y, ON_COLOR)
-				} else {
-					if monochrome == false {
-						l.LightOnXY(x, y, OFF_COLOR)
-					} else {
-						l.LightOffXY(x, y)
-					}
-				}
-			}
+	clock := transport.New(44100)
+	clock.SetTempo(tempo)
+	check(game.Follow(clock, stepsPerBeat))
+	go clock.Connect()
+	defer clock.Close()
+	if deviceName == "" {
+		sampler, err := audio.NewLoadedSampler(configPath)
+		check(err)
+		check(sampler.Run())
+		defer sampler.Close()
+		game.Trigger = func(note int, volume float32) { sampler.Play(note, 0.5*volume) }
+		go game.Connect()
+		defer game.Close()
+	} else {
+		devices, err := midi.GetDevices()
+		check(err)
+		device, ok := devices[deviceName]
+		if !ok {
+			check(fmt.Errorf("There is no MIDI device named %v.", deviceName))
 		}
+		pipe := midi.NewPipe(game, device)
+		check(pipe.Open())
+		go pipe.Connect()
+		defer pipe.Close()
 	}
-}
+	clock.Start()
 
-func blinker() (b Board) {
-	b = newBoard(8, 8)
-	b[1][0] = true
-	b[1][1] = true
-	b[1][2] = true
-	return
-}
-
-func glider() (b Board) {
-	b = newBoard(8, 8)
-	b[2][0] = true
-	b[2][1] = true
-	b[2][2] = true
-	b[1][2] = true
-	b[0][1] = true
-	return
-}
-
-func spaceship() (b Board) {
-	b = newBoard(8, 8)
-	b[4][1] = true
-	b[4][2] = true
-	b[4][3] = true
-	b[4][4] = true
-	b[3][4] = true
-	b[2][4] = true
-	b[1][3] = true
-	b[1][0] = true
-	b[3][0] = true
-	return
-}
-
-func tetris() (b Board) {
-	b = newBoard(8, 8)
-	b[3][3] = true
-	b[4][2] = true
-	b[4][3] = true
-	b[4][4] = true
-	b[5][4] = true
-	return
-}
-
-func queen() (b Board) {
-	b = newBoard(8, 8)
-	b[1][0] = true
-	b[1][1] = true
-	b[2][2] = true
-	b[3][3] = true
-	b[4][3] = true
-	b[5][3] = true
-	b[6][2] = true
-	b[7][0] = true
-	b[7][1] = true
-	return
-}
-
-func phoenix() (b Board) {
-	b = newBoard(8, 8)
-	b[0][4] = true
-	b[1][2] = true
-	b[1][4] = true
-	b[2][6] = true
-	b[3][0] = true
-	b[3][1] = true
-	b[4][6] = true
-	b[4][7] = true
-	b[5][1] = true
-	b[6][3] = true
-	b[6][5] = true
-	b[7][3] = true
-	return
-}
-
-func infinity() (b Board) {
-	b = newBoard(8, 8)
-	b[1][1] = true
-	b[1][2] = true
-	b[1][3] = true
-	b[1][5] = true
-	b[2][1] = true
-	b[3][4] = true
-	b[3][5] = true
-	b[4][2] = true
-	b[4][3] = true
-	b[4][5] = true
-	b[5][1] = true
-	b[5][3] = true
-	b[5][5] = true
-	return
-}
-
-func handleButtons(l *controller.Launchpad, nextBoards chan Board, quit chan bool) {
+	// The buttons of the top row (of a Launchpad) restart with the seed and stop
+	// or start playback, those of the right column start patterns, and those of
+	// the grid toggle cells.
+	frame := controller.NewGridFrame(grid)
 	for {
 		select {
-		case cc := <-l.OutPort().ControlChanges():
-			if cc.Value == 0 {
+		case g := <-game.Generations:
+			draw(g, frame, grid)
+		case m := <-grid.Wire().Out:
+			k, ok := m.(controller.Key)
+			if !ok || !k.Pressed {
 				continue
 			}
-			switch cc.ID {
-			case 108:
-				quit <- true
-				time.Sleep(250 * time.Millisecond) // Hack.
-				if len(quit) > 0 {
-					<-quit
+			switch {
+			case k.Y == -1 && k.X == 4:
+				game.SetBoard(seed.Center(height, width))
+			case k.Y == -1 && k.X == 7:
+				if clock.Playing() {
+					clock.Stop()
+				} else {
+					clock.Start()
 				}
-				nextBoards <- glider()
-				go loop(l, nextBoards, quit)
-			case 111:
-				quit <- true // Stop the playback loop.
-				programMode = true
+			case k.X == width && k.Y >= 0 && k.Y < len(patterns):
+				game.SetBoard(pattern(k.Y).Fit(height, width))
+			case k.X >= 0 && k.X < width && k.Y >= 0 && k.Y < height:
+				game.SetBoard(game.Board().Toggle(k.Y, k.X))
+				draw(life.Generation{Board: game.Board(), Column: -1}, frame, grid)
 			}
-		case note := <-l.OutPort().NoteOns():
-			switch note.Key {
-			case 8:
-				nextBoards <- glider()
-			case 24:
-				nextBoards <- spaceship()
-			case 40:
-				nextBoards <- queen()
-			case 56:
-				nextBoards <- phoenix()
-			case 72:
-				nextBoards <- queen()
-			case 88:
-				nextBoards <- infinity()
-			}
-		case note := <-l.OutPort().NoteOffs():
-			l.ToggleLightColor(note.Key, ON_COLOR, OFF_COLOR)
-		}
-	}
-}
-
-func loop(l *controller.Launchpad, nextBoards chan Board, quit chan bool) {
-	board := <-nextBoards
-	draw(board, l)
-	time.Sleep(1 * time.Second)
-	for {
-		var nextBoard Board
-		select {
-		case nextBoard = <-nextBoards:
-			break
-		case <-quit:
-			return
-		default:
-			nextBoard = board.step()
 		}
-		redraw(board, nextBoard, l)
-		time.Sleep(250 * time.Millisecond)
-		board = nextBoard
 	}
 }
-
-func main() {
-	devices, _ := midi.GetDevices()
-	launchpad := controller.NewLaunchpad(devices["Launchpad"], make(map[int]int))
-	launchpad.Open()
-	go launchpad.Run()
-
-	nextBoards := make(chan Board, 1)
-	nextBoards <- glider()
-
-	quit := make(chan bool, 1)
-	go handleButtons(&launchpad, nextBoards, quit)
-	go loop(&launchpad, nextBoards, quit)
-
-	wait := make(chan bool)
-	<-wait // wait forever
-}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"audio"
 	"fmt"
-	"midi"
 	"os"
+
+	"github.com/aoeu/audio"
+	"github.com/aoeu/audio/midi/controller"
+	_ "github.com/aoeu/audio/midi/portmidi"
 )
 
 func check(err error) {
@@ -15,25 +17,25 @@ func check(err error) {
 }
 
 func main() {
-	devices, err := midi.GetDevices()
+	grid, err := controller.OpenGrid()
 	check(err)
-	launchpad := midi.NewLaunchpad(devices["Launchpad"], make(map[int]int))
-	launchpad.Open()
-	go launchpad.Run()
+	go grid.Connect()
+	defer grid.Close()
 	sampler, err := audio.NewLoadedSampler("instruments/config/launchpad_drums.json")
-	sampler.Run()
 	check(err)
-	for {
-		select {
-		case note := <-launchpad.OutPort().NoteOns():
-			go sampler.Play(note.Key, 0.3)
-		case <-launchpad.OutPort().NoteOffs():
+	sampler.Run()
+	defer sampler.Close()
+	for m := range grid.Wire().Out {
+		k, ok := m.(controller.Key)
+		if !ok {
 			continue
-		case <-launchpad.OutPort().ControlChanges():
+		}
+		if !k.Pressed {
+			grid.Set(k.X, k.Y, controller.Off)
 			continue
 		}
+		grid.Set(k.X, k.Y, controller.Color{G: 255})
+		// The notes of the config are of 16 buttons per row.
+		go sampler.Play(16*k.Y+k.X, 0.3)
 	}
-	launchpad.Close()
-	sampler.Stop()
-	sampler.Close()
 }
@@ -1,13 +1,14 @@
 package main
 
 import (
-	"audio"
-	"github.com/aoeu/audio/midi"
-	"github.com/aoeu/audio/midi/controller"
-	"fmt"
 	"log"
 	"os"
-	"time"
+
+	"github.com/aoeu/audio"
+	"github.com/aoeu/audio/midi/controller"
+	_ "github.com/aoeu/audio/midi/portmidi"
+	"github.com/aoeu/audio/sequencer"
+	"github.com/aoeu/audio/transport"
 )
 
 func check(err error) {
@@ -17,61 +18,68 @@ func check(err error) {
 	}
 }
 
+var (
+	red    = controller.Color{R: 255}
+	redLow = controller.Color{R: 85}
+	green  = controller.Color{G: 255}
+)
+
+// Returns the colour of a step, which is brighter in the column that is playing.
+func colorOf(on, playing bool) controller.Color {
+	switch {
+	case on && playing:
+		return red
+	case on:
+		return redLow
+	case playing:
+		return green
+	}
+	return controller.Off
+}
+
 func main() {
-	devices, err := midi.GetDevices()
+	grid, err := controller.OpenGrid()
 	check(err)
-
-	launchpad := controller.NewLaunchpad(devices["Launchpad"], map[int]int{})
-	launchpad.ButtonPressColor = controller.RedLow
-	launchpad.MomentaryButtons = false
-	launchpad.Open()
-	go launchpad.Run()
+	go grid.Connect()
+	defer grid.Close()
 
 	sampler, err := audio.NewLoadedSampler("config/launchpad_sequencer.json")
+	check(err)
+	// The sequencer follows the transport that the sampler's audio advances.
+	clock := transport.New(44100)
+	sampler.Transport = clock
 	sampler.Run()
-	time.Sleep(1 * time.Second)
-	activeButtons := make(map[int]bool)
-	for i := 0; i < 8; i++ {
-		for j := i * 16; j < (i*16 + 8); j++ {
-			activeButtons[j] = false
-		}
-	}
 
-	go func() {
-		for {
-			select {
-			case note := <-launchpad.OutPort().NoteOns():
-				activeButtons[note.Key] = !activeButtons[note.Key] // toggle state
-				launchpad.ToggleLightColor(note.Key, controller.RedLow, controller.Black)
-			case <-launchpad.OutPort().NoteOffs():
-				continue
-			case <-launchpad.OutPort().ControlChanges():
-				continue
+	// Each row of the grid is a track of a clip of the sampler, of a step per column.
+	width, height := grid.Size()
+	pattern := sequencer.NewPattern(height, width)
+	seq := sequencer.New(pattern)
+	seq.Trigger = func(note int, volume float32) { sampler.Play(note, 0.7) }
+	seq.Follow(clock)
+	go seq.Connect()
+	defer seq.Close()
+	seq.Start()
+	clock.Start()
+
+	frame := controller.NewGridFrame(grid)
+	playing := -1
+	draw := func() {
+		for y := range frame {
+			for x := range frame[y] {
+				frame[y][x] = colorOf(pattern.Step(y, x).On, x == playing)
 			}
 		}
-	}()
-
+		grid.SetFrame(frame)
+	}
 	for {
-		for i := 0; i < 8; i++ {
-			startButton := i
-			endButton := startButton + (8 * 16)
-			for j := startButton; j < endButton; j += 16 {
-				if activeButtons[j] {
-					launchpad.LightOn(j, controller.Red) // On color
-					column, _ := launchpad.XY(j)
-					sampler.Play(column, 0.7)
-				} else {
-					launchpad.LightOn(j, controller.Green) // Off color
-				}
-			}
-			time.Sleep(250 * time.Millisecond)
-			for j := startButton; j < endButton; j += 16 {
-				if activeButtons[j] {
-					launchpad.LightOn(j, controller.RedLow)
-				} else {
-					launchpad.LightOff(j)
-				}
+		select {
+		case m := <-grid.Wire().Out:
+			if k, ok := m.(controller.Key); ok && k.Pressed && k.Y >= 0 && k.Y < height && k.X >= 0 && k.X < width {
+				pattern.Toggle(k.Y, k.X)
 			}
+		case p := <-seq.Steps:
+			playing = p.Step
 		}
+		draw()
 	}
 }
@@ -1,21 +1,31 @@
 package main
 
 import (
-	"github.com/aoeu/audio/midi"
-	"github.com/aoeu/audio/midi/controller"
 	"fmt"
+	"os"
 	"time"
+
+	"github.com/aoeu/audio/midi/controller"
+	_ "github.com/aoeu/audio/midi/portmidi"
 )
 
 func main() {
-	devices, _ := midi.GetDevices()
-	launchpad := controller.NewLaunchpad(devices["Launchpad"], make(map[int]int))
-	launchpad.Open()
-	go launchpad.Run()
+	grid, err := controller.OpenGrid()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	go grid.Connect()
+	defer grid.Close()
 
-	fmt.Println("Here.")
 	time.Sleep(1 * time.Second)
-	launchpad.AllGridLightsOn(controller.Green)
+	frame := controller.NewGridFrame(grid)
+	for y := range frame {
+		for x := range frame[y] {
+			frame[y][x] = controller.Color{G: 255}
+		}
+	}
+	grid.SetFrame(frame)
 
 	wait := make(chan bool, 1)
 	<-wait
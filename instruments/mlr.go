@@ -1,13 +1,14 @@
 package main
 
 import (
-	"audio"
 	"fmt"
 	"os"
 	"time"
 
-	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio"
 	"github.com/aoeu/audio/midi/controller"
+	_ "github.com/aoeu/audio/midi/portmidi"
+	"github.com/aoeu/audio/mlr"
 )
 
 func check(err error) {
@@ -17,96 +18,43 @@ func check(err error) {
 	}
 }
 
-func sleep(msLen time.Duration, resume chan bool) {
-	if len(resume) > 0 {
-		<-resume // Clear (e.g. reset) resume buffer it can be used.
-	}
-	time.Sleep(msLen)
-	resume <- true
-}
-
 func main() {
-	// Split out a sample, set up the sampler.
-	beat, err := audio.NewClipFromWave("samples/loops/beat.wav")
-	check(err)
-	numDivisions := 8
-	sleepLen := (beat.Duration() / int64(numDivisions))
-	sampler, err := audio.NewSampler(2)
+	// Set up the grid, whose rows below the top row play the clips of the tracks
+	// of the configuration, and whose top row records patterns and changes rows.
+	grid, err := controller.OpenGrid()
 	check(err)
-	clips, err := beat.Split(numDivisions)
+	go grid.Connect()
+	defer grid.Close()
+	width, height := grid.Size()
+
+	config, err := mlr.LoadConfig("config/mlr.json")
 	check(err)
-	for i, clip := range clips {
-		sampler.AddClip(clip, i)
+	if len(config.Tracks) > height-1 {
+		config.Tracks = config.Tracks[:height-1]
 	}
-	sampler.Run()
+	m := mlr.New(config, width, 44100)
 
-	// Set up the launchpad.
-	devices, err := midi.GetDevices()
+	// The sampler renders the rows and advances the transport they are quantized to.
+	sampler, err := audio.NewSampler(2)
 	check(err)
-	launchpad := controller.NewLaunchpad(devices["Launchpad"], map[int]int{})
-	launchpad.ButtonPressColor = controller.Red
-
-	launchpad.Open()
-	go launchpad.Run()
-
-	// Throw out MIDI data that is not needed.
-	go func() {
-		for {
-			select {
-			case note := <-launchpad.OutPort().NoteOffs():
-				launchpad.LightOff(note.Key)
-			case <-launchpad.OutPort().ControlChanges():
-				continue
-			}
-		}
-	}()
-
-	i := 0
-	var volume float32 = 0.5
-	play := make(chan bool, 1)
-	pause := make(chan bool, 1)
-	paused := true
+	sampler.Transport = m.Transport
+	sampler.Render = m.Render
+	check(sampler.RunAtRate(44100))
+	defer sampler.Close()
+	m.Transport.Start()
+
+	frame := controller.NewGridFrame(grid)
+	redraw := time.NewTicker(time.Second / 30)
+	defer redraw.Stop()
 	for {
 		select {
-		case note := <-launchpad.OutPort().NoteOns():
-			if note.Key == 8 {
-				pause <- true
-			}
-			if note.Key > numDivisions {
-				continue
-			}
-			last := i - 1
-			if last < 0 {
-				last = numDivisions - 1
-			}
-			launchpad.LightOff(last)
-			i = note.Key
-		case <-play:
-			last := i - 1
-			if last < 0 {
-				last = numDivisions - 1
-			}
-			launchpad.LightOff(last)
-			go sampler.Play(i, volume)
-			launchpad.LightOn(i, controller.Green)
-			go sleep(sleepLen, play)
-			i++
-			if i >= numDivisions {
-				i = 0
-			}
-		case <-pause:
-			timeout := make(chan bool, 1)
-			go sleep(sleepLen, timeout)
-			select {
-			case <-play:
-			case <-timeout:
-			}
-			if paused {
-				play <- true
-				paused = false
-			} else {
-				paused = true
+		case msg := <-grid.Wire().Out:
+			if k, ok := msg.(controller.Key); ok {
+				m.Press(k.X, k.Y, k.Pressed)
 			}
+		case <-redraw.C:
+			m.Draw(frame)
+			grid.SetFrame(frame)
 		}
 	}
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/aoeu/audio"
 	"github.com/aoeu/audio/midi"
+	_ "github.com/aoeu/audio/midi/portmidi"
 )
 
 func check(err error) {
@@ -28,7 +29,7 @@ func main() {
 	check(err)
 	nanopad := devices[deviceName]
 	nanopad.Open()
-	go nanopad.Run()
+	go nanopad.Connect()
 	sampler, err := audio.NewLoadedSampler(configPath)
 	check(err)
 	sampler.Run()
@@ -0,0 +1,190 @@
+// Package life provides cellular automata of the Game of Life and its variants,
+// on boards that wrap around at their edges, as a generative instrument that
+// plays the cells of each generation as notes of a scale.
+package life
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Rule is the numbers of live neighbours with which a dead cell is born and a
+// live cell survives.
+type Rule struct {
+	Birth    [9]bool
+	Survival [9]bool
+}
+
+// Rules of well-known automata.
+var (
+	Conway      = Rule{Birth: [9]bool{3: true}, Survival: [9]bool{2: true, 3: true}}
+	HighLife    = Rule{Birth: [9]bool{3: true, 6: true}, Survival: [9]bool{2: true, 3: true}}
+	Seeds       = Rule{Birth: [9]bool{2: true}}
+	DayAndNight = Rule{
+		Birth:    [9]bool{3: true, 6: true, 7: true, 8: true},
+		Survival: [9]bool{3: true, 4: true, 6: true, 7: true, 8: true},
+	}
+)
+
+var errRule = errors.New("A rule must be in B/S notation, such as B36/S23.")
+
+// Parses a rule in B/S notation, such as B3/S23 for Conway's Game of Life.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "/")
+	if len(parts) != 2 {
+		return r, errRule
+	}
+	for _, part := range parts {
+		if part == "" {
+			return r, errRule
+		}
+		var counts *[9]bool
+		switch part[0] {
+		case 'B':
+			counts = &r.Birth
+		case 'S':
+			counts = &r.Survival
+		default:
+			return r, errRule
+		}
+		for _, c := range part[1:] {
+			if c < '0' || c > '8' {
+				return r, errRule
+			}
+			counts[c-'0'] = true
+		}
+	}
+	return r, nil
+}
+
+// Returns the rule in B/S notation.
+func (r Rule) String() string {
+	b, s := "B", "S"
+	for n := 0; n < 9; n++ {
+		if r.Birth[n] {
+			b += fmt.Sprint(n)
+		}
+		if r.Survival[n] {
+			s += fmt.Sprint(n)
+		}
+	}
+	return b + "/" + s
+}
+
+// A Board is the cells of a grid, by row and column, that are alive. Boards are
+// not changed by their methods, so that generations may be shared.
+type Board [][]bool
+
+// A Cell is the row and column of a cell of a board.
+type Cell struct {
+	Row, Column int
+}
+
+// Creates a new board of a number of rows and columns of dead cells.
+func NewBoard(rows, columns int) Board {
+	b := make(Board, rows)
+	for i := range b {
+		b[i] = make([]bool, columns)
+	}
+	return b
+}
+
+// Returns the number of rows and columns of the board.
+func (b Board) Size() (rows, columns int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	return len(b), len(b[0])
+}
+
+// Returns the number of live neighbours of a cell, wrapping around the edges of the board.
+func (b Board) Neighbors(row, column int) (n int) {
+	rows, columns := b.Size()
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			if dr == 0 && dc == 0 {
+				continue
+			}
+			if b[(row+dr+rows)%rows][(column+dc+columns)%columns] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Returns the next generation of the board by a rule.
+func (b Board) Step(r Rule) Board {
+	rows, columns := b.Size()
+	next := NewBoard(rows, columns)
+	for row := range b {
+		for column, alive := range b[row] {
+			n := b.Neighbors(row, column)
+			next[row][column] = alive && r.Survival[n] || !alive && r.Birth[n]
+		}
+	}
+	return next
+}
+
+// Returns the cells that are alive in the next generation of the board but not in the board.
+func (b Board) Births(next Board) (born []Cell) {
+	for row := range next {
+		for column, alive := range next[row] {
+			if alive && !b[row][column] {
+				born = append(born, Cell{row, column})
+			}
+		}
+	}
+	return born
+}
+
+// Returns a board of a number of rows and columns, with the cells of the board
+// that fit in it from its top left.
+func (b Board) Fit(rows, columns int) Board {
+	fitted := NewBoard(rows, columns)
+	for row := 0; row < len(b) && row < rows; row++ {
+		copy(fitted[row], b[row])
+	}
+	return fitted
+}
+
+// Returns a board of a number of rows and columns, with the board in its middle.
+func (b Board) Center(rows, columns int) Board {
+	fitted := NewBoard(rows, columns)
+	height, width := b.Size()
+	top, left := (rows-height)/2, (columns-width)/2
+	for row := range b {
+		for column, alive := range b[row] {
+			if r, c := row+top, column+left; r >= 0 && r < rows && c >= 0 && c < columns {
+				fitted[r][c] = alive
+			}
+		}
+	}
+	return fitted
+}
+
+// Returns a copy of the board with a cell toggled.
+func (b Board) Toggle(row, column int) Board {
+	rows, columns := b.Size()
+	toggled := b.Fit(rows, columns)
+	toggled[row][column] = !toggled[row][column]
+	return toggled
+}
+
+// Returns the board with a line per row, of • for live cells and - for dead ones.
+func (b Board) String() string {
+	var s strings.Builder
+	for row := range b {
+		for _, alive := range b[row] {
+			if alive {
+				s.WriteString("•")
+			} else {
+				s.WriteString("-")
+			}
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
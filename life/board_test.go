@@ -0,0 +1,59 @@
+package life
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	for s, expected := range map[string]Rule{"B3/S23": Conway, "b36/s23": HighLife, "S23/B36": HighLife, "B2/S": Seeds} {
+		r, err := ParseRule(s)
+		if err != nil || r != expected {
+			t.Errorf("Parsed %v as %v (%v) instead of %v", s, r, err, expected)
+		}
+	}
+	if s := DayAndNight.String(); s != "B3678/S34678" {
+		t.Errorf("Day & Night is %v instead of B3678/S34678", s)
+	}
+	for _, s := range []string{"", "B3", "B9/S23", "X3/S23"} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("Parsed an invalid rule %q", s)
+		}
+	}
+}
+
+func TestStep(t *testing.T) {
+	// A glider travels a cell diagonally every 4 generations, so it returns to
+	// where it started on a board of 5 by 7 cells after 4*5*7 generations.
+	glider, _, err := ReadRLE(strings.NewReader("bo$2bo$3o!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, _, err := ReadRLE(strings.NewReader("$2bo$3bo$b3o!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := glider.Fit(5, 7)
+	b := start
+	for i := 0; i < 4*5*7; i++ {
+		b = b.Step(Conway)
+		if i == 3 && b.String() != moved.Fit(5, 7).String() {
+			t.Errorf("The glider did not move after 4 generations:\n%v", b)
+		}
+	}
+	if b.String() != start.String() {
+		t.Errorf("The glider wrapped around to\n%vinstead of\n%v", b, start)
+	}
+}
+
+func TestBirths(t *testing.T) {
+	b := NewBoard(3, 4).Toggle(1, 0).Toggle(1, 1).Toggle(1, 2)
+	next := b.Step(Conway)
+	born := b.Births(next)
+	if len(born) != 2 || born[0] != (Cell{0, 1}) || born[1] != (Cell{2, 1}) {
+		t.Errorf("A blinker bore %v instead of cells above and below its middle", born)
+	}
+	if !b[1][0] || b.Toggle(1, 0)[1][0] == b[1][0] {
+		t.Errorf("Toggling a cell of a board changed the board")
+	}
+}
@@ -0,0 +1,232 @@
+package life
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/transport"
+)
+
+// A Mode is what of each generation is played.
+type Mode int
+
+const (
+	Births  Mode = iota // Each step plays the rows of the cells born in the next generation.
+	Columns             // Each step plays the rows of the live cells of a column, from left to right.
+)
+
+// A Generation is a board that is played, and the column of it, if any.
+type Generation struct {
+	Board  Board
+	Column int // The column played, or -1 for the births of the board.
+}
+
+// A Life is a device that steps the generations of a board by a rule as a
+// transport reaches each step, sending a note of a scale for each row that
+// plays. Rows are the degrees of the scale from the root, upwards from the bottom.
+type Life struct {
+	*midi.Wires
+	Generations chan Generation // Receives each generation as it is played, if it has room.
+	// If set, notes call Trigger, such as the Play method of an audio.Sampler,
+	// with their keys and velocities from 0 to 1, rather than sending MIDI data.
+	Trigger    func(note int, volume float32)
+	mu         sync.Mutex
+	board      Board
+	rule       Rule
+	mode       Mode
+	scale      midi.Scale
+	root       int
+	channel    int
+	velocity   int
+	column     int   // The next column to be played, in the Columns mode.
+	sounding   []int // The keys of the notes of the last step, which end at the next.
+	steps      chan time.Time
+	disconnect chan bool
+}
+
+// Creates a new generative device of a board and rule, which plays the births of
+// each generation as notes of the C major pentatonic scale from middle C.
+func New(b Board, r Rule) *Life {
+	return &Life{
+		Wires:       midi.NewWires(),
+		Generations: make(chan Generation, 16),
+		board:       b,
+		rule:        r,
+		scale:       midi.MajorPentatonic,
+		root:        60,
+		velocity:    100,
+		steps:       make(chan time.Time, 1),
+		disconnect:  make(chan bool, 1),
+	}
+}
+
+func (l *Life) Open() error {
+	return nil
+}
+
+func (l *Life) Close() error {
+	l.disconnect <- true
+	return nil
+}
+
+func (l *Life) Wire() *midi.Wires {
+	return l.Wires
+}
+
+// Plays a step a number of times per beat of a transport, from 1 to its ticks
+// per quarter note, as it reaches them.
+func (l *Life) Follow(t *transport.Transport, stepsPerBeat int) error {
+	if stepsPerBeat < 1 || stepsPerBeat > t.PPQN {
+		return fmt.Errorf("Cannot play %v steps per beat of %v ticks.", stepsPerBeat, t.PPQN)
+	}
+	t.Every(1, func(tick int64) {
+		if !t.StepStarts(tick, stepsPerBeat) {
+			return
+		}
+		select {
+		case l.steps <- time.Now():
+		default:
+		}
+	})
+	return nil
+}
+
+// Plays the steps that the transport reaches, until the device is closed.
+func (l *Life) Connect() {
+	for {
+		select {
+		case at := <-l.steps:
+			if !l.send(l.step(midi.TimestampOf(at))) {
+				return
+			}
+		case <-l.disconnect:
+			return
+		}
+	}
+}
+
+// Sends notes out of the device, or triggers them, returning false if the device
+// was closed while sending them.
+func (l *Life) send(notes []midi.Message) bool {
+	l.mu.Lock()
+	trigger := l.Trigger
+	l.mu.Unlock()
+	for _, m := range notes {
+		if trigger != nil {
+			if n, ok := m.(midi.NoteOn); ok {
+				trigger(n.Key, float32(n.Velocity)/127)
+			}
+			continue
+		}
+		select {
+		case l.Out <- m:
+		case <-l.disconnect:
+			return false
+		}
+	}
+	return true
+}
+
+// Ends the notes that are sounding. The device must be locked.
+func (l *Life) release(at midi.Timestamp) (offs []midi.Message) {
+	for _, key := range l.sounding {
+		offs = append(offs, midi.NoteOff{Channel: l.channel, Key: key, Time: at})
+	}
+	l.sounding = nil
+	return offs
+}
+
+// Plays a step, returning the note offs of the last step and the notes of this one.
+func (l *Life) step(at midi.Timestamp) []midi.Message {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	notes := l.release(at)
+	rows, columns := l.board.Size()
+	if rows == 0 || columns == 0 {
+		return notes
+	}
+	playing := make(map[int]bool)
+	played := Generation{Board: l.board, Column: -1}
+	switch l.mode {
+	case Births:
+		next := l.board.Step(l.rule)
+		for _, c := range l.board.Births(next) {
+			playing[c.Row] = true
+		}
+		l.board = next
+		played.Board = next
+	case Columns:
+		if l.column >= columns {
+			l.column = 0
+		}
+		for row := range l.board {
+			if l.board[row][l.column] {
+				playing[row] = true
+			}
+		}
+		played.Column = l.column
+		if l.column++; l.column == columns {
+			l.column = 0
+			l.board = l.board.Step(l.rule)
+		}
+	}
+	var keys []int
+	for row := range playing {
+		if key := l.scale.Key(l.root, rows-1-row); key >= 0 && key <= 127 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		notes = append(notes, midi.NoteOn{Channel: l.channel, Key: key, Velocity: l.velocity, Time: at})
+	}
+	l.sounding = keys
+	select {
+	case l.Generations <- played:
+	default:
+	}
+	return notes
+}
+
+// Returns the board that is played.
+func (l *Life) Board() Board {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.board
+}
+
+// Sets the board that is played, such as a seed or a board with cells toggled.
+func (l *Life) SetBoard(b Board) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.board = b
+}
+
+func (l *Life) SetRule(r Rule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rule = r
+}
+
+func (l *Life) SetMode(m Mode) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mode, l.column = m, 0
+}
+
+// Sets the scale and root key that the rows are played as.
+func (l *Life) SetScale(s midi.Scale, root int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.scale, l.root = s, root
+}
+
+// Sets the channel and velocity of the notes.
+func (l *Life) SetNotes(channel, velocity int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.channel, l.velocity = channel, velocity
+}
@@ -0,0 +1,86 @@
+package life
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/transport"
+)
+
+func TestLife(t *testing.T) {
+	tr := transport.New(48000)
+	tr.SetSource(transport.Audio)
+	blinker := NewBoard(5, 4).Toggle(2, 0).Toggle(2, 1).Toggle(2, 2)
+	l := New(blinker, Conway)
+	for _, steps := range []int{0, tr.PPQN + 1} {
+		if err := l.Follow(tr, steps); err == nil {
+			t.Errorf("Followed the transport with %v steps per beat", steps)
+		}
+	}
+	if err := l.Follow(tr, 4); err != nil {
+		t.Fatal(err)
+	}
+	go l.Connect()
+	defer l.Close()
+	tr.Start()
+
+	type note struct {
+		on  bool
+		key int
+	}
+	receive := func() (n note) {
+		select {
+		case m := <-l.Out:
+			switch m := m.(type) {
+			case midi.NoteOn:
+				return note{true, m.Key}
+			case midi.NoteOff:
+				return note{false, m.Key}
+			}
+			t.Fatalf("Received %v instead of a note", m)
+		case <-time.After(time.Second):
+			t.Fatal("Did not receive a note")
+		}
+		return
+	}
+	// The births of the blinker are above and below its middle, the degrees 3
+	// and 1 of the scale, and then either side of it, degree 2.
+	tr.Advance(1)
+	expected := []note{{true, 62}, {true, 67}}
+	for _, e := range expected {
+		if n := receive(); n != e {
+			t.Errorf("Received %+v instead of %+v", n, e)
+		}
+	}
+	tr.Advance(6000) // A sixteenth note at 120 BPM.
+	expected = []note{{false, 62}, {false, 67}, {true, 64}}
+	for _, e := range expected {
+		if n := receive(); n != e {
+			t.Errorf("Received %+v instead of %+v", n, e)
+		}
+	}
+	if g := <-l.Generations; g.Column != -1 || g.Board.String() != blinker.Step(Conway).String() {
+		t.Errorf("Played the generation\n%vinstead of the blinker's next", g.Board)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	l := New(NewBoard(3, 2).Toggle(0, 0).Toggle(2, 0).Toggle(1, 1), HighLife)
+	l.SetMode(Columns)
+	l.SetScale(midi.Major, 48)
+	for i, keys := range [][]int{{48, 52}, {50}} {
+		var played []int
+		for _, m := range l.step(0) {
+			if n, ok := m.(midi.NoteOn); ok {
+				played = append(played, n.Key)
+			}
+		}
+		if len(played) != len(keys) || played[0] != keys[0] || played[len(played)-1] != keys[len(keys)-1] {
+			t.Errorf("Played %v instead of %v from column %v", played, keys, i)
+		}
+	}
+	if g := <-l.Generations; g.Column != 0 {
+		t.Errorf("Played column %v instead of 0", g.Column)
+	}
+}
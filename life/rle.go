@@ -0,0 +1,114 @@
+package life
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Reads a board, and its rule (or Conway's if it has none), from a file of the
+// run length encoded format of pattern collections such as LifeWiki's.
+func LoadRLE(fileName string) (Board, Rule, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, Conway, err
+	}
+	defer f.Close()
+	return ReadRLE(f)
+}
+
+// Reads a board and its rule from run length encoded data: optional comment
+// lines starting with #, an optional header such as "x = 3, y = 3, rule = B3/S23",
+// and runs of dead (b) and live (o) cells, rows ending with $ and the board with !.
+func ReadRLE(r io.Reader) (Board, Rule, error) {
+	rule := Conway
+	width, height := 0, 0
+	var data strings.Builder
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "x") && data.Len() == 0:
+			for _, field := range strings.Split(line, ",") {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					return nil, rule, fmt.Errorf("Invalid RLE header %q.", line)
+				}
+				key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+				var err error
+				switch key {
+				case "x":
+					_, err = fmt.Sscan(value, &width)
+				case "y":
+					_, err = fmt.Sscan(value, &height)
+				case "rule":
+					rule, err = ParseRule(value)
+				}
+				if err != nil {
+					return nil, rule, err
+				}
+			}
+		default:
+			data.WriteString(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, rule, err
+	}
+
+	var rows [][]bool
+	row := []bool{}
+	count := 0
+	for _, c := range data.String() {
+		n := count
+		if n == 0 {
+			n = 1
+		}
+		switch {
+		case c >= '0' && c <= '9':
+			count = count*10 + int(c-'0')
+			continue
+		case c == 'b' || c == '.':
+			row = append(row, make([]bool, n)...)
+		case c == '$':
+			rows = append(rows, row)
+			for i := 1; i < n; i++ {
+				rows = append(rows, nil)
+			}
+			row = []bool{}
+		case c == '!':
+			rows = append(rows, row)
+			return board(rows, width, height), rule, nil
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			// Cells of any other state, of automata of more states, are alive.
+			for i := 0; i < n; i++ {
+				row = append(row, true)
+			}
+		default:
+			return nil, rule, fmt.Errorf("Invalid RLE cell %q.", c)
+		}
+		count = 0
+	}
+	return nil, rule, errors.New("RLE data must end with !.")
+}
+
+// Returns a board of rows of cells, of at least a width and height.
+func board(rows [][]bool, width, height int) Board {
+	if len(rows) > height {
+		height = len(rows)
+	}
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	b := NewBoard(height, width)
+	for i, row := range rows {
+		copy(b[i], row)
+	}
+	return b
+}
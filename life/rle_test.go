@@ -0,0 +1,38 @@
+package life
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadRLE(t *testing.T) {
+	rle := `#N Replicator
+#C A pattern of HighLife.
+x = 5, y = 6, rule = B36/S23
+2b3o$bo2bo$o3bo$o2bo$3o!
+`
+	b, r, err := ReadRLE(strings.NewReader(rle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != HighLife {
+		t.Errorf("Read the rule %v instead of HighLife", r)
+	}
+	expected := "--•••\n-•--•\n•---•\n•--•-\n•••--\n-----\n"
+	if b.String() != expected {
+		t.Errorf("Read\n%vinstead of\n%v", b, expected)
+	}
+
+	b, r, err = ReadRLE(strings.NewReader("o$\n2$b2o!"))
+	if err != nil || r != Conway {
+		t.Fatalf("Read a rule of %v (%v) instead of Conway's", r, err)
+	}
+	if b.String() != "•--\n---\n---\n-••\n" {
+		t.Errorf("Read\n%vfrom runs of rows", b)
+	}
+	for _, rle := range []string{"3o", "x = 3, y = 1, rule = X\n3o!", "3q?!"} {
+		if _, _, err := ReadRLE(strings.NewReader(rle)); err == nil {
+			t.Errorf("Read invalid RLE %q", rle)
+		}
+	}
+}
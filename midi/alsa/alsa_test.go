@@ -0,0 +1,149 @@
+//go:build linux
+// +build linux
+
+package alsa
+
+import (
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestStructSizes(t *testing.T) {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		t.Skip("The sizes tested are of 64-bit systems.")
+	}
+	sizes := map[string][2]uintptr{
+		"snd_seq_client_info":    {unsafe.Sizeof(clientInfo{}), 188},
+		"snd_seq_port_info":      {unsafe.Sizeof(portInfo{}), 168},
+		"snd_seq_port_subscribe": {unsafe.Sizeof(portSubscribe{}), 80},
+	}
+	for name, size := range sizes {
+		if size[0] != size[1] {
+			t.Errorf("The size of %v is %v instead of %v", name, size[0], size[1])
+		}
+	}
+	if expected := uintptr(0xC0A85352); iocQueryNextPort != expected {
+		t.Errorf("The ioctl request to query ports is %#x instead of %#x", iocQueryNextPort, expected)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	messages := []midi.Message{
+		midi.NoteOn{Channel: 2, Key: 60, Velocity: 100},
+		midi.NoteOff{Channel: 15, Key: 127, Velocity: 0},
+		midi.ControlChange{Channel: 0, ID: 7, Value: 64},
+	}
+	for _, m := range messages {
+		e, ok := newEvent(m.Uint32())
+		if !ok {
+			t.Fatalf("Could not create an event of %+v", m)
+		}
+		e.source = Addr{128, 1}
+		decoded, size := decodeEvent(e.encode())
+		if size != eventSize {
+			t.Errorf("Decoded an event of %v bytes instead of %v", size, eventSize)
+		}
+		if decoded.source != e.source || decoded.queue != queueDirect {
+			t.Errorf("Decoded %+v instead of %+v", decoded, e)
+		}
+		if actual, ok := decoded.message(); !ok || actual != m.Uint32() {
+			t.Errorf("Decoded message %#x instead of %#x", actual, m.Uint32())
+		}
+	}
+	pitchBend := uint32(0xE3 | 0x00<<8 | 0x40<<16) // The center of the pitch wheel.
+	e, _ := newEvent(pitchBend)
+	if e.data[8] != 0 || e.data[9] != 0 {
+		t.Errorf("Encoded the center of the pitch wheel as %v", e.data[8:12])
+	}
+	if actual, _ := e.message(); actual != pitchBend {
+		t.Errorf("Decoded pitch bend %#x instead of %#x", actual, pitchBend)
+	}
+	for status, kind := range map[uint32]uint8{0xF8: eventClock, 0xFA: eventStart, 0xFB: eventContinue, 0xFC: eventStop} {
+		e, ok := newEvent(status)
+		if !ok || e.kind != kind {
+			t.Errorf("Created an event of kind %v instead of %v for %#x", e.kind, kind, status)
+		}
+		decoded, _ := decodeEvent(e.encode())
+		if actual, ok := decoded.message(); !ok || actual != status {
+			t.Errorf("Decoded real-time message %#x instead of %#x", actual, status)
+		}
+	}
+	if _, ok := newEvent(0xF2); ok {
+		t.Errorf("Created an event of a system common message")
+	}
+}
+
+func TestVirtualPorts(t *testing.T) {
+	if _, err := os.Stat(SequencerPath); err != nil {
+		t.Skip("There is no ALSA sequencer on this system.")
+	}
+	b := NewBackend("audio test", "Virtual 1", "Virtual 2")
+	defer b.Close()
+	devices, err := midi.GetBackendDevices(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Subscribe("audio test:Virtual 1", "audio test:Virtual 2"); err != nil {
+		t.Fatal(err)
+	}
+	v1, v2 := devices["audio test:Virtual 1"], devices["audio test:Virtual 2"]
+	for _, v := range []midi.SystemDevice{v1, v2} {
+		if err := v.Open(); err != nil {
+			t.Fatal(err)
+		}
+		go v.Connect()
+		defer v.Close()
+	}
+
+	expected := midi.NoteOn{Channel: 0, Key: 64, Velocity: 127}
+	v1.In <- expected
+	actual := <-v2.Out
+	if n, ok := actual.(midi.NoteOn); !ok || n.Channel != expected.Channel ||
+		n.Key != expected.Key || n.Velocity != expected.Velocity {
+		t.Errorf("Received %+v through virtual ports instead of %+v", actual, expected)
+	}
+}
+
+func TestReceiveErrors(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBackend("audio test")
+	b.seq = &Sequencer{file: r}
+	defer b.Close()
+	i := &input{backend: b, seq: b.seq, messages: make(chan received, inputBufferSize)}
+	b.inputs[0] = map[*input]bool{i: true}
+	go b.receive(b.seq)
+
+	expected := midi.NoteOn{Channel: 3, Key: 48, Velocity: 90}
+	e, _ := newEvent(expected.Uint32())
+	w.Write(e.encode())
+	w.Close()
+	deadline := time.Now().Add(time.Second)
+	read := 0
+	for {
+		dataAvailable, err := i.Poll()
+		if dataAvailable {
+			if actual, _, _ := i.Read(); actual != expected.Uint32() {
+				t.Errorf("Received %#x instead of %#x", actual, expected.Uint32())
+			}
+			read++
+			continue
+		}
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Polling returned no error once the sequencer could not be read from")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if read != 1 {
+		t.Errorf("Received %v messages before the error instead of 1", read)
+	}
+}
@@ -0,0 +1,355 @@
+//go:build linux
+// +build linux
+
+package alsa
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// The number of messages an input stream buffers before dropping any.
+const inputBufferSize = 512
+
+var errClosed = errors.New("The stream was closed.")
+
+// Implements midi.Backend with a client of the ALSA sequencer. Unlike portmidi,
+// the backend's devices are the ports of the sequencer, named by the names of
+// their client and port as "Client:Port" so that ports of the same name are
+// distinct, and the
+// backend may create virtual ports that other applications can connect to,
+// which are devices of the backend like any other.
+//
+// The backend is selected with midi.GetBackendDevices, or by setting
+// midi.DefaultBackend before calling midi.GetDevices.
+type Backend struct {
+	ClientName   string   // The name of the client, as other applications see it.
+	VirtualPorts []string // The names of the virtual ports that are created by the client.
+	mu           sync.Mutex
+	seq          *Sequencer
+	virtual      map[string]Addr
+	streams      []midi.StreamInfo
+	addrs        []Addr // The port of each stream, by ID.
+	inputs       map[uint8]map[*input]bool
+	err          error // Why the sequencer can no longer be read from.
+}
+
+// Creates a new backend of a sequencer client with virtual ports of the specified names.
+func NewBackend(clientName string, virtualPorts ...string) *Backend {
+	return &Backend{
+		ClientName:   clientName,
+		VirtualPorts: virtualPorts,
+		virtual:      make(map[string]Addr),
+		inputs:       make(map[uint8]map[*input]bool),
+	}
+}
+
+// Opens the backend's sequencer client and its virtual ports if they are not
+// yet open, then finds the ports of the sequencer.
+func (b *Backend) Initialize() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seq == nil {
+		seq, err := Open(b.ClientName)
+		if err != nil {
+			return err
+		}
+		b.seq = seq
+		go b.receive(seq)
+	}
+	for _, name := range b.VirtualPorts {
+		if _, ok := b.virtual[name]; ok {
+			continue
+		}
+		a, err := b.seq.CreatePort(name, CapRead|CapSubsRead|CapWrite|CapSubsWrite)
+		if err != nil {
+			return err
+		}
+		b.virtual[name] = a
+	}
+	ports, err := b.seq.Ports()
+	if err != nil {
+		return err
+	}
+	b.streams, b.addrs = nil, nil
+	add := func(a Addr, name string, isInput bool) {
+		b.streams = append(b.streams, midi.StreamInfo{
+			ID:       len(b.streams),
+			Name:     name,
+			IsInput:  isInput,
+			IsOutput: !isInput,
+		})
+		b.addrs = append(b.addrs, a)
+	}
+	for _, p := range ports {
+		if p.Client == systemClient || p.Client == b.seq.Client() {
+			continue
+		}
+		if p.Readable() {
+			add(p.Addr, p.ClientName+":"+p.Name, true)
+		}
+		if p.Writable() {
+			add(p.Addr, p.ClientName+":"+p.Name, false)
+		}
+	}
+	for _, name := range b.VirtualPorts {
+		add(b.virtual[name], b.ClientName+":"+name, true)
+		add(b.virtual[name], b.ClientName+":"+name, false)
+	}
+	return nil
+}
+
+// Finds the ports of the sequencer again. Open streams remain usable, other
+// than those of ports that were removed.
+func (b *Backend) Refresh() error {
+	return b.Initialize()
+}
+
+// Forgets the ports found when the backend was initialized. The sequencer client
+// and its virtual ports are kept open, so that other applications stay connected
+// to them while the backend is reinitialized, until the backend is closed.
+func (b *Backend) Terminate() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streams, b.addrs = nil, nil
+	return nil
+}
+
+// Closes the backend's sequencer client, removing its virtual ports.
+func (b *Backend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seq == nil {
+		return nil
+	}
+	err := b.seq.Close()
+	b.seq = nil
+	b.err = nil
+	b.virtual = make(map[string]Addr)
+	b.streams, b.addrs = nil, nil
+	return err
+}
+
+func (b *Backend) Streams() []midi.StreamInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]midi.StreamInfo{}, b.streams...)
+}
+
+// Returns the port of a stream, if the stream is of the type specified.
+// The backend's mutex must be held.
+func (b *Backend) addr(id int, isInput bool) (Addr, error) {
+	if b.seq == nil || id < 0 || id >= len(b.streams) || b.streams[id].IsInput != isInput {
+		return Addr{}, errors.New("Invalid stream ID.")
+	}
+	return b.addrs[id], nil
+}
+
+// Opens a stream of the MIDI data sent by a port. Other than for virtual ports,
+// a private port of the client is subscribed to the port to receive its data.
+func (b *Backend) OpenInput(id int) (midi.InputStream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, err := b.addr(id, true)
+	if err != nil {
+		return nil, err
+	}
+	i := &input{backend: b, seq: b.seq, messages: make(chan received, inputBufferSize)}
+	if a.Client == b.seq.Client() {
+		i.port = a
+	} else {
+		if i.port, err = b.seq.CreatePort("", CapWrite|CapNoExport); err != nil {
+			return nil, err
+		}
+		if err := b.seq.Subscribe(a, i.port); err != nil {
+			b.seq.DeletePort(i.port)
+			return nil, err
+		}
+		i.subscribed = &a
+	}
+	if b.inputs[i.port.Port] == nil {
+		b.inputs[i.port.Port] = make(map[*input]bool)
+	}
+	b.inputs[i.port.Port][i] = true
+	return i, nil
+}
+
+// Opens a stream of MIDI data to a port. Other than for virtual ports,
+// the port is subscribed to a private port of the client that sends the data.
+func (b *Backend) OpenOutput(id int) (midi.OutputStream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, err := b.addr(id, false)
+	if err != nil {
+		return nil, err
+	}
+	o := &output{backend: b, seq: b.seq}
+	if a.Client == b.seq.Client() {
+		o.port = a
+		return o, nil
+	}
+	if o.port, err = b.seq.CreatePort("", CapRead|CapNoExport); err != nil {
+		return nil, err
+	}
+	if err := b.seq.Subscribe(o.port, a); err != nil {
+		b.seq.DeletePort(o.port)
+		return nil, err
+	}
+	o.subscribed = &a
+	return o, nil
+}
+
+// Subscribes a port to receive the MIDI data sent by another, both by the names
+// of the devices of the backend, as with aconnect. Subscriptions between other
+// clients' ports outlast the backend.
+func (b *Backend) Subscribe(sender, dest string) error {
+	return b.subscription(sender, dest, true)
+}
+
+// Removes a subscription made by Subscribe.
+func (b *Backend) Unsubscribe(sender, dest string) error {
+	return b.subscription(sender, dest, false)
+}
+
+func (b *Backend) subscription(sender, dest string, subscribe bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seq == nil {
+		return errors.New("The backend is not initialized.")
+	}
+	find := func(name string, isInput bool) (Addr, error) {
+		for i, s := range b.streams {
+			if s.Name == name && s.IsInput == isInput {
+				return b.addrs[i], nil
+			}
+		}
+		return Addr{}, errors.New("No port named " + name + ".")
+	}
+	from, err := find(sender, true)
+	if err != nil {
+		return err
+	}
+	to, err := find(dest, false)
+	if err != nil {
+		return err
+	}
+	if subscribe {
+		return b.seq.Subscribe(from, to)
+	}
+	return b.seq.Unsubscribe(from, to)
+}
+
+type received struct {
+	message   uint32
+	timestamp midi.Timestamp
+}
+
+// Receives the events sent to the client's ports until the client is closed,
+// timestamping them when they are read. Events that were lost because the
+// client's buffer overflowed, or that were truncated, are skipped. Any other
+// error stops the events from being received, and is returned by the input
+// streams of the client when they are polled.
+func (b *Backend) receive(seq *Sequencer) {
+	for {
+		events, err := seq.Read()
+		now := midi.Now()
+		b.mu.Lock()
+		for _, e := range events {
+			for i := range b.inputs[e.Dest.Port] {
+				select {
+				case i.messages <- received{e.Message, now}:
+				default:
+				}
+			}
+		}
+		closed := b.seq != seq
+		recoverable := err == nil || err == errShortRead || errors.Is(err, syscall.ENOSPC)
+		if !closed && !recoverable {
+			b.err = err
+		}
+		b.mu.Unlock()
+		if closed || !recoverable {
+			return
+		}
+	}
+}
+
+type input struct {
+	backend    *Backend
+	seq        *Sequencer
+	port       Addr
+	subscribed *Addr // The port that is subscribed to, unless the port is virtual.
+	messages   chan received
+	closed     bool
+}
+
+func (i *input) Poll() (bool, error) {
+	i.backend.mu.Lock()
+	defer i.backend.mu.Unlock()
+	if i.closed || i.backend.seq != i.seq {
+		return false, errClosed
+	}
+	if len(i.messages) > 0 {
+		return true, nil
+	}
+	return false, i.backend.err
+}
+
+func (i *input) Read() (uint32, midi.Timestamp, error) {
+	select {
+	case r := <-i.messages:
+		return r.message, r.timestamp, nil
+	default:
+		return 0, 0, nil
+	}
+}
+
+func (i *input) Close() error {
+	i.backend.mu.Lock()
+	defer i.backend.mu.Unlock()
+	if i.closed {
+		return nil
+	}
+	i.closed = true
+	delete(i.backend.inputs[i.port.Port], i)
+	if i.subscribed == nil || i.backend.seq != i.seq {
+		return nil
+	}
+	i.seq.Unsubscribe(*i.subscribed, i.port)
+	return i.seq.DeletePort(i.port)
+}
+
+type output struct {
+	backend    *Backend
+	seq        *Sequencer
+	port       Addr
+	subscribed *Addr // The port that is subscribed to, unless the port is virtual.
+	closed     bool
+}
+
+// Sends a message to the port immediately, regardless of its Timestamp.
+func (o *output) Write(m midi.Message) error {
+	o.backend.mu.Lock()
+	defer o.backend.mu.Unlock()
+	if o.closed || o.backend.seq != o.seq {
+		return errClosed
+	}
+	return o.seq.Write(o.port.Port, m.Uint32())
+}
+
+func (o *output) Close() error {
+	o.backend.mu.Lock()
+	defer o.backend.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	if o.subscribed == nil || o.backend.seq != o.seq {
+		return nil
+	}
+	o.seq.Unsubscribe(o.port, *o.subscribed)
+	return o.seq.DeletePort(o.port)
+}
@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package alsa
+
+import "encoding/binary"
+
+// The size of struct snd_seq_event, not including the data of variable length events.
+const eventSize = 28
+
+// Types of events, as per snd_seq_event_type of the sequencer.
+const (
+	eventNoteOn     = 6
+	eventNoteOff    = 7
+	eventKeyPress   = 8
+	eventController = 10
+	eventPgmChange  = 11
+	eventChanPress  = 12
+	eventPitchBend  = 13
+	eventStart      = 30
+	eventContinue   = 31
+	eventStop       = 32
+	eventClock      = 36
+)
+
+// The types of the events of system real-time messages, by status.
+var realtimeEvents = map[byte]uint8{
+	0xF8: eventClock,
+	0xFA: eventStart,
+	0xFB: eventContinue,
+	0xFC: eventStop,
+}
+
+// Flags of the length of an event's data.
+const (
+	eventLengthMask     = 3 << 2
+	eventLengthVariable = 1 << 2
+)
+
+// The fields of struct snd_seq_event that are used for MIDI channel and real-time messages.
+// Events are encoded in the byte order of the little endian systems ALSA is run on.
+type event struct {
+	kind   uint8
+	flags  uint8
+	queue  uint8
+	source Addr
+	dest   Addr
+	data   [12]byte
+}
+
+// Decodes an event, returning it and its size including any variable length data.
+func decodeEvent(b []byte) (e event, size int) {
+	e.kind = b[0]
+	e.flags = b[1]
+	e.queue = b[3]
+	e.source = Addr{b[12], b[13]}
+	e.dest = Addr{b[14], b[15]}
+	copy(e.data[:], b[16:eventSize])
+	size = eventSize
+	if e.flags&eventLengthMask == eventLengthVariable {
+		size += int(binary.LittleEndian.Uint32(e.data[0:4]))
+	}
+	return e, size
+}
+
+func (e event) encode() []byte {
+	b := make([]byte, eventSize)
+	b[0] = e.kind
+	b[1] = e.flags
+	b[3] = e.queue
+	b[12], b[13] = e.source.Client, e.source.Port
+	b[14], b[15] = e.dest.Client, e.dest.Port
+	copy(b[16:], e.data[:])
+	return b
+}
+
+// Creates an event of a MIDI channel or system real-time message, whose status
+// is in its lowest byte, to be delivered directly.
+func newEvent(message uint32) (e event, ok bool) {
+	status := byte(message)
+	if kind, ok := realtimeEvents[status]; ok {
+		return event{kind: kind, queue: queueDirect}, true
+	}
+	channel := status & 0x0F
+	data1, data2 := byte(message>>8)&0x7F, byte(message>>16)&0x7F
+	e.queue = queueDirect
+	e.data[0] = channel
+	switch status & 0xF0 {
+	case 0x80:
+		e.kind = eventNoteOff
+		e.data[1], e.data[2] = data1, data2
+	case 0x90:
+		e.kind = eventNoteOn
+		e.data[1], e.data[2] = data1, data2
+	case 0xA0:
+		e.kind = eventKeyPress
+		e.data[1], e.data[2] = data1, data2
+	case 0xB0:
+		e.kind = eventController
+		binary.LittleEndian.PutUint32(e.data[4:8], uint32(data1))
+		binary.LittleEndian.PutUint32(e.data[8:12], uint32(data2))
+	case 0xC0:
+		e.kind = eventPgmChange
+		binary.LittleEndian.PutUint32(e.data[8:12], uint32(data1))
+	case 0xD0:
+		e.kind = eventChanPress
+		binary.LittleEndian.PutUint32(e.data[8:12], uint32(data1))
+	case 0xE0:
+		e.kind = eventPitchBend
+		value := int32(data1) | int32(data2)<<7 - 8192
+		binary.LittleEndian.PutUint32(e.data[8:12], uint32(value))
+	default:
+		return event{}, false
+	}
+	return e, true
+}
+
+// Returns the MIDI channel or system real-time message of an event, with its
+// status in the lowest byte.
+func (e event) message() (uint32, bool) {
+	channel := uint32(e.data[0] & 0x0F)
+	note := func(status uint32) uint32 {
+		return status | channel | uint32(e.data[1]&0x7F)<<8 | uint32(e.data[2]&0x7F)<<16
+	}
+	param := binary.LittleEndian.Uint32(e.data[4:8])
+	value := int32(binary.LittleEndian.Uint32(e.data[8:12]))
+	switch e.kind {
+	case eventNoteOff:
+		return note(0x80), true
+	case eventNoteOn:
+		return note(0x90), true
+	case eventKeyPress:
+		return note(0xA0), true
+	case eventController:
+		return 0xB0 | channel | (param&0x7F)<<8 | uint32(value&0x7F)<<16, true
+	case eventPgmChange:
+		return 0xC0 | channel | uint32(value&0x7F)<<8, true
+	case eventChanPress:
+		return 0xD0 | channel | uint32(value&0x7F)<<8, true
+	case eventPitchBend:
+		v := uint32(value + 8192)
+		return 0xE0 | channel | (v&0x7F)<<8 | (v>>7&0x7F)<<16, true
+	}
+	for status, kind := range realtimeEvents {
+		if e.kind == kind {
+			return uint32(status), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,306 @@
+//go:build linux
+// +build linux
+
+// Package alsa provides a MIDI backend of the ALSA sequencer of Linux,
+// using the ioctl interface of /dev/snd/seq rather than any C library.
+package alsa
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The device file of the ALSA sequencer.
+const SequencerPath = "/dev/snd/seq"
+
+// Special client, port and queue numbers of the sequencer.
+const (
+	systemClient        = 0   // The kernel's client of the timer and announce ports.
+	addressSubscribers  = 254 // Sends an event to all subscribers of its source port.
+	addressUnknown      = 253
+	queueDirect         = 253 // Delivers an event immediately instead of through a queue.
+	clientNameLength    = 64
+	portNameLength      = 64
+	maxVariableDataSize = 1 << 16
+)
+
+// Capabilities of a port.
+const (
+	CapRead      = 1 << 0 // Events may be read from the port.
+	CapWrite     = 1 << 1 // Events may be written to the port.
+	CapSubsRead  = 1 << 5 // Other clients may subscribe to read from the port.
+	CapSubsWrite = 1 << 6 // Other clients may subscribe to write to the port.
+	CapNoExport  = 1 << 7 // Other clients may not subscribe to the port at all.
+)
+
+// Types of a port.
+const (
+	TypeMIDIGeneric = 1 << 1
+	TypeApplication = 1 << 20
+)
+
+// An Addr is the address of a port of a client of the sequencer.
+type Addr struct {
+	Client uint8
+	Port   uint8
+}
+
+// The layout of struct snd_seq_client_info.
+type clientInfo struct {
+	Client          int32
+	Type            int32
+	Name            [clientNameLength]byte
+	Filter          uint32
+	MulticastFilter [8]byte
+	EventFilter     [32]byte
+	NumPorts        int32
+	EventLost       int32
+	Card            int32
+	Pid             int32
+	Reserved        [56]byte
+}
+
+// The layout of struct snd_seq_port_info.
+type portInfo struct {
+	Addr         Addr
+	Name         [portNameLength]byte
+	_            [2]byte
+	Capability   uint32
+	Type         uint32
+	MIDIChannels int32
+	MIDIVoices   int32
+	SynthVoices  int32
+	ReadUse      int32
+	WriteUse     int32
+	Kernel       uintptr
+	Flags        uint32
+	TimeQueue    uint8
+	Reserved     [59]byte
+}
+
+// The layout of struct snd_seq_port_subscribe.
+type portSubscribe struct {
+	Sender   Addr
+	Dest     Addr
+	Voices   uint32
+	Flags    uint32
+	Queue    uint8
+	_        [3]byte
+	Reserved [64]byte
+}
+
+const (
+	iocWrite = 1
+	iocRead  = 2
+)
+
+// Returns an ioctl request number of the sequencer, as per _IOC of linux/ioctl.h.
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'S'<<8 | nr
+}
+
+var (
+	iocClientID        = ioc(iocRead, 0x01, 4)
+	iocSetClientInfo   = ioc(iocWrite, 0x11, unsafe.Sizeof(clientInfo{}))
+	iocCreatePort      = ioc(iocRead|iocWrite, 0x20, unsafe.Sizeof(portInfo{}))
+	iocDeletePort      = ioc(iocWrite, 0x21, unsafe.Sizeof(portInfo{}))
+	iocSubscribePort   = ioc(iocWrite, 0x30, unsafe.Sizeof(portSubscribe{}))
+	iocUnsubscribePort = ioc(iocWrite, 0x31, unsafe.Sizeof(portSubscribe{}))
+	iocQueryNextClient = ioc(iocRead|iocWrite, 0x51, unsafe.Sizeof(clientInfo{}))
+	iocQueryNextPort   = ioc(iocRead|iocWrite, 0x52, unsafe.Sizeof(portInfo{}))
+)
+
+// A Sequencer is a client of the ALSA sequencer.
+type Sequencer struct {
+	file   *os.File
+	client uint8
+}
+
+// Opens a new client of the sequencer, named as other applications will see it.
+func Open(clientName string) (*Sequencer, error) {
+	f, err := os.OpenFile(SequencerPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sequencer{file: f}
+	var id int32
+	if err := s.ioctl(iocClientID, unsafe.Pointer(&id)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.client = uint8(id)
+	info := clientInfo{Client: id}
+	copy(info.Name[:clientNameLength-1], clientName)
+	if err := s.ioctl(iocSetClientInfo, unsafe.Pointer(&info)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Sequencer) ioctl(request uintptr, arg unsafe.Pointer) error {
+	c, err := s.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = c.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Returns the client number of the sequencer.
+func (s *Sequencer) Client() uint8 {
+	return s.client
+}
+
+// Closes the client, removing its ports and their subscriptions.
+func (s *Sequencer) Close() error {
+	return s.file.Close()
+}
+
+// Describes a port of a client of the sequencer.
+type PortInfo struct {
+	Addr
+	ClientName string
+	Name       string
+	Capability uint32
+}
+
+// Returns true if events may be subscribed to be read from the port.
+func (p PortInfo) Readable() bool {
+	return p.Capability&(CapRead|CapSubsRead) == CapRead|CapSubsRead && p.Capability&CapNoExport == 0
+}
+
+// Returns true if events may be subscribed to be written to the port.
+func (p PortInfo) Writable() bool {
+	return p.Capability&(CapWrite|CapSubsWrite) == CapWrite|CapSubsWrite && p.Capability&CapNoExport == 0
+}
+
+// Returns every port of every client of the sequencer.
+func (s *Sequencer) Ports() ([]PortInfo, error) {
+	var ports []PortInfo
+	client := clientInfo{Client: -1}
+	for {
+		if err := s.ioctl(iocQueryNextClient, unsafe.Pointer(&client)); err != nil {
+			if err == syscall.ENOENT {
+				return ports, nil
+			}
+			return nil, err
+		}
+		port := portInfo{Addr: Addr{Client: uint8(client.Client), Port: 0xFF}}
+		for {
+			if err := s.ioctl(iocQueryNextPort, unsafe.Pointer(&port)); err != nil {
+				if err == syscall.ENOENT {
+					break
+				}
+				return nil, err
+			}
+			ports = append(ports, PortInfo{
+				Addr:       port.Addr,
+				ClientName: cString(client.Name[:]),
+				Name:       cString(port.Name[:]),
+				Capability: port.Capability,
+			})
+		}
+	}
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// Creates a port of the sequencer's client with capabilities of the Cap constants.
+func (s *Sequencer) CreatePort(name string, capability uint32) (Addr, error) {
+	info := portInfo{
+		Addr:         Addr{Client: s.client},
+		Capability:   capability,
+		Type:         TypeMIDIGeneric | TypeApplication,
+		MIDIChannels: 16,
+	}
+	copy(info.Name[:portNameLength-1], name)
+	if err := s.ioctl(iocCreatePort, unsafe.Pointer(&info)); err != nil {
+		return Addr{}, err
+	}
+	return info.Addr, nil
+}
+
+// Deletes a port of the sequencer's client.
+func (s *Sequencer) DeletePort(a Addr) error {
+	info := portInfo{Addr: a}
+	return s.ioctl(iocDeletePort, unsafe.Pointer(&info))
+}
+
+// Subscribes a port to receive the events sent by another, as with aconnect.
+func (s *Sequencer) Subscribe(sender, dest Addr) error {
+	sub := portSubscribe{Sender: sender, Dest: dest}
+	return s.ioctl(iocSubscribePort, unsafe.Pointer(&sub))
+}
+
+// Removes a subscription made by Subscribe.
+func (s *Sequencer) Unsubscribe(sender, dest Addr) error {
+	sub := portSubscribe{Sender: sender, Dest: dest}
+	return s.ioctl(iocUnsubscribePort, unsafe.Pointer(&sub))
+}
+
+// Sends a MIDI message from a port of the sequencer's client to the subscribers of the port.
+// Messages that are neither channel messages nor system real-time messages are not sent.
+func (s *Sequencer) Write(source uint8, message uint32) error {
+	e, ok := newEvent(message)
+	if !ok {
+		return nil
+	}
+	e.source = Addr{s.client, source}
+	e.dest = Addr{addressSubscribers, addressUnknown}
+	_, err := s.file.Write(e.encode())
+	return err
+}
+
+// An Event is a MIDI message received by a port of the sequencer's client.
+type Event struct {
+	Source  Addr
+	Dest    Addr
+	Message uint32
+}
+
+var errShortRead = errors.New("Read a truncated event from the sequencer.")
+
+// Reads the events that are received by the ports of the sequencer's client,
+// skipping any that are not channel messages. Read blocks until there are events
+// to read or the sequencer is closed.
+func (s *Sequencer) Read() ([]Event, error) {
+	b := make([]byte, eventSize*64+maxVariableDataSize)
+	n, err := s.file.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for b = b[:n]; len(b) > 0; {
+		if len(b) < eventSize {
+			return events, errShortRead
+		}
+		e, size := decodeEvent(b)
+		if size > len(b) {
+			return events, errShortRead
+		}
+		b = b[size:]
+		if m, ok := e.message(); ok {
+			events = append(events, Event{Source: e.source, Dest: e.dest, Message: m})
+		}
+	}
+	return events, nil
+}
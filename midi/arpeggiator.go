@@ -0,0 +1,327 @@
+package midi
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An ArpeggioMode is the order in which an Arpeggiator plays the notes held.
+type ArpeggioMode int
+
+const (
+	ArpeggioUp       ArpeggioMode = iota // From the lowest note to the highest.
+	ArpeggioDown                         // From the highest note to the lowest.
+	ArpeggioUpDown                       // Up and then down, without repeating the highest and lowest notes.
+	ArpeggioRandom                       // Any note at random.
+	ArpeggioAsPlayed                     // In the order that the notes were played.
+	ArpeggioChord                        // All of the notes at once.
+)
+
+// Returns the steps of an arpeggio of notes repeated over a number of octaves,
+// as the notes that each step plays. Random arpeggios are returned in order, and
+// picked from at random.
+func arpeggio(mode ArpeggioMode, notes []NoteOn, octaves int) (steps [][]NoteOn) {
+	ordered := append([]NoteOn(nil), notes...)
+	if mode != ArpeggioAsPlayed {
+		sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Key < ordered[j].Key })
+	}
+	var keys []NoteOn
+	for octave := 0; octave < octaves; octave++ {
+		for _, n := range ordered {
+			if n.Key += octave * Octave; n.Key <= 127 {
+				keys = append(keys, n)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	switch mode {
+	case ArpeggioChord:
+		return [][]NoteOn{keys}
+	case ArpeggioDown:
+		for i := len(keys) - 1; i >= 0; i-- {
+			steps = append(steps, keys[i:i+1])
+		}
+		return steps
+	}
+	for i := range keys {
+		steps = append(steps, keys[i:i+1])
+	}
+	if mode == ArpeggioUpDown {
+		for i := len(keys) - 2; i > 0; i-- {
+			steps = append(steps, keys[i:i+1])
+		}
+	}
+	return steps
+}
+
+// An Arpeggiator is a device that plays the notes held on its In channel one
+// after another out of its Out channel, at a rate of a division of notes by a
+// MIDI clock that it keeps (from the first note held) or follows. Messages other
+// than notes and the clock are passed through.
+//
+// With latch set, the notes are played after they are released, until other
+// notes are held.
+type Arpeggiator struct {
+	*ThruDevice
+	mu sync.Mutex
+	midiClock
+	mode    ArpeggioMode
+	octaves int
+	rate    int     // In MIDI clocks.
+	gate    float64 // The length of notes, as a fraction of a step.
+	latch   bool
+	held    []NoteOn // The notes held down, in the order they were played.
+	notes   []NoteOn // The notes arpeggiated: those held, or those latched.
+	step    int      // The number of the next step of the arpeggio.
+	offs    []scheduledOff
+	rand    *rand.Rand
+	changed chan bool
+}
+
+// Creates a new arpeggiator, which plays up an octave of the notes held, as
+// sixteenth notes of half a step at 120 BPM.
+func NewArpeggiator() *Arpeggiator {
+	return &Arpeggiator{
+		ThruDevice: NewThruDevice(),
+		midiClock:  newMIDIClock(),
+		octaves:    1,
+		rate:       SixteenthNote,
+		gate:       0.5,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		changed:    make(chan bool, 1),
+	}
+}
+
+// Plays the notes held while the clock is playing, and passes through the other
+// messages received, until the arpeggiator is closed.
+func (a *Arpeggiator) Connect() {
+	for {
+		a.mu.Lock()
+		var messages []Message
+		if !a.playing {
+			messages = a.release(Now())
+		}
+		var due <-chan time.Time
+		timer := a.timer()
+		if timer != nil {
+			due = timer.C
+		}
+		a.mu.Unlock()
+		if !a.send(messages) {
+			return
+		}
+
+		select {
+		case <-due:
+			a.mu.Lock()
+			messages = a.tick(TimestampOf(a.at(a.count)))
+			a.mu.Unlock()
+		case m := <-a.In:
+			messages = a.receive(m)
+		case <-a.changed:
+			messages = nil
+		case <-a.disconnect:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !a.send(messages) {
+			return
+		}
+	}
+}
+
+func (a *Arpeggiator) send(messages []Message) bool {
+	for _, m := range messages {
+		select {
+		case a.Out <- m:
+		case <-a.disconnect:
+			return false
+		}
+	}
+	return true
+}
+
+// Follows a note or clock received, returning the messages that it plays or
+// the message itself if it is passed through.
+func (a *Arpeggiator) receive(m Message) []Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch n := m.(type) {
+	case NoteOn:
+		if n.Velocity == 0 {
+			a.releaseKey(n.Key)
+			return nil
+		}
+		if a.latch && len(a.held) == 0 {
+			a.notes, a.step = nil, 0
+		}
+		a.held = append(without(a.held, n.Key), n)
+		a.notes = append(without(a.notes, n.Key), n)
+		if !a.follow && !a.playing {
+			a.count = 0
+			a.start()
+		}
+	case NoteOff:
+		a.releaseKey(n.Key)
+	case Realtime:
+		if at, ok := a.midiClock.receive(n); ok {
+			return a.tick(at)
+		}
+	default:
+		return []Message{m}
+	}
+	return nil
+}
+
+// Returns notes without the note of a key.
+func without(notes []NoteOn, key int) []NoteOn {
+	kept := notes[:0]
+	for _, n := range notes {
+		if n.Key != key {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// Releases the note of a key, which stops being played unless it is latched.
+// The arpeggiator must be locked.
+func (a *Arpeggiator) releaseKey(key int) {
+	a.held = without(a.held, key)
+	if !a.latch {
+		a.notes = without(a.notes, key)
+	}
+	if len(a.notes) == 0 {
+		a.step = 0
+	}
+}
+
+// Plays a clock, returning the note offs and notes that are due at it.
+// The arpeggiator must be locked.
+func (a *Arpeggiator) tick(at Timestamp) (messages []Message) {
+	i := 0
+	for _, off := range a.offs {
+		if off.clock <= a.count {
+			off.Time = at
+			messages = append(messages, off.NoteOff)
+			continue
+		}
+		a.offs[i] = off
+		i++
+	}
+	a.offs = a.offs[:i]
+	if a.count%int64(a.rate) == 0 {
+		if steps := arpeggio(a.mode, a.notes, a.octaves); len(steps) > 0 {
+			notes := steps[a.step%len(steps)]
+			if a.mode == ArpeggioRandom {
+				notes = steps[a.rand.Intn(len(steps))]
+			}
+			a.step++
+			gate := int64(math.Round(a.gate * float64(a.rate)))
+			if gate < 1 {
+				gate = 1
+			}
+			for _, n := range notes {
+				messages = append(messages, NoteOn{n.Channel, n.Key, n.Velocity, at})
+				a.offs = append(a.offs, scheduledOff{a.count + gate, NoteOff{n.Channel, n.Key, 0, 0}})
+			}
+		}
+	}
+	a.count++
+	if !a.follow && len(a.notes) == 0 && len(a.offs) == 0 {
+		a.playing = false // Until another note is held.
+	}
+	return messages
+}
+
+// Returns note offs for the notes that are sounding. The arpeggiator must be locked.
+func (a *Arpeggiator) release(at Timestamp) (offs []Message) {
+	for _, off := range a.offs {
+		off.Time = at
+		offs = append(offs, off.NoteOff)
+	}
+	a.offs = nil
+	return offs
+}
+
+// Signals the Connect loop that playback was changed. The arpeggiator must be locked.
+func (a *Arpeggiator) notify() {
+	select {
+	case a.changed <- true:
+	default:
+	}
+}
+
+func (a *Arpeggiator) SetMode(mode ArpeggioMode) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.mode = mode
+}
+
+// Sets the number of octaves that the notes are repeated over, from 1.
+func (a *Arpeggiator) SetOctaves(octaves int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if octaves >= 1 {
+		a.octaves = octaves
+	}
+}
+
+// Sets the length of a step in MIDI clocks, such as SixteenthNote.
+func (a *Arpeggiator) SetRate(clocks int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if clocks >= 1 {
+		a.rate = clocks
+	}
+}
+
+// Sets the length of the notes, as a fraction of a step from 0 to 1.
+func (a *Arpeggiator) SetGate(gate float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if gate > 0 && gate <= 1 {
+		a.gate = gate
+	}
+}
+
+// Sets whether notes are played after they are released. Unsetting latch stops
+// the notes that are not held.
+func (a *Arpeggiator) SetLatch(latch bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latch = latch
+	if !latch {
+		a.notes = append([]NoteOn(nil), a.held...)
+	}
+}
+
+// Sets whether the arpeggiator follows the clock it receives, or keeps its own.
+func (a *Arpeggiator) Follow(follow bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setFollow(follow)
+	a.notify()
+}
+
+// Returns the tempo of the clock kept, in beats per minute.
+func (a *Arpeggiator) Tempo() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.tempo
+}
+
+// Sets the tempo of the clock kept, in beats per minute, from the next clock.
+func (a *Arpeggiator) SetTempo(bpm float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.setTempo(bpm)
+	a.notify()
+}
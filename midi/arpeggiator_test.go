@@ -0,0 +1,107 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArpeggio(t *testing.T) {
+	notes := []NoteOn{{Key: 64}, {Key: 60}, {Key: 67}}
+	for _, c := range []struct {
+		mode    ArpeggioMode
+		octaves int
+		keys    [][]int
+	}{
+		{ArpeggioUp, 2, [][]int{{60}, {64}, {67}, {72}, {76}, {79}}},
+		{ArpeggioDown, 1, [][]int{{67}, {64}, {60}}},
+		{ArpeggioUpDown, 1, [][]int{{60}, {64}, {67}, {64}}},
+		{ArpeggioAsPlayed, 2, [][]int{{64}, {60}, {67}, {76}, {72}, {79}}},
+		{ArpeggioChord, 1, [][]int{{60, 64, 67}}},
+	} {
+		var keys [][]int
+		for _, step := range arpeggio(c.mode, notes, c.octaves) {
+			var k []int
+			for _, n := range step {
+				k = append(k, n.Key)
+			}
+			keys = append(keys, k)
+		}
+		if len(keys) != len(c.keys) {
+			t.Errorf("Arpeggiated %v instead of %v in mode %v", keys, c.keys, c.mode)
+			continue
+		}
+		for i := range keys {
+			if len(keys[i]) != len(c.keys[i]) || keys[i][0] != c.keys[i][0] || keys[i][len(keys[i])-1] != c.keys[i][len(c.keys[i])-1] {
+				t.Errorf("Arpeggiated %v instead of %v in mode %v", keys, c.keys, c.mode)
+				break
+			}
+		}
+	}
+	if steps := arpeggio(ArpeggioUp, nil, 2); steps != nil {
+		t.Errorf("Arpeggiated %v without notes", steps)
+	}
+}
+
+func TestArpeggiator(t *testing.T) {
+	a := NewArpeggiator()
+	a.Follow(true)
+	go a.Connect()
+	defer a.Close()
+
+	clock := Timestamp(0)
+	tick := func(n int) {
+		for i := 0; i < n; i++ {
+			clock++ // Clocks are stamped from 1, as 0 is unknown.
+			a.In <- Realtime{Status: TIMING_CLOCK, Time: clock}
+		}
+	}
+	go func() {
+		a.In <- Realtime{Status: START}
+		a.In <- NoteOn{Key: 64, Velocity: 90}
+		a.In <- NoteOn{Key: 60, Velocity: 100}
+		tick(12)
+		a.In <- NoteOff{Key: 60}
+		a.In <- NoteOff{Key: 64}
+		a.In <- ControlChange{ID: 64, Value: 127}
+		tick(6)
+		a.SetLatch(true)
+		a.In <- NoteOn{Key: 67, Velocity: 80}
+		a.In <- NoteOff{Key: 67}
+		tick(7)
+	}()
+
+	// Sixteenth notes of 6 clocks, of half a step, by the clock from 1.
+	expected := []Message{
+		NoteOn{0, 60, 100, 1}, NoteOff{0, 60, 0, 4}, NoteOn{0, 64, 90, 7}, NoteOff{0, 64, 0, 10},
+		ControlChange{ID: 64, Value: 127},
+		NoteOn{0, 67, 80, 19}, NoteOff{0, 67, 0, 22}, NoteOn{0, 67, 80, 25},
+	}
+	for _, e := range expected {
+		select {
+		case m := <-a.Out:
+			if m != e {
+				t.Errorf("Received %v instead of %v", m, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive %v", e)
+		}
+	}
+}
+
+func TestArpeggiatorClock(t *testing.T) {
+	a := NewArpeggiator()
+	a.SetTempo(600)
+	go a.Connect()
+	defer a.Close()
+	go func() { a.In <- NoteOn{Key: 60, Velocity: 100} }()
+	for _, on := range []bool{true, false, true} {
+		select {
+		case m := <-a.Out:
+			if _, ok := m.(NoteOn); ok != on {
+				t.Errorf("Received %v instead of a note on (%v) of 60", m, on)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not play the note held by the clock kept")
+		}
+	}
+}
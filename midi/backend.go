@@ -0,0 +1,53 @@
+package midi
+
+// A Backend provides the streams of the MIDI devices on the system, through
+// which SystemDevices transfer MIDI data. Like portmidi, a Backend enumerates
+// its streams when initialized, and is reinitialized to find any changes.
+type Backend interface {
+	Initialize() error
+	Terminate() error
+	Streams() []StreamInfo // The streams found when the backend was last initialized.
+	OpenInput(id int) (InputStream, error)
+	OpenOutput(id int) (OutputStream, error)
+}
+
+// A Refresher is a Backend that can enumerate its streams again without being
+// reinitialized, keeping the streams that are open usable, though the IDs of
+// streams may change.
+type Refresher interface {
+	Backend
+	Refresh() error
+}
+
+// Describes a stream of a Backend. An input stream is for the output port of a
+// device, and an output stream is for the input port of a device.
+type StreamInfo struct {
+	ID       int
+	Name     string // The name of the device that the stream is of.
+	IsInput  bool
+	IsOutput bool
+}
+
+// An InputStream receives MIDI data from a device.
+type InputStream interface {
+	Poll() (dataAvailable bool, err error)
+	Read() (message uint32, timestamp Timestamp, err error)
+	Close() error
+}
+
+// A SysExReader is an InputStream that receives system exclusive messages.
+// Read returns a status of 0xF0 for each, whose bytes ReadSysEx then returns.
+type SysExReader interface {
+	InputStream
+	ReadSysEx() []byte
+}
+
+// An OutputStream sends MIDI data to a device, at the Timestamp of each Message.
+type OutputStream interface {
+	Write(Message) error
+	Close() error
+}
+
+// The Backend that GetDevices gets the devices of. Importing a backend's package,
+// such as github.com/aoeu/audio/midi/portmidi, makes it the default if there is none.
+var DefaultBackend Backend
@@ -0,0 +1,105 @@
+package midi
+
+import "time"
+
+// The number of MIDI clocks per quarter note.
+const ClocksPerQuarter = 24
+
+// The lengths of divisions of notes, in MIDI clocks.
+const (
+	WholeNote        = 4 * ClocksPerQuarter
+	HalfNote         = 2 * ClocksPerQuarter
+	QuarterNote      = ClocksPerQuarter
+	EighthNote       = ClocksPerQuarter / 2
+	SixteenthNote    = ClocksPerQuarter / 4
+	ThirtySecondNote = ClocksPerQuarter / 8
+	QuarterTriplet   = ClocksPerQuarter * 2 / 3
+	EighthTriplet    = ClocksPerQuarter / 3
+	SixteenthTriplet = ClocksPerQuarter / 6
+)
+
+// A midiClock is a MIDI clock that a device either keeps at a tempo, or follows
+// from the clock, start, continue and stop messages that it receives. It must
+// be locked by the device.
+type midiClock struct {
+	tempo       float64
+	follow      bool // Set to follow the clock received, rather than keeping it.
+	playing     bool
+	count       int64     // The number of the next clock, counted from the start.
+	anchor      time.Time // When the clock of the anchor was (or will be) played.
+	anchorCount int64
+}
+
+func newMIDIClock() midiClock {
+	return midiClock{tempo: 120}
+}
+
+// Returns when a clock is due at the tempo, when it is kept.
+func (c *midiClock) at(count int64) time.Time {
+	interval := time.Duration(float64(time.Minute) / c.tempo / ClocksPerQuarter)
+	return c.anchor.Add(time.Duration(count-c.anchorCount) * interval)
+}
+
+// Returns a timer of the next clock, or nil if the clock is not kept or not playing.
+func (c *midiClock) timer() *time.Timer {
+	if !c.playing || c.follow {
+		return nil
+	}
+	return time.NewTimer(time.Until(c.at(c.count)))
+}
+
+// Follows a system real-time message, returning the time of the clock that it
+// plays, if it does.
+func (c *midiClock) receive(r Realtime) (at Timestamp, ok bool) {
+	if !c.follow {
+		return 0, false
+	}
+	switch r.Status {
+	case TIMING_CLOCK:
+		if !c.playing {
+			return 0, false
+		}
+		if at = r.Time; at == 0 {
+			at = Now()
+		}
+		return at, true
+	case START:
+		c.playing, c.count = true, 0
+	case CONTINUE:
+		c.playing = true
+	case STOP:
+		c.playing = false
+	}
+	return 0, false
+}
+
+// Starts (or continues) keeping the clock from now, returning false if the clock
+// is followed or already playing.
+func (c *midiClock) start() bool {
+	if c.playing || c.follow {
+		return false
+	}
+	c.playing = true
+	c.anchor, c.anchorCount = time.Now(), c.count
+	return true
+}
+
+// Moves the clock back to its start.
+func (c *midiClock) rewind() {
+	c.anchor = c.at(c.count)
+	c.anchorCount, c.count = 0, 0
+}
+
+// Sets the tempo of the clock kept, from the next clock.
+func (c *midiClock) setTempo(bpm float64) {
+	if bpm <= 0 {
+		return
+	}
+	c.anchor, c.anchorCount = c.at(c.count), c.count
+	c.tempo = bpm
+}
+
+// Sets whether the clock is followed, stopping it.
+func (c *midiClock) setFollow(follow bool) {
+	c.follow, c.playing = follow, false
+}
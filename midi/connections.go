@@ -1,10 +1,5 @@
 package midi
 
-// #cgo CFLAGS: -I/opt/local/include
-// #cgo LDFLAGS: -L/opt/local/lib -lportmidi
-// #include <portmidi.h>
-import "C"
-
 //import "fmt"
 
 /*
@@ -22,13 +17,13 @@ TODO: All of this could be replaced with the io package.
 // A Pipe transmits MIDI data from a device's MIDI output to another device's MIDI input.
 // Implements Connector, one to one.
 type Pipe struct {
-	From       *Device
-	To         *Device
+	From       Wirer
+	To         Wirer
 	disconnect chan bool
 }
 
 // Creates a new Pipe, opening the devices sent as parameters.
-func NewPipe(from, to *Device) *Pipe {
+func NewPipe(from, to Wirer) *Pipe {
 	return &Pipe{
 		From:       from,
 		To:         to,
@@ -58,7 +53,15 @@ func (p Pipe) Connect() {
 	go p.To.Connect()
 	for {
 		select {
-		case p.To.In <- <-p.From.Out:
+		case m, ok := <-p.From.Wire().Out:
+			if !ok {
+				return
+			}
+			select {
+			case p.To.Wire().In <- m:
+			case <-p.disconnect:
+				return
+			}
 		case <-p.disconnect:
 			return
 		}
@@ -68,13 +71,13 @@ func (p Pipe) Connect() {
 // A Router transmits MIDI data from one MIDI device to many MIDI devices.
 // Implements Connector, one to many.
 type Router struct {
-	From       Device
-	To         []Device
+	From       Wirer
+	To         []Wirer
 	disconnect chan bool
 }
 
 // Creates a new Router and opens MIDI devices sent as parameters.
-func NewRouter(from Device, to ...Device) *Router {
+func NewRouter(from Wirer, to ...Wirer) *Router {
 	return &Router{
 		From:       from,
 		To:         to,
@@ -115,13 +118,13 @@ func (r *Router) Connect() {
 	}
 	for {
 		select {
-		case e, ok := <-r.From.Out:
+		case e, ok := <-r.From.Wire().Out:
 			if !ok {
 				return
 			}
 			go func() {
 				for _, to := range r.To {
-					to.In <- e
+					to.Wire().In <- e
 				}
 			}()
 		case <-r.disconnect:
@@ -133,13 +136,13 @@ func (r *Router) Connect() {
 // A Funnel merges MIDI data from many MIDI devices and transmits the data to one MIDI device.
 // Implements Connector, many to one.
 type Funnel struct {
-	From       []*Device
-	To         *Device
+	From       []Wirer
+	To         Wirer
 	disconnect chan bool
 }
 
 // Creates a new Funnel and open's the MIDI devices sent as parameters.
-func NewFunnel(to *Device, from ...*Device) *Funnel {
+func NewFunnel(to Wirer, from ...Wirer) *Funnel {
 	return &Funnel{From: from,
 		To:         to,
 		disconnect: make(chan bool, 1),
@@ -175,7 +178,7 @@ func (f *Funnel) Connect() {
 		go func() {
 			for {
 				select {
-				case f.To.In <- <-from.Out:
+				case f.To.Wire().In <- <-from.Wire().Out:
 				case <-f.disconnect:
 					f.disconnect <- true // Send disconnect again for the next goroutine.
 					return
@@ -188,12 +191,12 @@ func (f *Funnel) Connect() {
 // A Chain connects a series of MIDI devices (like creating many, serially chained pipes).
 // Implements Connector, serially chained pipes.
 type Chain struct {
-	Devices []*Device
+	Devices []Wirer
 	pipes   []*Pipe
 }
 
 // Creates a new Chain and open's the attached devices.
-func NewChain(devices ...*Device) *Chain {
+func NewChain(devices ...Wirer) *Chain {
 	numDevices := len(devices)
 	c := Chain{devices, make([]*Pipe, numDevices-1)}
 	for i := 1; i < numDevices; i++ {
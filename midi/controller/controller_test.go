@@ -3,6 +3,7 @@ package controller
 
 import (
 	"github.com/aoeu/audio/midi"
+	_ "github.com/aoeu/audio/midi/portmidi"
 	"fmt"
 	"time"
 )
@@ -11,6 +12,7 @@ func ExampleLaunchpad() {
 	devices, _ := midi.GetDevices()
 	launchpad := NewLaunchpad(devices["Launchpad"], map[int]int{})
 	launchpad.Open()
+	go launchpad.Connect()
 
 	launchpad.Reset()
 	time.Sleep(2 * time.Second)
@@ -62,8 +64,9 @@ func ExamplLaunchpad2() {
 			119: 50},
 		nanopad)
 	iac1 := devices["IAC Driver Bus 1"]
-	pipe, _ := midi.NewPipe(launchpad, iac1)
-	go pipe.Run()
+	pipe := midi.NewPipe(launchpad, iac1)
+	pipe.Open()
+	go pipe.Connect()
 	c := make(chan bool, 1)
 	<-c
 }
@@ -74,11 +77,41 @@ func ExampleMonome() {
 		fmt.Println("Error: ", err)
 	}
 	iac1 := devices["IAC Driver Bus 1"]
-	monome, err := NewMonome()
+	monomes, _ := DiscoverMonomes(time.Second)
+	if len(monomes) == 0 {
+		fmt.Println("No monome grids were found.")
+		return
+	}
+	monome := monomes[0].Monome()
 	monome.Open()
 	go monome.Connect()
-	pipe, _ := midi.NewPipe(monome, iac1)
-	go pipe.Run()
+	pipe := midi.NewPipe(monome, iac1)
+	pipe.Open()
+	go pipe.Connect()
 	c := make(chan bool, 1)
 	<-c
 }
+
+func ExampleRGBLaunchpad() {
+	devices, _ := midi.GetDevices()
+	device, ok := FindLaunchpad(devices)
+	if !ok {
+		fmt.Println("No Launchpad was found.")
+		return
+	}
+	launchpad := NewRGBLaunchpad(device, nil)
+	if err := launchpad.Open(); err != nil {
+		fmt.Println("Error: ", err)
+		return
+	}
+	go launchpad.Connect()
+	defer launchpad.Close()
+
+	launchpad.ScrollText(launchpad.Model.Name, 5, false)
+	time.Sleep(5 * time.Second)
+	for x := 0; x < 8; x++ {
+		launchpad.Set(x, x, Color{R: uint8(32 * x), G: 0, B: 255})
+	}
+	launchpad.Pulse(8, 0, 21)
+	time.Sleep(5 * time.Second)
+}
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"sync"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// A Frame is the velocity colour codes of the LEDs of a Launchpad by row and
+// column. Row 0 is the top row of Automap buttons, of which there are 8, and rows
+// 1 through 8 are the rows of the grid, with the scene launch buttons in column 8.
+type Frame [9][9]int
+
+// Returns a frame of LEDs that are all of a colour.
+func NewFrame(color int) (f Frame) {
+	for row := range f {
+		for column := range f[row] {
+			f[row][column] = color
+		}
+	}
+	return f
+}
+
+// Layouts of the keys of a Launchpad's grid.
+const (
+	layoutXY       = 1
+	layoutDrumRack = 2
+)
+
+// An Emulator is a virtual Launchpad, used in place of one for tests and to run
+// instruments without one. It is a device that speaks the Launchpad's MIDI protocol:
+// the MIDI data sent to it lights its LEDs, including with double-buffering and
+// rapid LED updates, and its buttons are pressed with Press and Release.
+type Emulator struct {
+	*midi.Wires
+	Updates    chan bool // Receives a value when the displayed LEDs change, if it holds none.
+	mu         sync.Mutex
+	buffers    [2]Frame
+	display    int  // The buffer that is displayed.
+	update     int  // The buffer that is updated.
+	flash      bool // Whether the buffers are displayed in turn.
+	layout     int
+	rapid      int // The index of the next LED of a rapid update.
+	disconnect chan bool
+}
+
+func NewEmulator() *Emulator {
+	e := &Emulator{
+		Wires:      midi.NewWires(),
+		Updates:    make(chan bool, 1),
+		disconnect: make(chan bool, 1),
+	}
+	e.reset()
+	return e
+}
+
+func (e *Emulator) Open() error {
+	return nil
+}
+
+func (e *Emulator) Close() error {
+	e.disconnect <- true
+	return nil
+}
+
+// Lights the LEDs as per the MIDI data sent to the emulator.
+func (e *Emulator) Connect() {
+	for {
+		select {
+		case m := <-e.In:
+			e.receive(m)
+		case <-e.disconnect:
+			return
+		}
+	}
+}
+
+func (e *Emulator) Wire() *midi.Wires {
+	return e.Wires
+}
+
+// Returns the LEDs that are displayed, or while flashing, those of the buffer
+// that is displayed first.
+func (e *Emulator) LEDs() Frame {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.buffers[e.display]
+}
+
+// Returns the LEDs of a buffer, 0 or 1.
+func (e *Emulator) Buffer(i int) Frame {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.buffers[i]
+}
+
+// Returns true if the buffers are flashing, being displayed in turn.
+func (e *Emulator) Flashing() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.flash
+}
+
+// Presses the button at a row and column of a Frame, sending it out of the emulator.
+func (e *Emulator) Press(row, column int) {
+	e.Out <- e.button(row, column, 127)
+}
+
+// Releases the button at a row and column of a Frame.
+func (e *Emulator) Release(row, column int) {
+	e.Out <- e.button(row, column, 0)
+}
+
+// Returns the message of a button being pressed or released.
+func (e *Emulator) button(row, column, velocity int) midi.Message {
+	if row == 0 {
+		return midi.ControlChange{ID: 104 + column, Value: velocity, Time: midi.Now()}
+	}
+	e.mu.Lock()
+	layout := e.layout
+	e.mu.Unlock()
+	return midi.NoteOn{Key: keyOf(layout, row, column), Velocity: velocity, Time: midi.Now()}
+}
+
+// Returns the key of a button of the grid, of a row from 1 to 8, in a layout.
+func keyOf(layout, row, column int) int {
+	if layout == layoutXY {
+		return 16*(row-1) + column
+	}
+	switch {
+	case column == 8: // The scene launch buttons, from the bottom.
+		return 100 + 8 - row
+	case column < 4: // The left half, from the bottom left, 4 keys to a row.
+		return 36 + 4*(8-row) + column
+	default:
+		return 68 + 4*(8-row) + column - 4
+	}
+}
+
+// Returns the row and column of the key of a button of the grid in a layout.
+func buttonOf(layout, key int) (row, column int, ok bool) {
+	if layout == layoutXY {
+		row, column = key/16+1, key%16
+		return row, column, row <= 8 && column <= 8
+	}
+	switch {
+	case key >= 100 && key < 108:
+		return 8 - (key - 100), 8, true
+	case key >= 36 && key < 68:
+		return 8 - (key-36)/4, (key - 36) % 4, true
+	case key >= 68 && key < 100:
+		return 8 - (key-68)/4, 4 + (key-68)%4, true
+	}
+	return 0, 0, false
+}
+
+// Resets the LEDs and buffers. The emulator must be locked.
+func (e *Emulator) reset() {
+	e.buffers[0] = NewFrame(Black)
+	e.buffers[1] = e.buffers[0]
+	e.display, e.update, e.flash = 0, 0, false
+	e.layout = layoutXY
+	e.rapid = 0
+}
+
+func (e *Emulator) receive(m midi.Message) {
+	e.mu.Lock()
+	before := e.buffers[e.display]
+	switch m := m.(type) {
+	case midi.NoteOn:
+		if m.Channel == 2 { // A rapid LED update of two LEDs.
+			e.rapidUpdate(m.Key)
+			e.rapidUpdate(m.Velocity)
+			break
+		}
+		e.rapid = 0
+		if row, column, ok := buttonOf(e.layout, m.Key); ok {
+			e.set(row, column, m.Velocity)
+		}
+	case midi.NoteOff:
+		e.rapid = 0
+		if row, column, ok := buttonOf(e.layout, m.Key); ok {
+			e.set(row, column, Black)
+		}
+	case midi.ControlChange:
+		e.rapid = 0
+		switch {
+		case m.ID >= 104 && m.ID < 112:
+			e.set(0, m.ID-104, m.Value)
+		case m.ID == 0:
+			e.control(m.Value)
+		}
+	}
+	changed := before != e.buffers[e.display]
+	e.mu.Unlock()
+	if changed {
+		select {
+		case e.Updates <- true:
+		default:
+		}
+	}
+}
+
+// Sets the next LED of a rapid update: the grid from left to right and top to
+// bottom, then the scene launch buttons from the top, then the Automap buttons.
+func (e *Emulator) rapidUpdate(velocity int) {
+	switch i := e.rapid; {
+	case i < 64:
+		e.set(1+i/8, i%8, velocity)
+	case i < 72:
+		e.set(1+i-64, 8, velocity)
+	default:
+		e.set(0, i-72, velocity)
+	}
+	e.rapid = (e.rapid + 1) % 80
+}
+
+// Sets an LED to the colour of a velocity, which is written to the buffer that is
+// updated, and copied to the other buffer, or cleared from it, as per its flags.
+func (e *Emulator) set(row, column, velocity int) {
+	if row == 0 && column == 8 {
+		return
+	}
+	color := velocity&0x33 | Black
+	e.buffers[e.update][row][column] = color
+	switch {
+	case velocity&0x04 != 0:
+		e.buffers[1-e.update][row][column] = color
+	case velocity&0x08 != 0:
+		e.buffers[1-e.update][row][column] = Black
+	}
+}
+
+// Applies a value of control change 0: a reset, a layout, the double-buffering
+// of the LEDs, or a test of all LEDs.
+func (e *Emulator) control(value int) {
+	switch {
+	case value == 0:
+		e.reset()
+	case value == layoutXY || value == layoutDrumRack:
+		e.layout = value
+	case value >= 0x20 && value <= 0x3D:
+		e.display = value & 0x01
+		e.update = value >> 2 & 0x01
+		e.flash = value&0x08 != 0
+		if value&0x10 != 0 {
+			e.buffers[e.update] = e.buffers[e.display]
+		}
+	case value >= 0x7D && value <= 0x7F:
+		e.buffers[0] = NewFrame(Amber)
+		e.buffers[1] = e.buffers[0]
+		e.buffers[0][0][8], e.buffers[1][0][8] = Black, Black
+	}
+}
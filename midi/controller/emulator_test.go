@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// Waits for the LEDs of an emulator to be as expected.
+func waitForLEDs(t *testing.T, e *Emulator, expected func(Frame) bool) {
+	timeout := time.After(time.Second)
+	for !expected(e.LEDs()) {
+		select {
+		case <-e.Updates:
+		case <-timeout:
+			t.Fatalf("The LEDs are\n%v", e.LEDs())
+		}
+	}
+}
+
+func TestLayouts(t *testing.T) {
+	for _, layout := range []int{layoutXY, layoutDrumRack} {
+		keys := make(map[int]bool)
+		for row := 1; row <= 8; row++ {
+			for column := 0; column <= 8; column++ {
+				key := keyOf(layout, row, column)
+				if keys[key] {
+					t.Errorf("Key %v of layout %v is of more than one button", key, layout)
+				}
+				keys[key] = true
+				if r, c, ok := buttonOf(layout, key); !ok || r != row || c != column {
+					t.Errorf("Key %v of layout %v is of %v, %v instead of %v, %v", key, layout, r, c, row, column)
+				}
+			}
+		}
+	}
+	if key := keyOf(layoutDrumRack, 8, 0); key != 36 {
+		t.Errorf("The bottom left key of the drum rack layout is %v instead of 36", key)
+	}
+}
+
+func TestEmulatorDoubleBuffering(t *testing.T) {
+	e := NewEmulator()
+	go e.Connect()
+	defer e.Close()
+	e.In <- midi.ControlChange{ID: 0, Value: 0x21}          // Display buffer 1 and update buffer 0.
+	e.In <- midi.NoteOn{Key: 0, Velocity: 0x03}             // Red, in buffer 0 only.
+	e.In <- midi.ControlChange{ID: 104, Value: Green}       // Green, in both buffers.
+	e.In <- midi.NoteOn{Channel: 2, Key: 0x33, Velocity: 0} // A rapid update of the first LEDs, in buffer 0 only.
+	e.In <- midi.ControlChange{ID: 0, Value: 0x24}          // Display buffer 0 and update buffer 1.
+	waitForLEDs(t, e, func(f Frame) bool {
+		return f[1][0] == Amber && f[1][1] == Black && f[0][0] == Green
+	})
+	if b := e.Buffer(1); b[1][0] != Black || b[0][0] != Green {
+		t.Errorf("Updated the buffer that was displayed: %v", b)
+	}
+	e.In <- midi.ControlChange{ID: 0, Value: 0x39} // Flash, copying buffer 1 to buffer 0.
+	waitForLEDs(t, e, func(f Frame) bool { return f[1][0] == Black })
+	if !e.Flashing() {
+		t.Errorf("The buffers are not flashing")
+	}
+	if b := e.Buffer(0); b[1][0] != Black {
+		t.Errorf("Did not copy the displayed buffer: %v", b)
+	}
+	e.In <- midi.ControlChange{ID: 0, Value: 0}
+	waitForLEDs(t, e, func(f Frame) bool { return f == NewFrame(Black) })
+}
+
+func TestLaunchpadEmulator(t *testing.T) {
+	e := NewEmulator()
+	l := NewLaunchpad(e, map[int]int{34: 60})
+	if err := l.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go l.Connect()
+	defer l.Close()
+
+	go e.Press(3, 2)
+	if m := <-l.Out; m.Uint32() != (midi.NoteOn{Key: 60, Velocity: 127}).Uint32() {
+		t.Errorf("Pressing a button sent %v", m)
+	}
+	waitForLEDs(t, e, func(f Frame) bool { return f[3][2] == Green })
+	go e.Release(3, 2)
+	if m := <-l.Out; m.Uint32() != (midi.NoteOff{Key: 60}).Uint32() {
+		t.Errorf("Releasing a button sent %v", m)
+	}
+	waitForLEDs(t, e, func(f Frame) bool { return f[3][2] == Black })
+
+	go e.Press(0, 4)
+	if m, ok := (<-l.Out).(midi.ControlChange); !ok || m.ID != 108 {
+		t.Errorf("Pressing an Automap button sent %v", m)
+	}
+	waitForLEDs(t, e, func(f Frame) bool { return f[0][4] == Red })
+
+	l.AllGridLightsOn(Amber)
+	waitForLEDs(t, e, func(f Frame) bool {
+		expected := NewFrame(Amber)
+		for i := 0; i < 9; i++ {
+			expected[0][i], expected[i][8] = Black, Black
+		}
+		return f == expected
+	})
+	l.LightOnXY(7, 7, Red)
+	waitForLEDs(t, e, func(f Frame) bool { return f[8][7] == Red })
+}
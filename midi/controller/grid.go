@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// A Grid is a grid controller, such as a Launchpad or a monome, that sends a Key
+// out of it for each press and release of its buttons. The buttons of the grid
+// are at columns X and rows Y from 0 at the top left to the width and height
+// less 1. Buttons outside of the grid, such as the top row and right column of a
+// Launchpad, are at a Y of -1 and an X of the width respectively.
+type Grid interface {
+	midi.Wirer
+	Size() (width, height int)
+	// Lights the LED of a button with a colour, as near as the LED can show it.
+	Set(x, y int, c Color) error
+	// Lights the LEDs of the grid with rows of colours, as many as the grid has.
+	SetFrame(rows [][]Color) error
+	// Turns off all LEDs.
+	Clear() error
+}
+
+// A Color is the colour of an LED, from 0 to 255 of red, green and blue.
+type Color struct {
+	R, G, B uint8
+}
+
+// Colours that all grids can show, at least as brightness.
+var (
+	Off   = Color{}
+	White = Color{255, 255, 255}
+)
+
+// Returns the grey of a brightness, from 0 to 255, for LEDs that only have brightness.
+func Gray(brightness uint8) Color {
+	return Color{brightness, brightness, brightness}
+}
+
+// Returns the brightness of a colour, from 0 to 255.
+func (c Color) Brightness() uint8 {
+	max := c.R
+	if c.G > max {
+		max = c.G
+	}
+	if c.B > max {
+		max = c.B
+	}
+	return max
+}
+
+// Returns rows of colours of the size of a grid, all off.
+func NewGridFrame(g Grid) [][]Color {
+	width, height := g.Size()
+	rows := make([][]Color, height)
+	for y := range rows {
+		rows[y] = make([]Color, width)
+	}
+	return rows
+}
+
+func noButton(x, y int) error {
+	return fmt.Errorf("There is no button at %v, %v.", x, y)
+}
+
+// A LaunchpadGrid is a Grid of an original Launchpad (or an Emulator or Terminal
+// of one) in the XY layout, whose top row of Automap buttons is at a Y of -1 and
+// whose column of scene buttons is at an X of 8. Its LEDs are drawn as frames,
+// sending only those that change, and frames that are set swap in at once.
+type LaunchpadGrid struct {
+	*midi.Wires
+	launchpad *Launchpad
+	device    midi.Wirer
+	stop      chan bool
+	mu        sync.Mutex
+	frame     Frame
+}
+
+// Creates a new LaunchpadGrid of a device.
+func NewLaunchpadGrid(d midi.Wirer) *LaunchpadGrid {
+	return &LaunchpadGrid{
+		Wires:     midi.NewWires(),
+		launchpad: NewLaunchpad(d, map[int]int{}),
+		device:    d,
+		stop:      make(chan bool, 1),
+		frame:     NewFrame(Black),
+	}
+}
+
+func (g *LaunchpadGrid) Open() error {
+	return g.device.Open()
+}
+
+func (g *LaunchpadGrid) Close() error {
+	g.stop <- true
+	g.launchpad.Reset()
+	return g.device.Close()
+}
+
+// Sends the presses and releases of the buttons out of the grid as Keys.
+func (g *LaunchpadGrid) Connect() {
+	go g.device.Connect()
+	g.launchpad.Reset()
+	for {
+		select {
+		case m := <-g.device.Wire().Out:
+			var k Key
+			switch m := m.(type) {
+			case midi.NoteOn: // The Launchpad releases buttons with a velocity of 0.
+				k = Key{X: m.Key % 16, Y: m.Key / 16, Pressed: m.Velocity > 0, Time: m.Time}
+			case midi.NoteOff:
+				k = Key{X: m.Key % 16, Y: m.Key / 16, Time: m.Time}
+			case midi.ControlChange:
+				if m.ID < 104 || m.ID > 111 {
+					continue
+				}
+				k = Key{X: m.ID - 104, Y: -1, Pressed: m.Value > 0, Time: m.Time}
+			default:
+				continue
+			}
+			select {
+			case g.Out <- k:
+			case <-g.stop:
+				g.stop <- true
+				return
+			}
+		case <-g.stop:
+			g.stop <- true // Push value back on for other go routines.
+			return
+		}
+	}
+}
+
+func (g *LaunchpadGrid) Wire() *midi.Wires {
+	return g.Wires
+}
+
+// Returns the size of the grid, not counting the top row and right column.
+func (g *LaunchpadGrid) Size() (width, height int) {
+	return 8, 8
+}
+
+// Returns the velocity colour code nearest a colour. The Launchpad has no blue,
+// so colours of only blue are shown as amber of their brightness.
+func velocityOf(c Color) int {
+	red, green := (int(c.R)+42)/85, (int(c.G)+42)/85
+	if red == 0 && green == 0 {
+		red = (int(c.B) + 42) / 85
+		green = red
+	}
+	return Black | red | green<<4
+}
+
+func (g *LaunchpadGrid) Set(x, y int, c Color) error {
+	if y < -1 || y >= 8 || x < 0 || x > 8 || y == -1 && x == 8 {
+		return noButton(x, y)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.frame[y+1][x] = velocityOf(c)
+	return g.launchpad.DrawFrame(g.frame)
+}
+
+func (g *LaunchpadGrid) SetFrame(rows [][]Color) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for y := 0; y < len(rows) && y < 8; y++ {
+		for x := 0; x < len(rows[y]) && x < 8; x++ {
+			g.frame[y+1][x] = velocityOf(rows[y][x])
+		}
+	}
+	return g.launchpad.SwapFrame(g.frame)
+}
+
+// Turns off all LEDs, and sets the XY layout.
+func (g *LaunchpadGrid) Clear() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.frame = NewFrame(Black)
+	return g.launchpad.Reset()
+}
+
+// A MonomeGrid is a Grid of a monome, which shows the brightness of colours with
+// the levels of varibright grids.
+type MonomeGrid struct {
+	*Monome
+}
+
+// Creates a new MonomeGrid of a monome.
+func NewMonomeGrid(m *Monome) *MonomeGrid {
+	return &MonomeGrid{m}
+}
+
+func (g *MonomeGrid) Size() (width, height int) {
+	return g.Width, g.Height
+}
+
+// Returns the level of an LED, from 0 to 15, of the brightness of a colour.
+func levelOf(c Color) int {
+	return (int(c.Brightness())*15 + 127) / 255
+}
+
+func (g *MonomeGrid) Set(x, y int, c Color) error {
+	if x < 0 || x >= g.Width || y < 0 || y >= g.Height {
+		return noButton(x, y)
+	}
+	return g.SetLevel(x, y, levelOf(c))
+}
+
+// Lights the LEDs of the grid by 8 by 8 quads.
+func (g *MonomeGrid) SetFrame(rows [][]Color) error {
+	for yOffset := 0; yOffset < g.Height; yOffset += 8 {
+		for xOffset := 0; xOffset < g.Width; xOffset += 8 {
+			var levels [64]int
+			for y := 0; y < 8 && yOffset+y < len(rows); y++ {
+				row := rows[yOffset+y]
+				for x := 0; x < 8 && xOffset+x < len(row); x++ {
+					levels[8*y+x] = levelOf(row[xOffset+x])
+				}
+			}
+			if err := g.MapLevel(xOffset, yOffset, levels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *MonomeGrid) Clear() error {
+	return g.All(false)
+}
+
+// An openDevice is a device that is already open and connected.
+type openDevice struct {
+	midi.Wirer
+}
+
+func (d openDevice) Open() error {
+	return nil
+}
+
+func (d openDevice) Connect() {}
+
+// The environment variable that selects the grid that OpenGrid opens, which is
+// a Terminal if it is set to "terminal".
+const GridVariable = "AUDIO_GRID"
+
+// Opens the grid controller of the system: the first Launchpad of any model, or
+// else the first monome that is found, or else a Terminal so that instruments
+// can be played without one.
+func OpenGrid() (Grid, error) {
+	if os.Getenv(GridVariable) == "terminal" {
+		return openTerminal()
+	}
+	devices, err := midi.GetDevices()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := FindLaunchpad(devices); ok {
+		if err := d.Open(); err != nil {
+			return nil, err
+		}
+		go d.Connect()
+		model := DetectModel(d)
+		var g Grid = NewLaunchpadGrid(openDevice{d})
+		if model.RGB {
+			g = NewRGBLaunchpad(openDevice{d}, model)
+		}
+		return g, g.Open()
+	}
+	monomes, err := DiscoverMonomes(time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if len(monomes) == 0 {
+		return openTerminal()
+	}
+	g := NewMonomeGrid(monomes[0].Monome())
+	return g, g.Open()
+}
+
+// Opens a grid of a Terminal of the standard input and output.
+func openTerminal() (Grid, error) {
+	g := NewLaunchpadGrid(NewTerminal())
+	return g, g.Open()
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestColors(t *testing.T) {
+	for _, c := range []struct {
+		color    Color
+		velocity int
+		level    int
+	}{
+		{Off, Black, 0},
+		{Color{255, 0, 0}, Red, 15},
+		{Color{0, 255, 0}, Green, 15},
+		{Color{255, 255, 0}, Amber, 15},
+		{Color{85, 85, 0}, AmberLow, 5},
+		{Color{0, 0, 255}, Amber, 15},
+		{Gray(128), Black | 2 | 2<<4, 8},
+	} {
+		if v := velocityOf(c.color); v != c.velocity {
+			t.Errorf("The velocity of %v is %v instead of %v", c.color, v, c.velocity)
+		}
+		if l := levelOf(c.color); l != c.level {
+			t.Errorf("The level of %v is %v instead of %v", c.color, l, c.level)
+		}
+	}
+}
+
+func TestLaunchpadGrid(t *testing.T) {
+	e := NewEmulator()
+	var g Grid = NewLaunchpadGrid(e)
+	if err := g.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go g.Connect()
+	defer g.Close()
+
+	for _, c := range []struct {
+		row, column int
+		x, y        int
+	}{
+		{3, 2, 2, 2},
+		{8, 8, 8, 7},
+		{0, 5, 5, -1},
+	} {
+		go e.Press(c.row, c.column)
+		if k := (<-g.Wire().Out).(Key); k.X != c.x || k.Y != c.y || !k.Pressed {
+			t.Errorf("Pressing %v, %v sent %+v", c.row, c.column, k)
+		}
+		go e.Release(c.row, c.column)
+		if k := (<-g.Wire().Out).(Key); k.X != c.x || k.Y != c.y || k.Pressed {
+			t.Errorf("Releasing %v, %v sent %+v", c.row, c.column, k)
+		}
+	}
+
+	frame := NewGridFrame(g)
+	frame[7][0] = Color{255, 0, 0}
+	g.SetFrame(frame)
+	g.Set(3, -1, Color{0, 255, 0})
+	g.Set(8, 0, White)
+	waitForLEDs(t, e, func(f Frame) bool {
+		return f[8][0] == Red && f[0][3] == Green && f[1][8] == Amber
+	})
+	if err := g.Set(8, -1, White); err == nil {
+		t.Errorf("Set a button that does not exist")
+	}
+	g.Clear()
+	waitForLEDs(t, e, func(f Frame) bool { return f == NewFrame(Black) })
+}
+
+func TestMonomeGrid(t *testing.T) {
+	host, device := net.Pipe()
+	m := newMonome(&mextConn{rw: host})
+	m.Width, m.Height = 8, 8
+	g := NewMonomeGrid(m)
+	go g.Set(1, 2, Gray(255))
+	b := make([]byte, 4)
+	io.ReadFull(device, b)
+	if expected := []byte{0x18, 1, 2, 15}; !bytes.Equal(b, expected) {
+		t.Errorf("Sent % X instead of % X", b, expected)
+	}
+	if err := g.Set(8, 0, White); err == nil {
+		t.Errorf("Set a button that does not exist")
+	}
+	device.Close()
+	host.Close()
+}
@@ -1,92 +1,119 @@
 package controller
 
-import "github.com/aoeu/audio/midi"
+import (
+	"sync"
+	"time"
 
+	"github.com/aoeu/audio/midi"
+)
+
+// A Launchpad is a Novation Launchpad, or an Emulator of one, whose button presses
+// are sent out of it as notes of their key numbers (transposed by a map of keys)
+// and control changes of the Automap buttons, lighting the buttons as they are pressed.
 type Launchpad struct {
-	device           midi.Device
-	inPort           *midi.SystemPort
-	transposer       *midi.Transposer // For transposition of the launchpad buttons.
-	auxIns           midi.Funnel      // Auxilary input devices that mimic touching launchpad buttons.
+	*midi.Wires
+	device           midi.Wirer   // The hardware or emulated Launchpad.
+	noteMap          map[int]int  // For transposition of the launchpad buttons.
+	reverseMap       map[int]int  // From transposed keys to the keys of the buttons.
+	auxIns           []midi.Wirer // Auxilary input devices that mimic touching launchpad buttons.
 	stop             chan bool
+	mu               sync.Mutex // Held while sending a sequence of messages to the device.
 	lightStatus      map[int]bool
+	drumMode         bool
+	drawing          sync.Mutex // Held while drawing a frame.
+	frames           [2]Frame   // The LEDs of each buffer as drawn by frames, without flags.
+	display          int        // The buffer that is displayed.
+	updating         int        // The buffer that the device updates.
+	lastWrite        time.Time
 	ButtonPressColor int
 	MomentaryButtons bool
+	MessageRate      int // The most messages written to the device per second, or 0 for no limit.
 }
 
-func NewLaunchpad(d midi.SystemDevice, noteMap map[int]int, auxIns ...midi.Device) (
-	l Launchpad) {
-	if d.Name != "Launchpad" {
-		return Launchpad{}
+// A rate of messages that the Launchpad's MIDI input keeps up with.
+const DefaultMessageRate = 400
+
+// Creates a new Launchpad of a device, such as the SystemDevice of FindLaunchpad
+// or an Emulator, with a map of the keys of its buttons to the keys that they
+// play, and any devices whose notes light the buttons that play them.
+func NewLaunchpad(d midi.Wirer, noteMap map[int]int, auxIns ...midi.Wirer) *Launchpad {
+	l := &Launchpad{
+		Wires:            midi.NewWires(),
+		device:           d,
+		noteMap:          noteMap,
+		reverseMap:       make(map[int]int, len(noteMap)),
+		auxIns:           auxIns,
+		stop:             make(chan bool, 1),
+		lightStatus:      make(map[int]bool),
+		ButtonPressColor: Green,
+		MomentaryButtons: true,
+		MessageRate:      DefaultMessageRate,
+	}
+	for key, val := range noteMap {
+		l.reverseMap[val] = key
 	}
-	l = Launchpad{device: d}
-	l.inPort = d.InPort().(*midi.SystemPort)
-	l.transposer = midi.NewTransposer(noteMap, nil)
-	l.auxIns, _ = midi.NewFunnel(midi.NewThruDevice(), auxIns...)
-	l.stop = make(chan bool, 1)
-	l.lightStatus = make(map[int]bool)
-	l.ButtonPressColor = Green
-	l.MomentaryButtons = true
 	return l
 }
 
-func (l Launchpad) Open() (err error) {
-	err = l.device.Open()
-	// Don't open the inPort as it is opened by the underlying device.
-	l.transposer.Open()
-	l.Reset()
-	return
+func (l *Launchpad) Open() error {
+	if err := l.device.Open(); err != nil {
+		return err
+	}
+	for _, d := range l.auxIns {
+		if err := d.Open(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (l Launchpad) Close() (err error) {
+func (l *Launchpad) Close() error {
 	l.stop <- true
-	err = l.device.Close()
-	if err != nil {
-		return
-	}
-	err = l.transposer.Close()
-	if err != nil {
-		return
+	l.Reset()
+	for _, d := range l.auxIns {
+		if err := d.Close(); err != nil {
+			return err
+		}
 	}
-	return
+	return l.device.Close()
 }
 
-func (l Launchpad) Run() {
-	go l.device.Run()
-	go l.transposer.Run()
-	go l.auxIns.Run()
+// Sends the button presses out of the Launchpad, and those mimicked by its
+// auxilary input devices, until it is closed.
+func (l *Launchpad) Connect() {
+	go l.device.Connect()
+	for _, d := range l.auxIns {
+		go d.Connect()
+		go l.connectAux(d)
+	}
 	l.Reset()
+	in := l.device.Wire().Out
 	for {
 		select {
-		// For the launchpad's buttons.
-		case note := <-l.device.OutPort().NoteOns():
-			l.transposer.InPort().NoteOns() <- note
-			l.LightOn(note.Key, l.ButtonPressColor)
-		case note := <-l.device.OutPort().NoteOffs():
-			l.transposer.InPort().NoteOffs() <- note
-			if l.MomentaryButtons {
-				l.LightOff(note.Key)
-			}
-		case cc := <-l.device.OutPort().ControlChanges():
-			l.transposer.InPort().ControlChanges() <- cc
-			if cc.ID < 108 {
-				if cc.Value == 0 {
-					l.AutomapLightOff(cc.ID)
-				} else {
-					l.AutomapLightOn(cc.ID, Red)
+		case m := <-in:
+			switch m := m.(type) {
+			case midi.NoteOn:
+				if m.Velocity == 0 { // The Launchpad releases buttons with a velocity of 0.
+					l.release(midi.NoteOff(m))
+					continue
 				}
-			} else {
-				l.AllAutomapLightsOff()
-				l.AutomapLightOn(cc.ID, Red)
+				l.LightOn(m.Key, l.ButtonPressColor)
+				m.Key = l.transpose(m.Key)
+				l.send(m)
+			case midi.NoteOff:
+				l.release(m)
+			case midi.ControlChange:
+				if m.ID < 108 {
+					if m.Value == 0 {
+						l.AutomapLightOff(m.ID)
+					} else {
+						l.AutomapLightOn(m.ID, Red)
+					}
+				} else if m.Value > 0 {
+					l.AutomapLightOnXOR(m.ID, Red)
+				}
+				l.send(m)
 			}
-		// For auxilary input devices that mimic pushing launchpad's buttons.
-		case note := <-l.auxIns.To.OutPort().NoteOns():
-			l.transposer.OutPort().NoteOns() <- note // Hack to bypass transposition.
-			key := l.transposer.ReverseMap[note.Key]
-			l.LightOn(key, Green)
-		case note := <-l.auxIns.To.OutPort().NoteOffs():
-			l.transposer.OutPort().NoteOffs() <- note // Hack to bypass transposition.
-			key := l.transposer.ReverseMap[note.Key]
-			l.LightOff(key)
 		case <-l.stop:
 			l.stop <- true // Push value back on for other go routines.
 			return
@@ -94,20 +121,86 @@ func (l Launchpad) Run() {
 	}
 }
 
-func (l Launchpad) InPort() midi.Port {
-	return l.inPort
+func (l *Launchpad) release(n midi.NoteOff) {
+	if l.MomentaryButtons {
+		l.LightOff(n.Key)
+	}
+	n.Key = l.transpose(n.Key)
+	l.send(n)
+}
+
+func (l *Launchpad) transpose(key int) int {
+	if to, ok := l.noteMap[key]; ok {
+		return to
+	}
+	return key
+}
+
+// Sends a message out of the Launchpad, unless it is closed.
+func (l *Launchpad) send(m midi.Message) {
+	select {
+	case l.Out <- m:
+	case <-l.stop:
+		l.stop <- true
+	}
+}
+
+// Sends the notes of an auxilary input device out of the Launchpad, bypassing
+// transposition, and lights the buttons that play them.
+func (l *Launchpad) connectAux(d midi.Wirer) {
+	for {
+		select {
+		case m := <-d.Wire().Out:
+			switch n := m.(type) {
+			case midi.NoteOn:
+				l.LightOn(l.reverseMap[n.Key], Green)
+			case midi.NoteOff:
+				l.LightOff(l.reverseMap[n.Key])
+			default:
+				continue
+			}
+			l.send(m)
+		case <-l.stop:
+			l.stop <- true
+			return
+		}
+	}
+}
+
+func (l *Launchpad) Wire() *midi.Wires {
+	return l.Wires
 }
 
-func (l Launchpad) OutPort() midi.Port {
-	return l.transposer.OutPort()
+// Writes messages to the device in order, no faster than the message rate.
+func (l *Launchpad) write(messages ...midi.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range messages {
+		if l.MessageRate > 0 {
+			next := l.lastWrite.Add(time.Second / time.Duration(l.MessageRate))
+			if wait := next.Sub(time.Now()); wait > 0 {
+				time.Sleep(wait)
+			}
+			l.lastWrite = time.Now()
+		}
+		l.device.Wire().In <- m
+	}
 }
 
+// Turns all lights off, clears both buffers, and sets the XY layout.
 func (l *Launchpad) Reset() (err error) {
-	// Turns all lights off and clears all buffers.
-	l.inPort.WriteRawEvent(midi.Event{0, 176, 0, 0})
+	l.mu.Lock()
+	l.drumMode = false
+	l.frames = [2]Frame{}
+	l.display, l.updating = 0, 0
+	l.mu.Unlock()
+	l.write(midi.ControlChange{Channel: 0, ID: 0, Value: 0})
 	return
 }
 
+// Velocity colour codes of the LEDs, from the two bits of red (the lowest) and of
+// green (the fifth and sixth), with the bits that copy the colour to both buffers
+// and clear it from the other buffer set.
 const (
 	Black    = 12
 	RedLow   = 13
@@ -122,71 +215,81 @@ const (
 /*
 Sending a MIDI channel 3 note-on message enters a special LED update mode.
 All eighty LEDs may be set (2 at a time)  using only forty consecutive MIDI events:
-    0 through 32:
-        The 8x8 button grid in left-to-right, top-to-bottom.
-    32 through 36:
-        Eight scene launch buttons in top-to-bottom order.
-    36 through 40:
-        The eight Automap/Live buttons in left-to-right order.
-
-    Keep this in mind for other functions that manipulate the lights.
+
+	0 through 32:
+	    The 8x8 button grid in left-to-right, top-to-bottom.
+	32 through 36:
+	    Eight scene launch buttons in top-to-bottom order.
+	36 through 40:
+	    The eight Automap/Live buttons in left-to-right order.
+
+Any other message, such as setting the layout, returns to the first LEDs.
+
+	Keep this in mind for other functions that manipulate the lights.
 */
-func (l Launchpad) AllLightsOn(color int) (err error) {
-	//l.Reset() // This needs to be called to write colors consecutively. Why?
-	l.inPort.NoteOns() <- midi.Note{Channel: 2, Key: 64, Velocity: 127}
-	for i := 0; i < 40; i++ {
-		l.inPort.ControlChanges() <- midi.ControlChange{Channel: 2, ID: color, Value: color}
-		if err != nil {
-			return
-		}
+func (l *Launchpad) rapidUpdate(colors [80]int) {
+	l.mu.Lock()
+	layout := l.layoutMessage()
+	l.mu.Unlock()
+	messages := []midi.Message{layout}
+	for i := 0; i < len(colors); i += 2 {
+		messages = append(messages, midi.NoteOn{Channel: 2, Key: colors[i], Velocity: colors[i+1]})
+	}
+	messages = append(messages, layout)
+	l.write(messages...)
+}
+
+// Returns the message that sets the current layout. The Launchpad must be locked.
+func (l *Launchpad) layoutMessage() midi.Message {
+	if l.drumMode {
+		return midi.ControlChange{Channel: 0, ID: 0, Value: 2}
+	}
+	return midi.ControlChange{Channel: 0, ID: 0, Value: 1}
+}
+
+func (l *Launchpad) AllLightsOn(color int) (err error) {
+	var colors [80]int
+	for i := range colors {
+		colors[i] = color
 	}
-	l.inPort.NoteOffs() <- midi.Note{Channel: 2, Key: 64, Velocity: 0}
+	l.rapidUpdate(colors)
 	return
 }
 
+// Lights the 8x8 grid without lighting the side buttons. (i.e. "Automap" and "Scene Select" buttons.)
 func (l *Launchpad) AllGridLightsOn(color int) (err error) {
-	l.Reset()
-	// BUG: The Launchpad spec says the next message should be channel 3.
-	// Channel 3 doesn't work, but 4 and up do...
-	l.inPort.WriteRawEvent(midi.Event{3, midi.NOTE_ON, 0, 0})
-	for i := 0; i < 32; i++ {
-		l.inPort.WriteRawEvent(midi.Event{2, midi.NOTE_ON, color, color})
-		if err != nil {
-			return
-		}
-	}
-	// Do not turn on the side buttons. (i.e. "Automap" and "Scene Select" buttons.)
-	for i := 0; i < 8; i++ {
-		l.inPort.WriteRawEvent(midi.Event{2, midi.NOTE_ON, Black, Black})
-		if err != nil {
-			return
+	var colors [80]int
+	for i := range colors {
+		colors[i] = color
+		if i >= 64 {
+			colors[i] = Black
 		}
 	}
-	l.inPort.WriteRawEvent(midi.Event{2, midi.NOTE_OFF, 0, 0})
+	l.rapidUpdate(colors)
 	return
 }
 
-func (l Launchpad) KeyNum(row, column int) int {
+func (l *Launchpad) KeyNum(row, column int) int {
 	return (16 * row) + column
 }
 
-func (l Launchpad) XY(keyNum int) (X, Y int) {
+func (l *Launchpad) XY(keyNum int) (X, Y int) {
 	X = (keyNum / 16)
 	Y = (keyNum % 16)
 	return
 }
 
-func (l Launchpad) LightOn(keyNum, color int) (err error) {
-	l.inPort.NoteOns() <- midi.Note{Channel: 0, Key: keyNum, Velocity: color}
+func (l *Launchpad) LightOn(keyNum, color int) (err error) {
+	l.write(midi.NoteOn{Channel: 0, Key: keyNum, Velocity: color})
 	return
 }
 
-func (l Launchpad) LightOff(keyNum int) (err error) {
-	l.inPort.NoteOffs() <- midi.Note{Channel: 0, Key: keyNum, Velocity: 0}
+func (l *Launchpad) LightOff(keyNum int) (err error) {
+	l.write(midi.NoteOff{Channel: 0, Key: keyNum, Velocity: 0})
 	return
 }
 
-func (l Launchpad) RowOn(Y int, color int) error {
+func (l *Launchpad) RowOn(Y int, color int) error {
 	startButton := Y * 16
 	endButton := startButton + 8
 	for i := startButton; i < endButton; i++ {
@@ -197,14 +300,14 @@ func (l Launchpad) RowOn(Y int, color int) error {
 	return nil
 }
 
-func (l Launchpad) RowOff(Y int) error {
+func (l *Launchpad) RowOff(Y int) error {
 	if err := l.RowOn(Y, Black); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (l Launchpad) ColumnOn(X int, color int) error {
+func (l *Launchpad) ColumnOn(X int, color int) error {
 	startButton := X
 	endButton := startButton + (8 * 16)
 	for i := startButton; i < endButton; i += 16 {
@@ -215,20 +318,20 @@ func (l Launchpad) ColumnOn(X int, color int) error {
 	return nil
 }
 
-func (l Launchpad) ColumnOff(X int) error {
+func (l *Launchpad) ColumnOff(X int) error {
 	if err := l.ColumnOn(X, Black); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (l Launchpad) AutomapLightOn(keyNum, color int) (err error) {
-	l.inPort.ControlChanges() <- midi.ControlChange{Channel: 0, ID: keyNum, Value: color}
+func (l *Launchpad) AutomapLightOn(keyNum, color int) (err error) {
+	l.write(midi.ControlChange{Channel: 0, ID: keyNum, Value: color})
 	return
 }
 
-func (l Launchpad) AutomapLightOff(keyNum int) (err error) {
-	l.inPort.ControlChanges() <- midi.ControlChange{Channel: 0, ID: keyNum, Value: Black}
+func (l *Launchpad) AutomapLightOff(keyNum int) (err error) {
+	l.write(midi.ControlChange{Channel: 0, ID: keyNum, Value: Black})
 	return
 }
 
@@ -239,7 +342,7 @@ func (l *Launchpad) AllAutomapLightsOff() (err error) {
 	return
 }
 
-func (l Launchpad) AutomapLightOnXOR(keyNum, color int) (err error) {
+func (l *Launchpad) AutomapLightOnXOR(keyNum, color int) (err error) {
 	err = l.AllAutomapLightsOff()
 	if err != nil {
 		return
@@ -248,57 +351,146 @@ func (l Launchpad) AutomapLightOnXOR(keyNum, color int) (err error) {
 	return
 }
 
-func (l Launchpad) LightOnXY(row, column, color int) (err error) {
-	buttonNum := (16 * row) + column
-	l.inPort.NoteOns() <- midi.Note{Channel: 0, Key: buttonNum, Velocity: color}
-	if err != nil {
-		return
-	}
-	return
+func (l *Launchpad) LightOnXY(row, column, color int) (err error) {
+	return l.LightOn(l.KeyNum(row, column), color)
 }
 
 func (l *Launchpad) LightOffXY(row, column int) (err error) {
-	buttonNum := (16 * row) + column
-	l.inPort.NoteOffs() <- midi.Note{Channel: 0, Key: buttonNum, Velocity: 0}
-	if err != nil {
-		return
-	}
-	return
+	return l.LightOff(l.KeyNum(row, column))
 }
 
 func (l *Launchpad) ToggleLightColor(buttonNum, color1, color2 int) (err error) {
-	if l.lightStatus[buttonNum] {
-		err = l.LightOn(buttonNum, color2)
-		if err != nil {
-			return
-		}
-		l.lightStatus[buttonNum] = false
-	} else {
-		err = l.LightOn(buttonNum, color1)
-		if err != nil {
-			return
-		}
-		l.lightStatus[buttonNum] = true
+	l.mu.Lock()
+	on := !l.lightStatus[buttonNum]
+	l.lightStatus[buttonNum] = on
+	l.mu.Unlock()
+	if on {
+		return l.LightOn(buttonNum, color1)
 	}
-	return
+	return l.LightOn(buttonNum, color2)
 }
 
-func (l Launchpad) ToggleLight(buttonNum int, color int) error {
+func (l *Launchpad) ToggleLight(buttonNum int, color int) error {
 	return l.ToggleLightColor(buttonNum, color, Black)
 }
 
-func (l Launchpad) ToggleLightXY(row, column, color int) (err error) {
-	buttonNum := (16 * row) + column
-	l.ToggleLight(buttonNum, color)
-	return
+func (l *Launchpad) ToggleLightXY(row, column, color int) (err error) {
+	return l.ToggleLight(l.KeyNum(row, column), color)
 }
 
-func (l Launchpad) DrumMode() (err error) {
-	l.inPort.ControlChanges() <- midi.ControlChange{Channel: 0, ID: 0, Value: 2}
+func (l *Launchpad) DrumMode() (err error) {
+	l.mu.Lock()
+	l.drumMode = true
+	layout := l.layoutMessage()
+	l.mu.Unlock()
+	l.write(layout)
 	return
 }
 
-func (l Launchpad) XYMode() (err error) {
-	l.inPort.ControlChanges() <- midi.ControlChange{Channel: 0, ID: 0, Value: 1}
+func (l *Launchpad) XYMode() (err error) {
+	l.mu.Lock()
+	l.drumMode = false
+	layout := l.layoutMessage()
+	l.mu.Unlock()
+	l.write(layout)
 	return
 }
+
+// Lights the LEDs as per a frame of velocity colour codes, sending only those
+// that differ from the frames drawn before, or a rapid update if more than half
+// of them do. The LEDs lit by other methods are not known to differ.
+func (l *Launchpad) DrawFrame(f Frame) error {
+	return l.drawFrame(f, false)
+}
+
+// Lights the LEDs as per a frame, like DrawFrame, in the buffer that is not
+// displayed, then displays that buffer so that the frame appears at once.
+func (l *Launchpad) SwapFrame(f Frame) error {
+	return l.drawFrame(f, true)
+}
+
+func (l *Launchpad) drawFrame(f Frame, swap bool) error {
+	l.drawing.Lock()
+	defer l.drawing.Unlock()
+	l.mu.Lock()
+	update, flags := l.display, Black // Copy to both buffers.
+	if swap {
+		update, flags = 1-l.display, 0
+	}
+	var messages []midi.Message
+	if update != l.updating {
+		messages = append(messages, bufferMessage(l.display, update))
+	}
+	var changed [][2]int
+	for row := range f {
+		for column := range f[row] {
+			if row == 0 && column == 8 {
+				continue
+			}
+			color := f[row][column] & 0x33
+			if color != l.frames[update][row][column] || !swap && color != l.frames[1-update][row][column] {
+				changed = append(changed, [2]int{row, column})
+			}
+			l.frames[update][row][column] = color
+			if !swap {
+				l.frames[1-update][row][column] = color
+			}
+		}
+	}
+	layout := l.layoutMessage()
+	drumMode := l.drumMode
+	if swap {
+		l.display, l.updating = update, 1-update
+	} else {
+		l.updating = update
+	}
+	l.mu.Unlock()
+
+	if len(changed) > 40 {
+		messages = append(messages, layout)
+		colors := rapidColors(f)
+		for i := 0; i < len(colors); i += 2 {
+			messages = append(messages, midi.NoteOn{Channel: 2, Key: colors[i]&0x33 | flags, Velocity: colors[i+1]&0x33 | flags})
+		}
+		messages = append(messages, layout)
+	} else {
+		keyLayout := layoutXY
+		if drumMode {
+			keyLayout = layoutDrumRack
+		}
+		for _, c := range changed {
+			row, column := c[0], c[1]
+			color := f[row][column]&0x33 | flags
+			if row == 0 {
+				messages = append(messages, midi.ControlChange{ID: 104 + column, Value: color})
+			} else {
+				messages = append(messages, midi.NoteOn{Key: keyOf(keyLayout, row, column), Velocity: color})
+			}
+		}
+	}
+	if swap {
+		messages = append(messages, bufferMessage(update, 1-update))
+	}
+	l.write(messages...)
+	return nil
+}
+
+// Returns the message that displays a buffer and updates a buffer.
+func bufferMessage(display, update int) midi.Message {
+	return midi.ControlChange{ID: 0, Value: 0x20 | display | update<<2}
+}
+
+// Returns the colours of a frame in the order of a rapid update.
+func rapidColors(f Frame) (colors [80]int) {
+	for i := range colors {
+		switch {
+		case i < 64:
+			colors[i] = f[1+i/8][i%8]
+		case i < 72:
+			colors[i] = f[1+i-64][8]
+		default:
+			colors[i] = f[0][i-72]
+		}
+	}
+	return colors
+}
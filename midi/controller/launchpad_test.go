@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// Returns the messages sent to a fake Launchpad until it is idle.
+func sentMessages(f *fakeLaunchpad) (messages []midi.Message) {
+	for {
+		select {
+		case m := <-f.sent:
+			messages = append(messages, m)
+		case <-time.After(50 * time.Millisecond):
+			return messages
+		}
+	}
+}
+
+func TestDrawFrame(t *testing.T) {
+	f := newFakeLaunchpad(nil)
+	go f.Connect()
+	defer f.Close()
+	l := NewLaunchpad(f, map[int]int{})
+	l.MessageRate = 0
+
+	frame := NewFrame(Black)
+	frame[2][3], frame[0][1] = Red, Green
+	l.DrawFrame(frame)
+	expected := []midi.Message{
+		midi.ControlChange{ID: 105, Value: Green},
+		midi.NoteOn{Key: 19, Velocity: Red},
+	}
+	if m := sentMessages(f); len(m) != len(expected) || m[0] != expected[0] || m[1] != expected[1] {
+		t.Errorf("Drew the changed LEDs with %v instead of %v", m, expected)
+	}
+	l.DrawFrame(frame)
+	if m := sentMessages(f); len(m) != 0 {
+		t.Errorf("Drew LEDs that did not change with %v", m)
+	}
+	l.DrawFrame(NewFrame(Amber))
+	if m := sentMessages(f); len(m) != 42 {
+		t.Errorf("Drew a frame of all changed LEDs with %v messages instead of a rapid update", len(m))
+	}
+	l.SwapFrame(NewFrame(Amber))
+	expected = []midi.Message{
+		midi.ControlChange{ID: 0, Value: 0x24}, // Display buffer 0 and update buffer 1.
+		midi.ControlChange{ID: 0, Value: 0x21}, // Display buffer 1 and update buffer 0.
+	}
+	if m := sentMessages(f); len(m) != len(expected) || m[0] != expected[0] || m[1] != expected[1] {
+		t.Errorf("Swapped a frame that did not change with %v instead of %v", m, expected)
+	}
+}
+
+func TestSwapFrame(t *testing.T) {
+	e := NewEmulator()
+	go e.Connect()
+	defer e.Close()
+	l := NewLaunchpad(e, map[int]int{})
+
+	var frames [3]Frame
+	for i := range frames {
+		frames[i] = NewFrame(Black)
+		frames[i][1+i][i], frames[i][0][i] = Red, Green
+		l.SwapFrame(frames[i])
+		waitForLEDs(t, e, func(f Frame) bool { return f == frames[i] })
+		if i > 0 && e.Buffer(1-(i+1)%2) != frames[i-1] {
+			t.Errorf("Updated the buffer that was displayed")
+		}
+	}
+	l.DrawFrame(frames[0])
+	waitForLEDs(t, e, func(f Frame) bool { return f == frames[0] })
+	if e.Buffer(0) != e.Buffer(1) {
+		t.Errorf("Drew a frame to one buffer only")
+	}
+}
+
+func TestMessageRate(t *testing.T) {
+	f := newFakeLaunchpad(nil)
+	go f.Connect()
+	defer f.Close()
+	l := NewLaunchpad(f, map[int]int{})
+	l.MessageRate = 1000
+	start := time.Now()
+	for i := 0; i < 21; i++ {
+		l.LightOn(i, Red)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Wrote 21 messages in %v at 1000 messages per second", elapsed)
+	}
+}
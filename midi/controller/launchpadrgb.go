@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// The header of the system exclusive messages of Novation's devices.
+var novation = []byte{0x00, 0x20, 0x29}
+
+// A device inquiry, to which devices reply with their manufacturer and family.
+var deviceInquiry = []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}
+
+// How long to wait for a reply to a device inquiry.
+const inquiryTimeout = 500 * time.Millisecond
+
+// A Model is a model of Launchpad, with what differs between models of how they
+// are spoken to in programmer mode (or the session layout, for the MK2), where
+// the grid is of notes 11 to 88 from the bottom left.
+type Model struct {
+	Name       string
+	family     [2]byte // As replied to device inquiries.
+	device     byte    // The byte of system exclusive messages after Novation's header.
+	RGB        bool    // Whether LEDs are lit by red, green and blue rather than by velocity.
+	topRow     int     // The control change of the leftmost button of the top row.
+	sideCCs    bool    // Whether the right column sends control changes rather than notes.
+	maxLevel   uint8   // The largest level of red, green or blue.
+	programmer []byte  // The command that enters programmer mode, after the header.
+	live       []byte  // The command that leaves programmer mode, if any.
+	rgb        []byte  // The command that sets the red, green and blue of LEDs.
+	newText    bool    // Whether text is scrolled with the commands of the X and later.
+}
+
+var (
+	// The original Launchpad, the Launchpad S and the Launchpad Mini before the MK3,
+	// which do not reply to device inquiries and are driven by Launchpad rather than RGBLaunchpad.
+	LaunchpadMK1 = &Model{Name: "Launchpad"}
+	LaunchpadMK2 = &Model{
+		Name: "Launchpad MK2", family: [2]byte{0x69, 0x00}, device: 0x18, RGB: true,
+		topRow: 104, maxLevel: 63, programmer: []byte{0x22, 0x00}, rgb: []byte{0x0B},
+	}
+	LaunchpadPro = &Model{
+		Name: "Launchpad Pro", family: [2]byte{0x51, 0x00}, device: 0x10, RGB: true,
+		topRow: 91, sideCCs: true, maxLevel: 63, programmer: []byte{0x2C, 0x03},
+		live: []byte{0x2C, 0x00}, rgb: []byte{0x0B},
+	}
+	LaunchpadX = &Model{
+		Name: "Launchpad X", family: [2]byte{0x03, 0x01}, device: 0x0C, RGB: true,
+		topRow: 91, sideCCs: true, maxLevel: 127, programmer: []byte{0x0E, 0x01},
+		live: []byte{0x0E, 0x00}, rgb: []byte{0x03, 0x03}, newText: true,
+	}
+	LaunchpadMiniMK3 = &Model{
+		Name: "Launchpad Mini MK3", family: [2]byte{0x13, 0x01}, device: 0x0D, RGB: true,
+		topRow: 91, sideCCs: true, maxLevel: 127, programmer: []byte{0x0E, 0x01},
+		live: []byte{0x0E, 0x00}, rgb: []byte{0x03, 0x03}, newText: true,
+	}
+	LaunchpadProMK3 = &Model{
+		Name: "Launchpad Pro MK3", family: [2]byte{0x23, 0x01}, device: 0x0E, RGB: true,
+		topRow: 91, sideCCs: true, maxLevel: 127, programmer: []byte{0x0E, 0x01},
+		live: []byte{0x0E, 0x00}, rgb: []byte{0x03, 0x03}, newText: true,
+	}
+)
+
+// The models that are identified by their replies to device inquiries.
+var models = []*Model{LaunchpadMK2, LaunchpadPro, LaunchpadX, LaunchpadMiniMK3, LaunchpadProMK3}
+
+// Returns the model of a reply to a device inquiry, or nil if it is not of a Launchpad.
+func modelOf(reply []byte) *Model {
+	// F0 7E <device ID> 06 02 <manufacturer> <family> <member> <version> F7
+	if len(reply) < 12 || reply[1] != 0x7E || reply[3] != 0x06 || reply[4] != 0x02 ||
+		!bytes.Equal(reply[5:8], novation) {
+		return nil
+	}
+	for _, m := range models {
+		if reply[8] == m.family[0] && reply[9] == m.family[1] {
+			return m
+		}
+	}
+	return nil
+}
+
+// Sends a device inquiry to a Launchpad that is open and connected, and returns
+// its model as per its reply. Launchpads that do not reply within a timeout are
+// taken to be of the LaunchpadMK1 model. Other data sent out of the device until
+// then is dropped.
+func DetectModel(d midi.Wirer) *Model {
+	d.Wire().In <- midi.SysEx{Data: deviceInquiry}
+	timeout := time.After(inquiryTimeout)
+	for {
+		select {
+		case m := <-d.Wire().Out:
+			if s, ok := m.(midi.SysEx); ok {
+				if model := modelOf(s.Data); model != nil {
+					return model
+				}
+			}
+		case <-timeout:
+			return LaunchpadMK1
+		}
+	}
+}
+
+// Returns the device of a Launchpad of any model, whose names begin with
+// "Launchpad", preferring ports for MIDI rather than for DAWs.
+func FindLaunchpad(devices midi.SystemDevices) (midi.SystemDevice, bool) {
+	var names []string
+	for name := range devices {
+		if strings.HasPrefix(name, "Launchpad") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return midi.SystemDevice{}, false
+	}
+	sort.Slice(names, func(i, j int) bool {
+		iDAW, jDAW := strings.Contains(names[i], "DAW"), strings.Contains(names[j], "DAW")
+		if iDAW != jDAW {
+			return jDAW
+		}
+		return names[i] < names[j]
+	})
+	return devices[names[0]], true
+}
+
+var errNotRGB = errors.New("The Launchpad does not have RGB LEDs; use a Launchpad rather than an RGBLaunchpad.")
+
+// An RGBLaunchpad is a Launchpad with RGB LEDs: an MK2, Pro, X, Mini MK3 or Pro MK3,
+// which is put in programmer mode while it is open. It implements Grid, sending
+// a Key for each press and release of its buttons.
+type RGBLaunchpad struct {
+	*midi.Wires
+	Model  *Model // The model of the Launchpad, which is detected when opened if nil.
+	device midi.Wirer
+	stop   chan bool
+	mu     sync.Mutex  // Held while sending to the device.
+	lit    [9][9]Color // The colours of the LEDs that were set, by Y+1 and X.
+	known  [9][9]bool  // Whether the LEDs are lit with the colours that were set.
+}
+
+// Creates a new RGBLaunchpad of a device, of a model or nil to detect it.
+func NewRGBLaunchpad(d midi.Wirer, m *Model) *RGBLaunchpad {
+	return &RGBLaunchpad{
+		Wires:  midi.NewWires(),
+		Model:  m,
+		device: d,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Opens and connects the device, detects its model if it is not known, and
+// enters programmer mode.
+func (l *RGBLaunchpad) Open() error {
+	if l.Model != nil && !l.Model.RGB {
+		return errNotRGB
+	}
+	if err := l.device.Open(); err != nil {
+		return err
+	}
+	go l.device.Connect()
+	if l.Model == nil {
+		l.Model = DetectModel(l.device)
+	}
+	if !l.Model.RGB {
+		return errNotRGB
+	}
+	l.sysex(l.Model.programmer...)
+	return l.Clear()
+}
+
+// Turns off the LEDs, leaves programmer mode and closes the device.
+func (l *RGBLaunchpad) Close() error {
+	l.stop <- true
+	l.Clear()
+	if l.Model.live != nil {
+		l.sysex(l.Model.live...)
+	}
+	return l.device.Close()
+}
+
+// Sends the presses and releases of the buttons out of the Launchpad as Keys.
+func (l *RGBLaunchpad) Connect() {
+	for {
+		select {
+		case m := <-l.device.Wire().Out:
+			var number, value int
+			switch m := m.(type) {
+			case midi.NoteOn:
+				number, value = m.Key, m.Velocity
+			case midi.NoteOff:
+				number = m.Key
+			case midi.ControlChange:
+				number, value = m.ID, m.Value
+			default:
+				continue
+			}
+			x, y, ok := l.button(number)
+			if !ok {
+				continue
+			}
+			select {
+			case l.Out <- Key{X: x, Y: y, Pressed: value > 0, Time: m.Timestamp()}:
+			case <-l.stop:
+				l.stop <- true
+				return
+			}
+		case <-l.stop:
+			l.stop <- true // Push value back on for other go routines.
+			return
+		}
+	}
+}
+
+func (l *RGBLaunchpad) Wire() *midi.Wires {
+	return l.Wires
+}
+
+// Returns the size of the grid, not counting the top row and right column.
+func (l *RGBLaunchpad) Size() (width, height int) {
+	return 8, 8
+}
+
+// Returns the column and row of the button of a note or control change number.
+func (l *RGBLaunchpad) button(number int) (x, y int, ok bool) {
+	if number >= l.Model.topRow && number < l.Model.topRow+8 {
+		return number - l.Model.topRow, -1, true
+	}
+	row, column := number/10, number%10
+	if row < 1 || row > 8 || column < 1 || column > 9 {
+		return 0, 0, false
+	}
+	return column - 1, 8 - row, true
+}
+
+// Returns the number of the LED of a button, and whether it is lit by control
+// changes rather than notes.
+func (l *RGBLaunchpad) led(x, y int) (number int, cc bool, err error) {
+	switch {
+	case y == -1 && x >= 0 && x < 8:
+		return l.Model.topRow + x, true, nil
+	case y >= 0 && y < 8 && x >= 0 && x <= 8:
+		return 10*(8-y) + x + 1, x == 8 && l.Model.sideCCs, nil
+	}
+	return 0, false, noButton(x, y)
+}
+
+// Sends a system exclusive message of Novation's header, the model's device byte and some data.
+func (l *RGBLaunchpad) sysex(data ...byte) {
+	b := append([]byte{0xF0}, novation...)
+	b = append(b, 0x02, l.Model.device)
+	b = append(b, data...)
+	l.send(midi.SysEx{Data: append(b, 0xF7)})
+}
+
+func (l *RGBLaunchpad) send(m midi.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.device.Wire().In <- m
+}
+
+// Lights an LED with a colour.
+func (l *RGBLaunchpad) Set(x, y int, c Color) error {
+	number, _, err := l.led(x, y)
+	if err != nil {
+		return err
+	}
+	scale := func(v uint8) byte {
+		return byte(int(v) * int(l.Model.maxLevel) / 255)
+	}
+	data := append([]byte{}, l.Model.rgb...)
+	l.sysex(append(data, byte(number), scale(c.R), scale(c.G), scale(c.B))...)
+	l.mu.Lock()
+	l.lit[y+1][x], l.known[y+1][x] = c, true
+	l.mu.Unlock()
+	return nil
+}
+
+// Lights the LEDs of the grid with rows of colours, sending only those that
+// differ from the colours that the LEDs were set to.
+func (l *RGBLaunchpad) SetFrame(rows [][]Color) error {
+	for y := 0; y < len(rows) && y < 8; y++ {
+		for x := 0; x < len(rows[y]) && x < 8; x++ {
+			l.mu.Lock()
+			same := l.known[y+1][x] && l.lit[y+1][x] == rows[y][x]
+			l.mu.Unlock()
+			if same {
+				continue
+			}
+			if err := l.Set(x, y, rows[y][x]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lights an LED with a colour of the Launchpad's palette of 128 colours, on a
+// MIDI channel from 0 to 2 that lights it steadily, flashing or pulsing.
+func (l *RGBLaunchpad) palette(x, y, color, channel int) error {
+	number, cc, err := l.led(x, y)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.lit[y+1][x], l.known[y+1][x] = Off, color == 0 && channel == 0
+	l.mu.Unlock()
+	if cc {
+		l.send(midi.ControlChange{Channel: channel, ID: number, Value: color})
+	} else {
+		l.send(midi.NoteOn{Channel: channel, Key: number, Velocity: color})
+	}
+	return nil
+}
+
+// Lights an LED with a colour of the Launchpad's palette, from 0 (off) to 127.
+func (l *RGBLaunchpad) SetPalette(x, y, color int) error {
+	return l.palette(x, y, color, 0)
+}
+
+// Flashes an LED between the colour it is lit with and a colour of the palette.
+func (l *RGBLaunchpad) Flash(x, y, color int) error {
+	return l.palette(x, y, color, 1)
+}
+
+// Pulses an LED with a colour of the palette.
+func (l *RGBLaunchpad) Pulse(x, y, color int) error {
+	return l.palette(x, y, color, 2)
+}
+
+// Turns off all LEDs.
+func (l *RGBLaunchpad) Clear() error {
+	for y := -1; y < 8; y++ {
+		for x := 0; x <= 8; x++ {
+			if y == -1 && x == 8 {
+				continue
+			}
+			if err := l.SetPalette(x, y, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Scrolls text across the grid in a colour of the palette, once or repeatedly.
+// Scrolling text that is empty stops any text that is scrolling.
+func (l *RGBLaunchpad) ScrollText(text string, color int, loop bool) {
+	var data []byte
+	if l.Model.newText {
+		data = []byte{0x07, byte(stateOf(loop)), 0x07, 0x00, byte(color)} // At a speed of 7 pads per second.
+	} else {
+		data = []byte{0x14, byte(color), byte(stateOf(loop))}
+	}
+	l.sysex(append(data, []byte(text)...)...)
+}
+
+func stateOf(on bool) int {
+	if on {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// A fakeLaunchpad replies to device inquiries as a model of Launchpad, and
+// sends the other data sent to it out of its sent channel.
+type fakeLaunchpad struct {
+	*midi.Wires
+	reply []byte
+	sent  chan midi.Message
+	stop  chan bool
+}
+
+func newFakeLaunchpad(reply []byte) *fakeLaunchpad {
+	return &fakeLaunchpad{
+		Wires: midi.NewWires(),
+		reply: reply,
+		sent:  make(chan midi.Message, 256),
+		stop:  make(chan bool, 1),
+	}
+}
+
+func (f *fakeLaunchpad) Open() error { return nil }
+
+func (f *fakeLaunchpad) Close() error {
+	f.stop <- true
+	return nil
+}
+
+func (f *fakeLaunchpad) Connect() {
+	for {
+		select {
+		case m := <-f.In:
+			if s, ok := m.(midi.SysEx); ok && bytes.Equal(s.Data, deviceInquiry) {
+				if f.reply != nil {
+					f.Out <- midi.SysEx{Data: f.reply}
+				}
+				continue
+			}
+			f.sent <- m
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *fakeLaunchpad) Wire() *midi.Wires {
+	return f.Wires
+}
+
+func TestRGBLaunchpad(t *testing.T) {
+	reply := []byte{0xF0, 0x7E, 0x00, 0x06, 0x02, 0x00, 0x20, 0x29, 0x03, 0x01, 0x00, 0x00, 0x00, 0x04, 0x05, 0x03, 0xF7}
+	f := newFakeLaunchpad(reply)
+	l := NewRGBLaunchpad(f, nil)
+	if err := l.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go l.Connect()
+	defer l.Close()
+	if l.Model != LaunchpadX {
+		t.Fatalf("Detected a %v instead of a Launchpad X", l.Model.Name)
+	}
+	if m := <-f.sent; !bytes.Equal(m.(midi.SysEx).Data, []byte{0xF0, 0x00, 0x20, 0x29, 0x02, 0x0C, 0x0E, 0x01, 0xF7}) {
+		t.Errorf("Sent %v instead of entering programmer mode", m)
+	}
+	for i := 0; i < 80; i++ { // Clearing the LEDs.
+		<-f.sent
+	}
+
+	l.Set(0, 0, Color{255, 0, 128})
+	if m := <-f.sent; !bytes.Equal(m.(midi.SysEx).Data, []byte{0xF0, 0x00, 0x20, 0x29, 0x02, 0x0C, 0x03, 0x03, 81, 127, 0, 63, 0xF7}) {
+		t.Errorf("Set the top left LED with %v", m)
+	}
+	l.Pulse(8, 7, 5)
+	if m := <-f.sent; m != (midi.ControlChange{Channel: 2, ID: 19, Value: 5}) {
+		t.Errorf("Pulsed the bottom right side LED with %v", m)
+	}
+	l.Flash(3, 2, 9)
+	if m := <-f.sent; m != (midi.NoteOn{Channel: 1, Key: 64, Velocity: 9}) {
+		t.Errorf("Flashed an LED with %v", m)
+	}
+	if err := l.Set(8, -1, White); err == nil {
+		t.Errorf("Set an LED that does not exist")
+	}
+	l.ScrollText("hi", 5, true)
+	if m := <-f.sent; !bytes.Equal(m.(midi.SysEx).Data, []byte{0xF0, 0x00, 0x20, 0x29, 0x02, 0x0C, 0x07, 1, 7, 0, 5, 'h', 'i', 0xF7}) {
+		t.Errorf("Scrolled text with %v", m)
+	}
+
+	for _, c := range []struct {
+		sent     midi.Message
+		expected Key
+	}{
+		{midi.NoteOn{Key: 11, Velocity: 100}, Key{X: 0, Y: 7, Pressed: true}},
+		{midi.NoteOn{Key: 11}, Key{X: 0, Y: 7}},
+		{midi.ControlChange{ID: 93, Value: 127}, Key{X: 2, Y: -1, Pressed: true}},
+		{midi.ControlChange{ID: 89, Value: 127}, Key{X: 8, Y: 0, Pressed: true}},
+	} {
+		f.Out <- c.sent
+		if k := (<-l.Out).(Key); k.X != c.expected.X || k.Y != c.expected.Y || k.Pressed != c.expected.Pressed {
+			t.Errorf("Sent %v of %v instead of %v", k, c.sent, c.expected)
+		}
+	}
+}
+
+func TestDetectModel(t *testing.T) {
+	f := newFakeLaunchpad(nil)
+	go f.Connect()
+	defer f.Close()
+	if m := DetectModel(f); m != LaunchpadMK1 {
+		t.Errorf("Detected a %v of a Launchpad that does not reply", m.Name)
+	}
+	if NewRGBLaunchpad(f, LaunchpadMK1).Open() != errNotRGB {
+		t.Errorf("Opened a Launchpad without RGB LEDs")
+	}
+	reply := []byte{0xF0, 0x7E, 0x00, 0x06, 0x02, 0x00, 0x20, 0x29, 0x69, 0x00, 0x00, 0x00, 0x00, 0x01, 0x05, 0x03, 0xF7}
+	if m := modelOf(reply); m != LaunchpadMK2 {
+		t.Errorf("Detected an MK2 as %v", m)
+	}
+}
@@ -0,0 +1,143 @@
+package controller
+
+import "github.com/aoeu/audio/midi"
+
+// A Layout is a musical layout of the buttons of a grid: the keys that they
+// play, and the scale (or blocks of drums) that their LEDs show.
+type Layout struct {
+	Keys  [][]int    // The key of each button by row Y and column X, or -1 for none.
+	Scale midi.Scale // The scale whose keys are lit, or nil.
+	Root  int        // The root key of the scale.
+	Block int        // The number of keys of the blocks of drums that are lit alternately, or 0.
+}
+
+// An Isomorphism is the intervals, in semitones, between neighbouring columns
+// and rows of an isomorphic layout, in which every chord and scale has the same
+// shape in every key.
+type Isomorphism struct {
+	Column, Row int
+}
+
+var (
+	Fourths       = Isomorphism{Column: 1, Row: 5} // Chromatic rows a fourth apart, as the strings of a bass.
+	Fifths        = Isomorphism{Column: 1, Row: 7} // Chromatic rows a fifth apart, as the strings of a violin.
+	WickiHayden   = Isomorphism{Column: 2, Row: 5} // Whole tones across and fourths up, with fifths up and to the right.
+	HarmonicTable = Isomorphism{Column: 4, Row: 3} // Major thirds across and minor thirds up, with fifths up and to the right.
+)
+
+// Returns a layout of the size of a grid whose buttons play keys from the key
+// of the bottom left button, by the intervals of an isomorphism, lighting the
+// keys of a scale from a root.
+func IsomorphicLayout(width, height, low int, i Isomorphism, s midi.Scale, root int) Layout {
+	return newLayout(width, height, s, root, func(x, y int) int {
+		return low + x*i.Column + y*i.Row
+	})
+}
+
+// Returns a layout of the size of a grid whose buttons play only the keys of a
+// scale, from the root key at the bottom left, ascending by a degree to the
+// right and by a number of degrees up, such as 3 for fourths of a diatonic scale.
+func ScaleLayout(width, height int, s midi.Scale, root, rowDegrees int) Layout {
+	return newLayout(width, height, s, root, func(x, y int) int {
+		return s.Key(root, x+y*rowDegrees)
+	})
+}
+
+// Returns a layout of the size of a grid of blocks of 4 by 4 buttons that play
+// consecutive keys from the key of the bottom left button, like the pads of a
+// drum machine, ascending to the right and up within a block and from block to
+// block likewise.
+func DrumLayout(width, height, low int) Layout {
+	blocks := width / 4
+	if blocks < 1 {
+		blocks = 1
+	}
+	l := newLayout(width, height, nil, low, func(x, y int) int {
+		block := y/4*blocks + x/4
+		return low + block*16 + y%4*4 + x%4
+	})
+	l.Block = 16
+	return l
+}
+
+// Returns a layout of the keys of buttons by column and row from the bottom,
+// which are none beyond the range of keys.
+func newLayout(width, height int, s midi.Scale, root int, keyOf func(x, y int) int) Layout {
+	l := Layout{Keys: make([][]int, height), Scale: s, Root: root}
+	for y := range l.Keys {
+		l.Keys[y] = make([]int, width)
+		for x := range l.Keys[y] {
+			key := keyOf(x, height-1-y)
+			if key < 0 || key > 127 {
+				key = -1
+			}
+			l.Keys[y][x] = key
+		}
+	}
+	return l
+}
+
+// Returns the key that a button plays, if any.
+func (l Layout) Key(x, y int) (key int, ok bool) {
+	if y < 0 || y >= len(l.Keys) || x < 0 || x >= len(l.Keys[y]) || l.Keys[y][x] < 0 {
+		return 0, false
+	}
+	return l.Keys[y][x], true
+}
+
+// Returns a map of the key numbers of buttons, such as those of
+// (*Launchpad).KeyNum, to the keys that they play, as NewLaunchpad transposes by.
+func (l Layout) NoteMap(keyNum func(row, column int) int) map[int]int {
+	noteMap := make(map[int]int)
+	for y := range l.Keys {
+		for x, key := range l.Keys[y] {
+			if key >= 0 {
+				noteMap[keyNum(y, x)] = key
+			}
+		}
+	}
+	return noteMap
+}
+
+// Returns rows of the colours of the buttons: that of root for the roots of the
+// scale, in for the other keys of the scale and out for the keys outside of it.
+// The blocks of drum layouts are of root and in alternately.
+func (l Layout) Colors(root, in, out Color) [][]Color {
+	rows := make([][]Color, len(l.Keys))
+	for y := range l.Keys {
+		rows[y] = make([]Color, len(l.Keys[y]))
+		for x, key := range l.Keys[y] {
+			rows[y][x] = l.color(key, root, in, out)
+		}
+	}
+	return rows
+}
+
+func (l Layout) color(key int, root, in, out Color) Color {
+	switch {
+	case key < 0:
+		return Off
+	case l.Block > 0:
+		if (key-l.Root)/l.Block%2 == 0 {
+			return root
+		}
+		return in
+	case l.Scale == nil:
+		return out
+	}
+	interval := ((key-l.Root)%midi.Octave + midi.Octave) % midi.Octave
+	if interval == 0 {
+		return root
+	}
+	for _, i := range l.Scale {
+		if i == interval {
+			return in
+		}
+	}
+	return out
+}
+
+// Lights the buttons of a grid as per the colours of the layout.
+func (l Layout) Light(g Grid, root, in, out Color) error {
+	return g.SetFrame(l.Colors(root, in, out))
+}
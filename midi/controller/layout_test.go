@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestLayoutKeys(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		layout Layout
+		x, y   int
+		key    int
+	}{
+		{"fourths", IsomorphicLayout(8, 8, 40, Fourths, midi.Major, 48), 0, 7, 40},
+		{"fourths", IsomorphicLayout(8, 8, 40, Fourths, midi.Major, 48), 3, 6, 48},
+		{"wicki-hayden", IsomorphicLayout(8, 8, 48, WickiHayden, nil, 0), 2, 6, 57},
+		{"harmonic table", IsomorphicLayout(8, 8, 36, HarmonicTable, nil, 0), 1, 6, 43},
+		{"scale", ScaleLayout(8, 8, midi.Major, 60, 3), 0, 7, 60},
+		{"scale", ScaleLayout(8, 8, midi.Major, 60, 3), 7, 7, 72},
+		{"scale", ScaleLayout(8, 8, midi.Major, 60, 3), 1, 6, 67},
+		{"drums", DrumLayout(8, 8, 36), 0, 7, 36},
+		{"drums", DrumLayout(8, 8, 36), 3, 4, 51},
+		{"drums", DrumLayout(8, 8, 36), 4, 7, 52},
+		{"drums", DrumLayout(8, 8, 36), 0, 3, 68},
+	} {
+		if key, ok := c.layout.Key(c.x, c.y); !ok || key != c.key {
+			t.Errorf("The %v layout plays %v at %v, %v instead of %v", c.name, key, c.x, c.y, c.key)
+		}
+	}
+	if key, ok := IsomorphicLayout(8, 8, 100, Fifths, nil, 0).Key(7, 0); ok {
+		t.Errorf("A button plays key %v, beyond the range of keys", key)
+	}
+}
+
+func TestLayoutNoteMap(t *testing.T) {
+	l := NewLaunchpad(NewEmulator(), nil)
+	noteMap := DrumLayout(8, 8, 36).NoteMap(l.KeyNum)
+	if len(noteMap) != 64 {
+		t.Errorf("Mapped %v buttons instead of 64", len(noteMap))
+	}
+	for button, key := range map[int]int{112: 36, 115: 39, 116: 52, 0: 80} {
+		if noteMap[button] != key {
+			t.Errorf("Mapped button %v to %v instead of %v", button, noteMap[button], key)
+		}
+	}
+}
+
+func TestLayoutColors(t *testing.T) {
+	root, in, out := Color{G: 255}, Color{R: 255}, Off
+	colors := IsomorphicLayout(8, 8, 48, Fourths, midi.MajorPentatonic, 48).Colors(root, in, out)
+	for x, expected := range []Color{root, out, in, out, in, out, out, in} {
+		if colors[7][x] != expected {
+			t.Errorf("Lit button %v, 7 %v instead of %v", x, colors[7][x], expected)
+		}
+	}
+	colors = DrumLayout(8, 8, 36).Colors(root, in, out)
+	if colors[7][0] != root || colors[7][4] != in || colors[3][0] != root {
+		t.Errorf("Lit the blocks of drums %v, %v and %v", colors[7][0], colors[7][4], colors[3][0])
+	}
+}
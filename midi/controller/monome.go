@@ -1,93 +1,666 @@
 package controller
 
 import (
+	"bufio"
 	"fmt"
-	"github.com/aoeu/audio/midi"
-	"github.com/tarm/goserial"
 	"io"
-)
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-// TODO(aoeu): Update to github.com/tarm/serial
-// TODO(aoeu): Assert this code even potentially works against hardware.
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/osc"
+	"github.com/tarm/serial"
+)
 
 const (
-	debug      = false
-	bufferSize = 10
-	baudRate   = 115200
+	baudRate = 115200
+	// The port that serialosc receives discovery requests on.
+	serialoscPort = 12002
+	// How long to wait for a grid to report its size once it is opened.
+	sizeTimeout = 500 * time.Millisecond
 )
 
-// Implements Device
+// A Key is a press or release of a button of a grid controller, at its column X
+// and row Y from the top left. It is sent as a note of the key number of the
+// button, as for a Launchpad's buttons, so that it can be routed as MIDI data:
+// 16 times its row plus its column, on channel 0 for rows 0 to 7 and channel 1
+// for rows 8 to 15 of larger grids. Buttons above the grid, such as a
+// Launchpad's top row, are sent as its control changes from 104.
+type Key struct {
+	X, Y    int
+	Pressed bool
+	Time    midi.Timestamp
+}
+
+func (k Key) Uint32() uint32 {
+	if k.Y < 0 {
+		return midi.ControlChange{ID: 104 + k.X, Value: 127 * state(k.Pressed)}.Uint32()
+	}
+	channel, key := keyNumber(k.X, k.Y)
+	if k.Pressed {
+		return midi.NoteOn{Channel: channel, Key: key, Velocity: 127}.Uint32()
+	}
+	return midi.NoteOff{Channel: channel, Key: key}.Uint32()
+}
+
+// Returns the channel and key number of the note of a button.
+func keyNumber(x, y int) (channel, key int) {
+	return y / 8, 16*(y%8) + x
+}
+
+// Returns the button of the channel and key number of a note.
+func noteButton(channel, key int) (x, y int) {
+	return key % 16, 8*channel + key/16
+}
+
+func (k Key) Timestamp() midi.Timestamp {
+	return k.Time
+}
+
+// A Tilt is a reading of the accelerometer of a grid.
+type Tilt struct {
+	Sensor  int
+	X, Y, Z int
+}
+
+// A size that a grid reported.
+type gridSize struct {
+	width, height int
+}
+
+// A monomeConn is a connection to a grid, directly over its serial port or through serialosc.
+type monomeConn interface {
+	open() error
+	// Sends a command, by its address relative to the grid's serialosc prefix,
+	// e.g. "grid/led/set" with the arguments x, y and state.
+	send(address string, args ...int) error
+	// Requests the grid's size.
+	requestSize() error
+	// Returns the next Key, Tilt or gridSize of the grid.
+	next() (interface{}, error)
+	close() error
+}
+
+// A Monome is a monome grid, sending its key presses and releases out as Keys.
+// LEDs are lit with its methods, or by sending notes of key numbers to it, with a
+// velocity of 127 at full brightness. Grids with varibright show levels from 0 to
+// 15, and others show levels of 8 and above as lit.
 type Monome struct {
-	// TODO(aoeu): Figure out how to write to the monome and implement an input port.
-	out *Port
+	*midi.Wires
+	Width, Height int       // The size of the grid, known once it is opened.
+	Tilts         chan Tilt // Readings of tilt sensors that are enabled. Dropped if not received.
+	conn          monomeConn
+	sizes         chan gridSize
+	disconnect    chan bool
+	closed        chan bool
+	closing       sync.Once
 }
 
-func NewMonome() *Monome {
+func newMonome(c monomeConn) *Monome {
 	return &Monome{
-		out: NewPort(),
+		Wires:      midi.NewWires(),
+		Tilts:      make(chan Tilt, 16),
+		conn:       c,
+		sizes:      make(chan gridSize, 1),
+		disconnect: make(chan bool, 1),
+		closed:     make(chan bool),
 	}
 }
 
-type Port struct {
-	midi.Port
-	devicePath string
-	serialPort io.ReadWriteCloser
-	serialData chan []byte
+// Creates a new monome grid at the path of its serial port, e.g. "/dev/ttyUSB0",
+// that is spoken to with the monome extended (mext) serial protocol.
+func NewMonome(path string) *Monome {
+	return newMonome(&mextConn{path: path})
+}
+
+// Creates a new monome grid that serialosc serves on a port of the local host.
+func NewSerialoscMonome(port int) *Monome {
+	return newMonome(&serialoscConn{port: port, prefix: "/monome"})
 }
 
-func NewPort() *Port {
-	return &Port{
-		midi.Port:  midi.NewPort(false),
-		devicePath: "/dev/tty.usbserial-m64-0851", // TODO(aoeu): Don't hardcode the device.
-		serialData: make(chan []byte),
+// Opens the grid, starting to read its keys, and waits briefly for its size.
+func (m *Monome) Open() error {
+	if err := m.conn.open(); err != nil {
+		return err
 	}
+	go m.read()
+	if err := m.conn.requestSize(); err != nil {
+		return err
+	}
+	select {
+	case s := <-m.sizes:
+		m.Width, m.Height = s.width, s.height
+	case <-time.After(sizeTimeout):
+		m.Width, m.Height = 8, 8
+	}
+	return nil
 }
 
-func (p *Port) Open() error {
-	p.isOpen = true
-	c := &serial.Config{Name: p.devicePath, Baud: baudRate}
-	var err error
-	p.serialPort, err = serial.OpenPort(c)
+// Closes the grid, turning off its LEDs.
+func (m *Monome) Close() (err error) {
+	m.closing.Do(func() {
+		m.disconnect <- true
+		close(m.closed)
+		m.All(false)
+		err = m.conn.close()
+	})
 	return err
 }
 
-func (p *Port) Close() error {
-	p.serialPort.Close()
-	p.midi.Port.Close()
+func (m *Monome) read() {
+	for {
+		e, err := m.conn.next()
+		if err != nil {
+			return
+		}
+		switch e := e.(type) {
+		case Key:
+			e.Time = midi.Now()
+			select {
+			case m.Out <- e:
+			case <-m.closed:
+				return
+			}
+		case Tilt:
+			select {
+			case m.Tilts <- e:
+			default:
+			}
+		case gridSize:
+			select {
+			case m.sizes <- e:
+			default:
+			}
+		}
+	}
 }
 
-func (p *Port) Connect() {
-	go p.readFromSerialPort()
+// Lights the LEDs of the notes sent to the grid, by channel and key number as
+// Keys are sent.
+func (m *Monome) Connect() {
 	for {
 		select {
-		case <-p.disconnect:
-			// TODO(aoeu): Is this needed? p.disconnect <- true
+		case msg := <-m.In:
+			switch n := msg.(type) {
+			case midi.NoteOn:
+				x, y := noteButton(n.Channel, n.Key)
+				m.SetLevel(x, y, n.Velocity/8)
+			case midi.NoteOff:
+				x, y := noteButton(n.Channel, n.Key)
+				m.SetLevel(x, y, 0)
+			case Key:
+				m.Set(n.X, n.Y, n.Pressed)
+			}
+		case <-m.disconnect:
 			return
-		case msg := <-p.serialData:
-			msgType := msg[0]
-			buttonNum := int(msg[1])
-			switch msgType {
-			case 0: // Note On
-				p.messages <- midi.NoteOn{Channel: 0, Key: buttonNum, Velocity: 127}
-			case 16: // Note Off
-				p.messages <- midi.NoteOff{Channel: 0, Key: buttonNum}
+		}
+	}
+}
+
+func (m *Monome) Wire() *midi.Wires {
+	return m.Wires
+}
+
+func state(on bool) int {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// Turns an LED on or off.
+func (m *Monome) Set(x, y int, on bool) error {
+	return m.conn.send("grid/led/set", x, y, state(on))
+}
+
+// Turns all LEDs on or off.
+func (m *Monome) All(on bool) error {
+	return m.conn.send("grid/led/all", state(on))
+}
+
+// Sets the LEDs of an 8 by 8 quad at offsets that are multiples of 8, from a
+// bitmask of each row with the leftmost LED in the lowest bit.
+func (m *Monome) Map(xOffset, yOffset int, rows [8]byte) error {
+	args := []int{xOffset, yOffset}
+	for _, r := range rows {
+		args = append(args, int(r))
+	}
+	return m.conn.send("grid/led/map", args...)
+}
+
+// Sets the LEDs of a row from bitmasks of 8 LEDs each, starting at an offset that is a multiple of 8.
+func (m *Monome) Row(xOffset, y int, masks ...byte) error {
+	return m.conn.send("grid/led/row", append([]int{xOffset, y}, bytesToInts(masks)...)...)
+}
+
+// Sets the LEDs of a column from bitmasks of 8 LEDs each, the topmost in the lowest bit.
+func (m *Monome) Column(x, yOffset int, masks ...byte) error {
+	return m.conn.send("grid/led/col", append([]int{x, yOffset}, bytesToInts(masks)...)...)
+}
+
+// Sets the brightness of all lit LEDs, from 0 to 15.
+func (m *Monome) Intensity(level int) error {
+	return m.conn.send("grid/led/intensity", level)
+}
+
+// Sets the level of an LED, from 0 (off) to 15.
+func (m *Monome) SetLevel(x, y, level int) error {
+	return m.conn.send("grid/led/level/set", x, y, level)
+}
+
+// Sets the level of all LEDs.
+func (m *Monome) AllLevel(level int) error {
+	return m.conn.send("grid/led/level/all", level)
+}
+
+// Sets the levels of an 8 by 8 quad, row by row.
+func (m *Monome) MapLevel(xOffset, yOffset int, levels [64]int) error {
+	return m.conn.send("grid/led/level/map", append([]int{xOffset, yOffset}, levels[:]...)...)
+}
+
+// Sets the levels of a row, in groups of 8, starting at an offset that is a multiple of 8.
+func (m *Monome) RowLevel(xOffset, y int, levels ...int) error {
+	return m.conn.send("grid/led/level/row", append([]int{xOffset, y}, levels...)...)
+}
+
+// Sets the levels of a column, in groups of 8.
+func (m *Monome) ColumnLevel(x, yOffset int, levels ...int) error {
+	return m.conn.send("grid/led/level/col", append([]int{x, yOffset}, levels...)...)
+}
+
+// Enables or disables the readings of a tilt sensor.
+func (m *Monome) EnableTilt(sensor int, enabled bool) error {
+	return m.conn.send("tilt/set", sensor, state(enabled))
+}
+
+func bytesToInts(b []byte) []int {
+	ints := make([]int, len(b))
+	for i, v := range b {
+		ints[i] = int(v)
+	}
+	return ints
+}
+
+// A MonomeInfo describes a grid that was discovered.
+type MonomeInfo struct {
+	ID   string // The serial number of the grid, e.g. "m1000010".
+	Type string // The model, e.g. "monome 128", if reported by serialosc.
+	Port int    // The serialosc port of the grid, or 0 if it was found on a serial port.
+	Path string // The serial port of the grid, if it was found on one.
+}
+
+// Returns a new Monome for the grid.
+func (i MonomeInfo) Monome() *Monome {
+	if i.Port != 0 {
+		return NewSerialoscMonome(i.Port)
+	}
+	return NewMonome(i.Path)
+}
+
+// Returns the grids that serialosc serves, or if serialosc does not reply within
+// a timeout, the grids on serial ports, which serialosc would otherwise hold open.
+func DiscoverMonomes(timeout time.Duration) ([]MonomeInfo, error) {
+	found, err := serialoscMonomes(timeout)
+	if err == nil && len(found) > 0 {
+		return found, nil
+	}
+	return serialMonomes(), nil
+}
+
+// Patterns of the serial ports of grids, whose FTDI serial numbers begin with 'm'.
+var serialPatterns = []string{
+	"/dev/tty.usbserial-m*",
+	"/dev/serial/by-id/usb-monome*",
+}
+
+func serialMonomes() []MonomeInfo {
+	var found []MonomeInfo
+	for _, pattern := range serialPatterns {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			id := filepath.Base(path)
+			if i := strings.LastIndex(id, "-m"); i >= 0 {
+				id = id[i+1:]
 			}
+			found = append(found, MonomeInfo{ID: id, Path: path})
 		}
 	}
+	return found
 }
 
-func (p *Port) readFromSerialPort() {
-	buffer := make([]byte, 128)
-	for {
-		select {
-		case <-p.disconnect:
-			p.disconnect <- true
+func serialoscMonomes(timeout time.Duration) ([]MonomeInfo, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	var found []MonomeInfo
+	mux := osc.NewMux()
+	mux.HandleFunc("/serialosc/device", func(msg osc.Message, from net.Addr) {
+		if len(msg.Arguments) != 3 {
 			return
+		}
+		id, _ := msg.Arguments[0].(string)
+		model, _ := msg.Arguments[1].(string)
+		port, _ := msg.Arguments[2].(int32)
+		mu.Lock()
+		found = append(found, MonomeInfo{ID: id, Type: model, Port: int(port)})
+		mu.Unlock()
+	})
+	s := &osc.Server{Handler: mux}
+	go s.Serve(conn)
+	defer s.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+	list := osc.Message{Address: "/serialosc/list", Arguments: []interface{}{"127.0.0.1", int32(local.Port)}}
+	if err := s.SendTo(list, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: serialoscPort}); err != nil {
+		return nil, err
+	}
+	time.Sleep(timeout)
+	mu.Lock()
+	defer mu.Unlock()
+	return found, nil
+}
+
+// A connection to a grid through serialosc, which sends and receives OSC messages
+// at addresses under a prefix on behalf of the grid.
+type serialoscConn struct {
+	port   int // The port that serialosc serves the grid on.
+	prefix string
+	server *osc.Server
+	client *osc.Client
+	events chan interface{}
+	closed chan bool
+}
+
+func (c *serialoscConn) open() error {
+	var err error
+	if c.client, err = osc.Dial(net.JoinHostPort("127.0.0.1", strconv.Itoa(c.port))); err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		c.client.Close()
+		return err
+	}
+	c.events = make(chan interface{}, 64)
+	c.closed = make(chan bool)
+	mux := osc.NewMux()
+	mux.HandleFunc(c.prefix+"/grid/key", func(msg osc.Message, from net.Addr) {
+		if v, ok := ints(msg, 3); ok {
+			c.receive(Key{X: v[0], Y: v[1], Pressed: v[2] != 0})
+		}
+	})
+	mux.HandleFunc(c.prefix+"/tilt", func(msg osc.Message, from net.Addr) {
+		if v, ok := ints(msg, 4); ok {
+			c.receive(Tilt{Sensor: v[0], X: v[1], Y: v[2], Z: v[3]})
+		}
+	})
+	mux.HandleFunc("/sys/size", func(msg osc.Message, from net.Addr) {
+		if v, ok := ints(msg, 2); ok {
+			c.receive(gridSize{v[0], v[1]})
+		}
+	})
+	c.server = &osc.Server{Handler: mux}
+	go c.server.Serve(conn)
+	local := conn.LocalAddr().(*net.UDPAddr)
+	for _, msg := range []osc.Message{
+		{Address: "/sys/port", Arguments: []interface{}{int32(local.Port)}},
+		{Address: "/sys/host", Arguments: []interface{}{"127.0.0.1"}},
+		{Address: "/sys/prefix", Arguments: []interface{}{c.prefix}},
+	} {
+		if err := c.client.Send(msg); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *serialoscConn) receive(e interface{}) {
+	select {
+	case c.events <- e:
+	case <-c.closed:
+	}
+}
+
+// Returns the integer arguments of a message with a number of them.
+func ints(msg osc.Message, n int) ([]int, bool) {
+	if len(msg.Arguments) != n {
+		return nil, false
+	}
+	v := make([]int, n)
+	for i, a := range msg.Arguments {
+		switch a := a.(type) {
+		case int32:
+			v[i] = int(a)
+		case float32:
+			v[i] = int(a)
 		default:
-			n, _ := p.serialPort.Read(buffer)
-			for i := 0; i < n; i += 2 {
-				p.serialData <- []byte{buffer[i], buffer[i+1]}
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func (c *serialoscConn) send(address string, args ...int) error {
+	msg := osc.Message{Address: c.prefix + "/" + address}
+	for _, a := range args {
+		msg.Arguments = append(msg.Arguments, int32(a))
+	}
+	return c.client.Send(msg)
+}
+
+func (c *serialoscConn) requestSize() error {
+	return c.client.Send(osc.Message{Address: "/sys/info"})
+}
+
+func (c *serialoscConn) next() (interface{}, error) {
+	select {
+	case e := <-c.events:
+		return e, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *serialoscConn) close() error {
+	if c.client == nil {
+		return nil
+	}
+	close(c.closed)
+	c.server.Close()
+	return c.client.Close()
+}
+
+// Commands of the mext serial protocol that are sent to grids, in sections by their upper nibble.
+const (
+	mextSize        = 0x05
+	mextLEDSet      = 0x10 // | state
+	mextLEDAll      = 0x12 // | state
+	mextLEDMap      = 0x14
+	mextLEDRow      = 0x15
+	mextLEDColumn   = 0x16
+	mextIntensity   = 0x17
+	mextLevelSet    = 0x18
+	mextLevelAll    = 0x19
+	mextLevelMap    = 0x1A
+	mextLevelRow    = 0x1B
+	mextLevelColumn = 0x1C
+	mextTiltEnable  = 0x82
+	mextTiltDisable = 0x83
+)
+
+// Commands of the mext serial protocol that are received from grids.
+const (
+	mextKeyUp   = 0x20
+	mextKeyDown = 0x21
+	mextTilt    = 0x81
+)
+
+// The lengths of the commands that are received, including the command byte.
+var mextLengths = map[byte]int{
+	0x00:        3,  // Query response: section and number.
+	0x01:        33, // ID.
+	0x02:        3,  // Grid offset.
+	0x03:        3,  // Grid size, of older firmware.
+	mextSize:    3,
+	0x0F:        9, // Firmware version.
+	mextKeyUp:   3,
+	mextKeyDown: 3,
+	0x80:        3, // Tilt sensors that are active.
+	mextTilt:    8,
+}
+
+// A connection to a grid over its serial port with the monome extended protocol.
+type mextConn struct {
+	path string
+	rw   io.ReadWriteCloser
+	r    *bufio.Reader
+	mu   sync.Mutex
+}
+
+func (c *mextConn) open() error {
+	if c.rw == nil {
+		p, err := serial.OpenPort(&serial.Config{Name: c.path, Baud: baudRate})
+		if err != nil {
+			return err
+		}
+		c.rw = p
+	}
+	c.r = bufio.NewReader(c.rw)
+	return nil
+}
+
+// Encodes a command by its serialosc address.
+func mextEncode(address string, args ...int) ([]byte, error) {
+	arg := func(i int) byte {
+		if i < len(args) {
+			return byte(args[i])
+		}
+		return 0
+	}
+	var b []byte
+	switch address {
+	case "grid/led/set":
+		b = []byte{mextLEDSet | arg(2)&1, arg(0), arg(1)}
+	case "grid/led/all":
+		b = []byte{mextLEDAll | arg(0)&1}
+	case "grid/led/map":
+		b = []byte{mextLEDMap, arg(0), arg(1)}
+		for i := 2; i < 10; i++ {
+			b = append(b, arg(i))
+		}
+	case "grid/led/row", "grid/led/col":
+		command := byte(mextLEDRow)
+		if address == "grid/led/col" {
+			command = mextLEDColumn
+		}
+		for i := 2; i < len(args); i++ { // One command for each 8 LEDs.
+			x, y := arg(0), arg(1)
+			if command == mextLEDRow {
+				x += byte(8 * (i - 2))
+			} else {
+				y += byte(8 * (i - 2))
+			}
+			b = append(b, command, x, y, arg(i))
+		}
+	case "grid/led/intensity":
+		b = []byte{mextIntensity, arg(0)}
+	case "grid/led/level/set":
+		b = []byte{mextLevelSet, arg(0), arg(1), arg(2)}
+	case "grid/led/level/all":
+		b = []byte{mextLevelAll, arg(0)}
+	case "grid/led/level/map":
+		b = append([]byte{mextLevelMap, arg(0), arg(1)}, packLevels(args[2:], 64)...)
+	case "grid/led/level/row", "grid/led/level/col":
+		command := byte(mextLevelRow)
+		if address == "grid/led/level/col" {
+			command = mextLevelColumn
+		}
+		for i := 2; i < len(args); i += 8 {
+			x, y := arg(0), arg(1)
+			if command == mextLevelRow {
+				x += byte(i - 2)
+			} else {
+				y += byte(i - 2)
 			}
+			b = append(b, command, x, y)
+			b = append(b, packLevels(args[i:], 8)...)
+		}
+	case "tilt/set":
+		if arg(1) != 0 {
+			b = []byte{mextTiltEnable, arg(0)}
+		} else {
+			b = []byte{mextTiltDisable, arg(0)}
 		}
+	default:
+		return nil, fmt.Errorf("Unsupported monome command %v.", address)
+	}
+	return b, nil
+}
+
+// Packs a number of levels two to a byte, the first in the upper nibble.
+func packLevels(levels []int, n int) []byte {
+	b := make([]byte, n/2)
+	for i := 0; i < n && i < len(levels); i++ {
+		b[i/2] |= byte(levels[i]&0x0F) << uint(4*(1-i%2))
+	}
+	return b
+}
+
+func (c *mextConn) send(address string, args ...int) error {
+	b, err := mextEncode(address, args...)
+	if err != nil {
+		return err
+	}
+	return c.write(b)
+}
+
+func (c *mextConn) write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.rw.Write(b)
+	return err
+}
+
+func (c *mextConn) requestSize() error {
+	return c.write([]byte{mextSize})
+}
+
+// Reads commands until one that is a Key, Tilt or gridSize, skipping others.
+func (c *mextConn) next() (interface{}, error) {
+	for {
+		command, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		n, ok := mextLengths[command]
+		if !ok {
+			continue // Skip bytes until a known command, to resynchronize.
+		}
+		b := make([]byte, n-1)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		switch command {
+		case mextKeyUp, mextKeyDown:
+			return Key{X: int(b[0]), Y: int(b[1]), Pressed: command == mextKeyDown}, nil
+		case mextTilt:
+			axis := func(i int) int { return int(int16(uint16(b[i])<<8 | uint16(b[i+1]))) }
+			return Tilt{Sensor: int(b[0]), X: axis(1), Y: axis(3), Z: axis(5)}, nil
+		case mextSize, 0x03:
+			return gridSize{int(b[0]), int(b[1])}, nil
+		}
+	}
+}
+
+func (c *mextConn) close() error {
+	if c.rw == nil {
+		return nil
 	}
+	return c.rw.Close()
 }
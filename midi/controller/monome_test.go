@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/osc"
+)
+
+func TestMextEncode(t *testing.T) {
+	tests := []struct {
+		address  string
+		args     []int
+		expected []byte
+	}{
+		{"grid/led/set", []int{3, 4, 1}, []byte{0x11, 3, 4}},
+		{"grid/led/all", []int{0}, []byte{0x12}},
+		{"grid/led/map", []int{8, 0, 1, 2, 3, 4, 5, 6, 7, 8}, []byte{0x14, 8, 0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"grid/led/row", []int{0, 5, 0xFF, 0x0F}, []byte{0x15, 0, 5, 0xFF, 0x15, 8, 5, 0x0F}},
+		{"grid/led/col", []int{2, 0, 0x81}, []byte{0x16, 2, 0, 0x81}},
+		{"grid/led/level/set", []int{1, 2, 15}, []byte{0x18, 1, 2, 15}},
+		{"grid/led/level/row", []int{0, 1, 1, 2, 3, 4, 5, 6, 7, 8}, []byte{0x1B, 0, 1, 0x12, 0x34, 0x56, 0x78}},
+		{"tilt/set", []int{0, 1}, []byte{0x82, 0}},
+		{"tilt/set", []int{0, 0}, []byte{0x83, 0}},
+	}
+	for _, test := range tests {
+		actual, err := mextEncode(test.address, test.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, test.expected) {
+			t.Errorf("Encoded %v %v as % X instead of % X", test.address, test.args, actual, test.expected)
+		}
+	}
+	if _, err := mextEncode("grid/led/unknown"); err == nil {
+		t.Errorf("Encoded an unsupported command")
+	}
+}
+
+func TestMonomeSerial(t *testing.T) {
+	host, device := net.Pipe()
+	m := newMonome(&mextConn{rw: host})
+	go func() {
+		b := make([]byte, 1)
+		io.ReadFull(device, b) // The request of the grid's size.
+		device.Write([]byte{0x05, 16, 8, 0x99, 0x21, 3, 2, 0x81, 0, 0x01, 0x00, 0xFF, 0xFF, 0, 0})
+	}()
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 16 || m.Height != 8 {
+		t.Errorf("The grid's size is %vx%v instead of 16x8", m.Width, m.Height)
+	}
+	select {
+	case k := <-m.Out:
+		if k, ok := k.(Key); !ok || k.X != 3 || k.Y != 2 || !k.Pressed {
+			t.Errorf("Received %+v instead of a press at 3, 2", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive a key")
+	}
+	select {
+	case tilt := <-m.Tilts:
+		if expected := (Tilt{0, 256, -1, 0}); tilt != expected {
+			t.Errorf("Received %+v instead of %+v", tilt, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive a tilt")
+	}
+	go m.SetLevel(1, 2, 15)
+	b := make([]byte, 4)
+	io.ReadFull(device, b)
+	if expected := []byte{0x18, 1, 2, 15}; !bytes.Equal(b, expected) {
+		t.Errorf("Sent % X instead of % X", b, expected)
+	}
+	device.Close()
+	m.Close()
+}
+
+func TestMonomeSerialosc(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var host *net.UDPAddr
+	leds := make(chan osc.Message, 8)
+	serialosc := &osc.Server{}
+	mux := osc.NewMux()
+	mux.HandleFunc("/sys/port", func(m osc.Message, from net.Addr) {
+		host = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(m.Arguments[0].(int32))}
+	})
+	mux.HandleFunc("/sys/info", func(m osc.Message, from net.Addr) {
+		serialosc.SendTo(osc.Message{Address: "/sys/size", Arguments: []interface{}{int32(8), int32(16)}}, host)
+		serialosc.SendTo(osc.Message{Address: "/monome/grid/key", Arguments: []interface{}{int32(7), int32(15), int32(1)}}, host)
+	})
+	mux.HandleFunc("/monome/grid/led/set", func(m osc.Message, from net.Addr) {
+		leds <- m
+	})
+	mux.HandleFunc("/monome/grid/led/all", func(m osc.Message, from net.Addr) {
+		leds <- m
+	})
+	serialosc.Handler = mux
+	go serialosc.Serve(conn)
+	defer serialosc.Close()
+
+	m := NewSerialoscMonome(conn.LocalAddr().(*net.UDPAddr).Port)
+	if err := m.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 8 || m.Height != 16 {
+		t.Errorf("The grid's size is %vx%v instead of 8x16", m.Width, m.Height)
+	}
+	select {
+	case k := <-m.Out:
+		if k, ok := k.(Key); !ok || k.X != 7 || k.Y != 15 || !k.Pressed {
+			t.Errorf("Received %+v instead of a press at 7, 15", k)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive a key")
+	}
+	m.Set(7, 15, true)
+	m.Close()
+	for _, expected := range []osc.Message{
+		{Address: "/monome/grid/led/set", Arguments: []interface{}{int32(7), int32(15), int32(1)}},
+		{Address: "/monome/grid/led/all", Arguments: []interface{}{int32(0)}},
+	} {
+		select {
+		case actual := <-leds:
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Sent %v instead of %v", actual, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not send %v", expected)
+		}
+	}
+}
+
+func TestKeyNumbers(t *testing.T) {
+	for _, c := range []struct {
+		key      Key
+		expected midi.Message
+	}{
+		{Key{X: 3, Y: 2, Pressed: true}, midi.NoteOn{Key: 35, Velocity: 127}},
+		{Key{X: 15, Y: 15, Pressed: true}, midi.NoteOn{Channel: 1, Key: 127, Velocity: 127}},
+		{Key{X: 0, Y: 8}, midi.NoteOff{Channel: 1, Key: 0}},
+		{Key{X: 2, Y: -1, Pressed: true}, midi.ControlChange{ID: 106, Value: 127}},
+	} {
+		if u := c.key.Uint32(); u != c.expected.Uint32() {
+			t.Errorf("Sent %+v as %#x instead of %#x", c.key, u, c.expected.Uint32())
+		}
+		if c.key.Y < 0 {
+			continue
+		}
+		channel, key := keyNumber(c.key.X, c.key.Y)
+		if x, y := noteButton(channel, key); x != c.key.X || y != c.key.Y {
+			t.Errorf("The note of button %v, %v is of button %v, %v", c.key.X, c.key.Y, x, y)
+		}
+	}
+}
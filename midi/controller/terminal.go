@@ -0,0 +1,296 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Escape sequences of the terminal.
+const (
+	enableMouse  = "\x1b[?1000h\x1b[?1006h" // Report button presses and releases with SGR coordinates.
+	disableMouse = "\x1b[?1006l\x1b[?1000l"
+	hideCursor   = "\x1b[?25l"
+	showCursor   = "\x1b[?25h"
+	clearScreen  = "\x1b[2J"
+	home         = "\x1b[H"
+	resetColor   = "\x1b[0m"
+)
+
+// The width of a button on the screen, in columns.
+const buttonWidth = 4
+
+// A Terminal is an Emulator of a Launchpad that is drawn in a terminal, so that
+// instruments can be played without a grid controller. Its buttons are pressed
+// by clicking them, or by moving a cursor with the arrow keys (or h, j, k and l)
+// and pressing space or enter. Pressing q or Ctrl-C interrupts the program.
+type Terminal struct {
+	*Emulator
+	in         io.Reader
+	out        io.Writer
+	cursor     sync.Mutex
+	row        int // The row and column of the cursor, in a Frame.
+	column     int
+	clicked    bool // Whether a button is clicked, at the row and column of the click.
+	click      input
+	moves      chan bool
+	disconnect chan bool
+	closing    sync.Once
+	sttyState  string // The state of the terminal before it was put in raw mode.
+}
+
+// Creates a new Terminal of the standard input and output.
+func NewTerminal() *Terminal {
+	return newTerminal(os.Stdin, os.Stdout)
+}
+
+func newTerminal(in io.Reader, out io.Writer) *Terminal {
+	return &Terminal{
+		Emulator:   NewEmulator(),
+		in:         in,
+		out:        out,
+		row:        1,
+		moves:      make(chan bool, 1),
+		disconnect: make(chan bool, 1),
+	}
+}
+
+// Puts the terminal in raw mode and enables mouse reporting.
+func (t *Terminal) Open() error {
+	if t.in == os.Stdin {
+		state, err := stty("-g")
+		if err != nil {
+			return err
+		}
+		t.sttyState = strings.TrimSpace(state)
+		if _, err := stty("raw", "-echo"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(t.out, enableMouse+hideCursor+clearScreen)
+	return err
+}
+
+// Restores the terminal.
+func (t *Terminal) Close() (err error) {
+	t.closing.Do(func() {
+		t.disconnect <- true
+		t.Emulator.Close()
+		io.WriteString(t.out, resetColor+disableMouse+showCursor+"\r\n")
+		if t.sttyState != "" {
+			_, err = stty(t.sttyState)
+		}
+	})
+	return err
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// Lights the LEDs as per the MIDI data sent to the terminal, drawing them as they
+// change, and presses the buttons as per the input of the terminal.
+func (t *Terminal) Connect() {
+	go t.Emulator.Connect()
+	go t.read()
+	t.draw()
+	for {
+		select {
+		case <-t.Updates:
+		case <-t.moves:
+		case <-t.disconnect:
+			return
+		}
+		t.draw()
+	}
+}
+
+func (t *Terminal) draw() {
+	t.cursor.Lock()
+	row, column := t.row, t.column
+	t.cursor.Unlock()
+	t.out.Write(render(t.LEDs(), row, column))
+}
+
+// Renders a frame of LEDs, with the button at a row and column under the cursor.
+func render(f Frame, cursorRow, cursorColumn int) []byte {
+	var b bytes.Buffer
+	b.WriteString(home)
+	for row := range f {
+		for column, color := range f[row] {
+			if row == 0 && column == 8 {
+				b.WriteString(strings.Repeat(" ", buttonWidth))
+				continue
+			}
+			left, right := " ", " "
+			if row == cursorRow && column == cursorColumn {
+				left, right = "[", "]"
+			}
+			fmt.Fprintf(&b, "%v%v%v██%v%v", resetColor, left, ansiColor(color), resetColor, right)
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString("click, or move with arrows and press with space; q quits\r\n")
+	return b.Bytes()
+}
+
+// Returns the escape sequence of the foreground colour of a velocity colour code.
+func ansiColor(color int) string {
+	red, green := color&0x03, color>>4&0x03
+	if red == 0 && green == 0 {
+		return "\x1b[38;2;48;48;48m" // Dark grey, for LEDs that are off.
+	}
+	return fmt.Sprintf("\x1b[38;2;%v;%v;0m", red*85, green*85)
+}
+
+// Kinds of terminal input.
+const (
+	inputMove = iota
+	inputPress
+	inputClick
+	inputUnclick
+	inputQuit
+)
+
+// An input of the terminal: a movement of the cursor by a number of rows and columns,
+// a press of the button under the cursor, or a click or its release at a row and column.
+type input struct {
+	kind        int
+	row, column int
+}
+
+// Parses the complete inputs of some bytes read from the terminal, returning the
+// bytes of an incomplete escape sequence.
+func parseInput(b []byte) (inputs []input, rest []byte) {
+	for len(b) > 0 {
+		switch b[0] {
+		case 'q', 0x03: // Ctrl-C.
+			inputs = append(inputs, input{kind: inputQuit})
+		case ' ', '\r', '\n':
+			inputs = append(inputs, input{kind: inputPress})
+		case 'k':
+			inputs = append(inputs, input{inputMove, -1, 0})
+		case 'j':
+			inputs = append(inputs, input{inputMove, 1, 0})
+		case 'l':
+			inputs = append(inputs, input{inputMove, 0, 1})
+		case 'h':
+			inputs = append(inputs, input{inputMove, 0, -1})
+		case 0x1b:
+			in, n := parseEscape(b)
+			if n == 0 {
+				return inputs, b
+			}
+			if in != nil {
+				inputs = append(inputs, *in)
+			}
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return inputs, nil
+}
+
+// Parses an escape sequence, returning its input, if it is one, and its length,
+// or 0 if it is incomplete.
+func parseEscape(b []byte) (*input, int) {
+	if len(b) < 3 {
+		return nil, 0
+	}
+	if b[1] != '[' {
+		return nil, 1
+	}
+	switch b[2] {
+	case 'A':
+		return &input{inputMove, -1, 0}, 3
+	case 'B':
+		return &input{inputMove, 1, 0}, 3
+	case 'C':
+		return &input{inputMove, 0, 1}, 3
+	case 'D':
+		return &input{inputMove, 0, -1}, 3
+	case '<': // A mouse report: "\x1b[<button;x;y" then 'M' when pressed or 'm' when released.
+		end := bytes.IndexAny(b, "Mm")
+		if end < 0 {
+			return nil, 0
+		}
+		fields := strings.Split(string(b[3:end]), ";")
+		if len(fields) != 3 || fields[0] != "0" { // Only the left button.
+			return nil, end + 1
+		}
+		x, _ := strconv.Atoi(fields[1])
+		y, _ := strconv.Atoi(fields[2])
+		kind := inputClick
+		if b[end] == 'm' {
+			kind = inputUnclick
+		}
+		return &input{kind, y - 1, (x - 1) / buttonWidth}, end + 1
+	}
+	return nil, 3
+}
+
+func validButton(row, column int) bool {
+	return row >= 0 && row <= 8 && column >= 0 && column <= 8 && !(row == 0 && column == 8)
+}
+
+// Reads the input of the terminal, pressing buttons as per it.
+func (t *Terminal) read() {
+	b := make([]byte, 256)
+	var rest []byte
+	for {
+		n, err := t.in.Read(b)
+		if err != nil {
+			return
+		}
+		var inputs []input
+		inputs, rest = parseInput(append(rest, b[:n]...))
+		for _, in := range inputs {
+			t.handle(in)
+		}
+	}
+}
+
+func (t *Terminal) handle(in input) {
+	switch in.kind {
+	case inputMove:
+		t.cursor.Lock()
+		if validButton(t.row+in.row, t.column+in.column) {
+			t.row, t.column = t.row+in.row, t.column+in.column
+		}
+		t.cursor.Unlock()
+		select {
+		case t.moves <- true:
+		default:
+		}
+	case inputPress:
+		t.cursor.Lock()
+		row, column := t.row, t.column
+		t.cursor.Unlock()
+		t.Press(row, column)
+		t.Release(row, column)
+	case inputClick:
+		if validButton(in.row, in.column) {
+			t.clicked, t.click = true, in
+			t.Press(in.row, in.column)
+		}
+	case inputUnclick: // Released where the button was clicked, wherever the mouse is.
+		if t.clicked {
+			t.clicked = false
+			t.Release(t.click.row, t.click.column)
+		}
+	case inputQuit:
+		t.Close()
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(os.Interrupt)
+		}
+	}
+}
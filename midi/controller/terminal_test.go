@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestParseInput(t *testing.T) {
+	inputs, rest := parseInput([]byte("jl \x1b[D\x1b[<0;6;3M\x1b[<2;1;1M\x1b[<0;6;3m\x1b[<0;1"))
+	expected := []input{
+		{inputMove, 1, 0},
+		{inputMove, 0, 1},
+		{kind: inputPress},
+		{inputMove, 0, -1},
+		{inputClick, 2, 1},
+		{inputUnclick, 2, 1},
+	}
+	if !reflect.DeepEqual(inputs, expected) {
+		t.Errorf("Parsed %v instead of %v", inputs, expected)
+	}
+	if string(rest) != "\x1b[<0;1" {
+		t.Errorf("Returned %q of an incomplete escape sequence", rest)
+	}
+}
+
+func TestRender(t *testing.T) {
+	f := NewFrame(Black)
+	f[1][0] = Red
+	b := render(f, 1, 0)
+	if !bytes.Contains(b, []byte("["+ansiColor(Red)+"██")) {
+		t.Errorf("Did not draw the cursor over the red LED: %q", b)
+	}
+	if ansiColor(Green) != "\x1b[38;2;0;255;0m" || ansiColor(AmberLow) != "\x1b[38;2;85;85;0m" {
+		t.Errorf("Converted colours to %q and %q", ansiColor(Green), ansiColor(AmberLow))
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	r, w := io.Pipe()
+	term := newTerminal(r, ioutil.Discard)
+	l := NewLaunchpad(term, map[int]int{})
+	if err := l.Open(); err != nil {
+		t.Fatal(err)
+	}
+	go l.Connect()
+	defer l.Close()
+
+	go w.Write([]byte("\x1b[<0;6;3M"))
+	if m := <-l.Out; m.Uint32() != (midi.NoteOn{Key: l.KeyNum(1, 1), Velocity: 127}).Uint32() {
+		t.Errorf("Clicking a button sent %v", m)
+	}
+	go w.Write([]byte("\x1b[<0;30;9m"))
+	if m := <-l.Out; m.Uint32() != (midi.NoteOff{Key: l.KeyNum(1, 1)}).Uint32() {
+		t.Errorf("Releasing a click sent %v", m)
+	}
+	go w.Write([]byte("\x1b[Al "))
+	for _, expected := range []midi.Message{
+		midi.ControlChange{ID: 105, Value: 127},
+		midi.ControlChange{ID: 105, Value: 0},
+	} {
+		if m := <-l.Out; m.Uint32() != expected.Uint32() {
+			t.Errorf("Pressing space sent %v instead of %v", m, expected)
+		}
+	}
+}
@@ -10,9 +10,21 @@ On Device implementations:
     TransposerDevice: A "fake" device that can be piped or chained
         to other devices in order to manipulate or transpose
         the MIDI data coming through it.
+    Processor: A "fake" device that filters and maps the MIDI
+        data coming through it with composable Processes.
+    Player: A "fake" device that plays the MIDI data of a
+        Standard MIDI File out of its output port in real-time.
+    Recorder: A thru device that records the MIDI data routed
+        through it to a Standard MIDI File.
+    Euclidean: A "fake" device that plays Euclidean rhythms and
+        polyrhythms by a MIDI clock that it keeps or follows.
+    Arpeggiator: A "fake" device that plays the notes held on it
+        one after another by a MIDI clock that it keeps or follows.
+    Quantizer: A "fake" device that snaps the notes coming through
+        it to a scale, optionally playing them as diatonic chords.
 */
 
-import "github.com/aoeu/audio/midi/portmidi"
+import "errors"
 
 type Wires struct {
 	In  chan Message // MIDI Messages inbound to the device are received from the In channel.
@@ -26,6 +38,15 @@ func NewWires() *Wires {
 	}
 }
 
+// A Wirer is any Device implementation that can be associated with other
+// devices by a Connector, which transmits MIDI data over the device's Wires.
+type Wirer interface {
+	Opener
+	Closer
+	Connecter
+	Wire() *Wires
+}
+
 type Device struct {
 	in  *Port
 	out *Port
@@ -63,6 +84,10 @@ func (s Device) Connect() {
 	}
 }
 
+func (d Device) Wire() *Wires {
+	return d.Wires
+}
+
 // Implements Device, used to route MIDI data.
 type ThruDevice struct {
 	in         *Port
@@ -74,100 +99,221 @@ type ThruDevice struct {
 // Creates a new thru device.
 func NewThruDevice() *ThruDevice {
 	return &ThruDevice{
-		in:         &Port{},
-		out:        &Port{},
+		in:         NewPort(false),
+		out:        NewPort(false),
 		disconnect: make(chan bool, 1),
 		Wires:      NewWires(),
 	}
 }
 
+func (t *ThruDevice) Open() error {
+	if err := t.in.Open(); err != nil {
+		return err
+	}
+	return t.out.Open()
+}
+
+// Stops routing data and closes the thru device.
+func (t *ThruDevice) Close() error {
+	t.disconnect <- true
+	if err := t.in.Close(); err != nil {
+		return err
+	}
+	return t.out.Close()
+}
+
 // Routes data through the thru device.
 func (t ThruDevice) Connect() {
 	for {
 		select {
-		case t.Out <- <-t.In:
+		case m := <-t.In:
+			select {
+			case t.Out <- m:
+			case <-t.disconnect:
+				return
+			}
 		case <-t.disconnect:
 			return
 		}
 	}
 }
 
+func (t ThruDevice) Wire() *Wires {
+	return t.Wires
+}
+
 // Represents a software or hardware MIDI device on the system.
 type SystemDevice struct { // Implements Device
-	in  *SystemInPort
-	out *SystemOutPort
+	in      *SystemInPort
+	out     *SystemOutPort
+	backend Backend
 	Wires
 	Name string
 }
 
 func (s SystemDevice) Open() error {
 	// TODO(aoeu): Ramify with Device.Open()
-	err := s.in.Open()
-	if err != nil {
-		return err
+	if s.in != nil {
+		if err := s.in.Open(); err != nil {
+			return err
+		}
+	}
+	if s.out != nil {
+		return s.out.Open()
 	}
-	return s.out.Open()
+	return nil
 }
 
 func (s SystemDevice) Close() error {
-	err := s.in.SystemPort.Close()
-	if err != nil {
-		return err
+	if s.in != nil {
+		if err := s.in.Close(); err != nil {
+			return err
+		}
 	}
-	err = s.out.SystemPort.Close()
-	return err
+	if s.out != nil {
+		return s.out.Close()
+	}
+	return nil
 }
 
 func (s SystemDevice) Connect() {
-	if s.in.isOpen {
+	if s.in != nil && s.in.isOpen {
 		go s.in.Connect()
 	}
-	if s.out.isOpen {
+	if s.out != nil && s.out.isOpen {
 		go s.out.Connect()
 	}
 }
 
-func getSystemDevices() SystemDevices {
-	devices := make(map[string]SystemDevice)
-	for i := 0; i < portmidi.NumStreams(); i++ {
-		streamInfo := portmidi.NewStreamInfo(i)
-		if _, ok := devices[streamInfo.Name]; !ok {
-			devices[streamInfo.Name] = SystemDevice{
-				Name: streamInfo.Name,
-			}
+func (s SystemDevice) Wire() *Wires {
+	return &s.Wires
+}
+
+// Locks the ports of the device so that their streams may be replaced.
+func (s SystemDevice) lock() {
+	if s.in != nil {
+		s.in.mu.Lock()
+	}
+	if s.out != nil {
+		s.out.mu.Lock()
+	}
+}
+
+func (s SystemDevice) unlock() {
+	if s.in != nil {
+		s.in.mu.Unlock()
+	}
+	if s.out != nil {
+		s.out.mu.Unlock()
+	}
+}
+
+// Closes the streams of the device's ports. The device must be locked.
+func (s SystemDevice) suspend() {
+	if s.in != nil {
+		s.in.suspend()
+	}
+	if s.out != nil {
+		s.out.suspend()
+	}
+}
+
+// Replaces the streams of the device's ports with the streams of a device of the
+// same name that was found once its backend was rescanned, reopening the streams
+// of the ports that are open but offline. The device must be locked.
+func (s SystemDevice) resume(streams []StreamInfo) error {
+	for _, stream := range streams {
+		switch {
+		case stream.IsOutput && s.in != nil:
+			s.in.id = stream.ID
+		case stream.IsInput && s.out != nil:
+			s.out.id = stream.ID
 		}
-		sp := SystemPort{
-			Port: *NewPort(streamInfo.IsOpen),
+	}
+	if s.in != nil {
+		if err := s.in.resume(); err != nil {
+			return err
 		}
-		d := devices[streamInfo.Name]
+	}
+	if s.out != nil {
+		return s.out.resume()
+	}
+	return nil
+}
+
+// Returns the streams of each device of a backend, by device name.
+func systemStreams(b Backend) map[string][]StreamInfo {
+	streams := make(map[string][]StreamInfo)
+	for _, stream := range b.Streams() {
+		streams[stream.Name] = append(streams[stream.Name], stream)
+	}
+	return streams
+}
+
+func newSystemDevice(b Backend, name string, streams []StreamInfo) SystemDevice {
+	d := SystemDevice{Name: name, backend: b}
+	for _, stream := range streams {
 		switch {
-		case streamInfo.IsOutput: // An output stream is for an input port.
-			d.in = &SystemInPort{SystemPort: sp, Output: portmidi.NewOutput(i)}
+		case stream.IsOutput: // An output stream is for an input port.
+			d.in = &SystemInPort{
+				SystemPort: SystemPort{Port: *NewPort(false), backend: b, id: stream.ID},
+			}
 			d.Wires.In = d.in.messages
-		case streamInfo.IsInput: // An input stream is for an output port.
-			d.out = &SystemOutPort{SystemPort: sp, Input: portmidi.NewInput(i)}
+		case stream.IsInput: // An input stream is for an output port.
+			d.out = &SystemOutPort{
+				SystemPort: SystemPort{Port: *NewPort(false), backend: b, id: stream.ID},
+			}
 			d.Wires.Out = d.out.messages
 		}
-		devices[streamInfo.Name] = d
+	}
+	return d
+}
+
+func getSystemDevices(b Backend) SystemDevices {
+	devices := make(map[string]SystemDevice)
+	for name, streams := range systemStreams(b) {
+		devices[name] = newSystemDevice(b, name, streams)
 	}
 	return devices
 }
 
 type SystemDevices map[string]SystemDevice
 
-// This function will cause terrible errors if called. Do not use it.
+// Closes all of the devices and terminates their backend.
+// No system device may be used afterward.
 func (s *SystemDevices) Shutdown() error {
 	var err error
+	backends := make(map[Backend]bool)
 	m := map[string]SystemDevice(*s)
 	for _, device := range m {
-		err = device.Close()
+		if e := device.Close(); e != nil {
+			err = e
+		}
+		backends[device.backend] = true
+	}
+	if len(backends) == 0 && DefaultBackend != nil {
+		backends[DefaultBackend] = true
+	}
+	for b := range backends {
+		if e := b.Terminate(); e != nil {
+			err = e
+		}
 	}
-	err = portmidi.Terminate()
 	return err
 }
 
+// Returns the devices of the DefaultBackend.
 func GetDevices() (SystemDevices, error) {
-	return getSystemDevices(), portmidi.Initialize()
+	if DefaultBackend == nil {
+		return SystemDevices{}, errors.New("There is no default MIDI backend; import one such as github.com/aoeu/audio/midi/portmidi.")
+	}
+	return GetBackendDevices(DefaultBackend)
+}
+
+// Initializes a backend and returns its devices.
+func GetBackendDevices(b Backend) (SystemDevices, error) {
+	err := b.Initialize()
+	return getSystemDevices(b), err
 }
 
 // Implements Device
@@ -184,8 +330,8 @@ type Transposition func(Transposer)
 
 func NewTransposer(noteMap map[int]int, transposeFunc Transposition) (t *Transposer) {
 	t = &Transposer{NoteMap: noteMap, Wires: NewWires()}
-	t.in = &Port{}
-	t.out = &Port{}
+	t.in = NewPort(false)
+	t.out = NewPort(false)
 	if transposeFunc == nil {
 		transposeFunc = func(t1 Transposer) {
 			for {
@@ -233,3 +379,7 @@ func (t Transposer) Close() (err error) {
 func (t Transposer) Connect() {
 	t.Transpose(t)
 }
+
+func (t Transposer) Wire() *Wires {
+	return t.Wires
+}
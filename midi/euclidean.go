@@ -0,0 +1,297 @@
+package midi
+
+import (
+	"sync"
+	"time"
+)
+
+// The IDs of the ControlChanges that set the parameters of a Euclidean's track
+// of the number of their channel.
+const (
+	EuclideanHits = 20 + iota
+	EuclideanSteps
+	EuclideanRotation
+	EuclideanLength
+	EuclideanKey
+	EuclideanVelocity
+)
+
+// Returns a Euclidean rhythm of hits spread as evenly as possible over steps,
+// rotated right by a number of steps, such as x..x..x. for 3 hits over 8 steps.
+func EuclideanRhythm(hits, steps, rotation int) []bool {
+	rhythm := make([]bool, steps)
+	if steps == 0 {
+		return rhythm
+	}
+	for i := range rhythm {
+		j := ((i-rotation)%steps + steps) % steps
+		rhythm[i] = j*hits%steps < hits
+	}
+	return rhythm
+}
+
+// A EuclideanTrack plays a note on the hits of a Euclidean rhythm, whose steps
+// are of a number of MIDI clocks. Tracks of different steps and lengths of steps
+// play polyrhythms.
+type EuclideanTrack struct {
+	Hits, Steps, Rotation int
+	Length                int // In MIDI clocks, such as SixteenthNote.
+	Channel               int
+	Key                   int
+	Velocity              int
+}
+
+// A Euclidean is a device that plays Euclidean rhythms by MIDI clock, which it
+// either keeps at a tempo or follows from the clock, start, continue and stop
+// messages it receives. Notes last half a step.
+//
+// A ControlChange on a channel sets a parameter of the track of that number,
+// such as EuclideanHits to the value of the ControlChange.
+type Euclidean struct {
+	*ThruDevice
+	mu sync.Mutex
+	midiClock
+	tracks  []EuclideanTrack
+	rhythms [][]bool
+	offs    []scheduledOff
+	changed chan bool
+}
+
+// A NoteOff that is due at a clock.
+type scheduledOff struct {
+	clock int64
+	NoteOff
+}
+
+// Creates a new Euclidean of tracks, keeping a clock of 120 BPM.
+func NewEuclidean(tracks ...EuclideanTrack) *Euclidean {
+	e := &Euclidean{
+		ThruDevice: NewThruDevice(),
+		midiClock:  newMIDIClock(),
+		changed:    make(chan bool, 1),
+	}
+	for _, t := range tracks {
+		e.tracks = append(e.tracks, t)
+		e.rhythms = append(e.rhythms, EuclideanRhythm(t.Hits, t.Steps, t.Rotation))
+	}
+	return e
+}
+
+// Plays the rhythms while the Euclidean is playing, and follows the messages
+// it receives, until it is closed.
+func (e *Euclidean) Connect() {
+	for {
+		e.mu.Lock()
+		var messages []Message
+		if !e.playing {
+			messages = e.release(Now())
+		}
+		var due <-chan time.Time
+		timer := e.timer()
+		if timer != nil {
+			due = timer.C
+		}
+		e.mu.Unlock()
+		if !e.send(messages) {
+			return
+		}
+
+		select {
+		case <-due:
+			e.mu.Lock()
+			messages = e.tick(TimestampOf(e.at(e.count)))
+			e.mu.Unlock()
+		case m := <-e.In:
+			messages = e.receive(m)
+		case <-e.changed:
+			messages = nil
+		case <-e.disconnect:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !e.send(messages) {
+			return
+		}
+	}
+}
+
+func (e *Euclidean) send(messages []Message) bool {
+	for _, m := range messages {
+		select {
+		case e.Out <- m:
+		case <-e.disconnect:
+			return false
+		}
+	}
+	return true
+}
+
+// Follows a clock or ControlChange received, returning the notes that it plays.
+func (e *Euclidean) receive(m Message) []Message {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch m := m.(type) {
+	case ControlChange:
+		e.control(m)
+	case Realtime:
+		if at, ok := e.midiClock.receive(m); ok {
+			return e.tick(at)
+		}
+	}
+	return nil
+}
+
+// Sets a parameter of a track by a ControlChange. The Euclidean must be locked.
+func (e *Euclidean) control(c ControlChange) {
+	if c.Channel >= len(e.tracks) {
+		return
+	}
+	t := e.tracks[c.Channel]
+	switch c.ID {
+	case EuclideanHits:
+		t.Hits = c.Value
+	case EuclideanSteps:
+		if c.Value > 0 {
+			t.Steps = c.Value
+		}
+	case EuclideanRotation:
+		t.Rotation = c.Value
+	case EuclideanLength:
+		if c.Value > 0 {
+			t.Length = c.Value
+		}
+	case EuclideanKey:
+		t.Key = c.Value
+	case EuclideanVelocity:
+		if c.Value > 0 {
+			t.Velocity = c.Value
+		}
+	default:
+		return
+	}
+	e.setTrack(c.Channel, t)
+}
+
+// Plays a clock, returning the note offs and notes that are due at it.
+// The Euclidean must be locked.
+func (e *Euclidean) tick(at Timestamp) (messages []Message) {
+	i := 0
+	for _, off := range e.offs {
+		if off.clock <= e.count {
+			off.Time = at
+			messages = append(messages, off.NoteOff)
+			continue
+		}
+		e.offs[i] = off
+		i++
+	}
+	e.offs = e.offs[:i]
+	for n, t := range e.tracks {
+		if t.Length <= 0 || t.Steps <= 0 || e.count%int64(t.Length) != 0 {
+			continue
+		}
+		step := e.count / int64(t.Length) % int64(t.Steps)
+		if !e.rhythms[n][step] {
+			continue
+		}
+		messages = append(messages, NoteOn{t.Channel, t.Key, t.Velocity, at})
+		gate := t.Length / 2
+		if gate < 1 {
+			gate = 1
+		}
+		e.offs = append(e.offs, scheduledOff{e.count + int64(gate), NoteOff{t.Channel, t.Key, 0, 0}})
+	}
+	e.count++
+	return messages
+}
+
+// Returns note offs for the notes that are sounding. The Euclidean must be locked.
+func (e *Euclidean) release(at Timestamp) (offs []Message) {
+	for _, off := range e.offs {
+		off.Time = at
+		offs = append(offs, off.NoteOff)
+	}
+	e.offs = nil
+	return offs
+}
+
+// Signals the Connect loop that playback was changed. The Euclidean must be locked.
+func (e *Euclidean) notify() {
+	select {
+	case e.changed <- true:
+	default:
+	}
+}
+
+// Starts (or continues) keeping the clock, unless the clock is followed.
+func (e *Euclidean) Start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.start() {
+		e.notify()
+	}
+}
+
+// Stops playback, releasing any sounding notes.
+func (e *Euclidean) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.playing = false
+	e.notify()
+}
+
+// Moves playback to the first step of every track.
+func (e *Euclidean) Rewind() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rewind()
+	e.notify()
+}
+
+// Sets whether the Euclidean follows the clock it receives, or keeps its own.
+func (e *Euclidean) Follow(follow bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setFollow(follow)
+	e.notify()
+}
+
+// Returns the tempo of the clock kept, in beats per minute.
+func (e *Euclidean) Tempo() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.tempo
+}
+
+// Sets the tempo of the clock kept, in beats per minute, from the next clock.
+func (e *Euclidean) SetTempo(bpm float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setTempo(bpm)
+	e.notify()
+}
+
+// Returns the tracks of rhythms.
+func (e *Euclidean) Tracks() []EuclideanTrack {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]EuclideanTrack(nil), e.tracks...)
+}
+
+// Sets a track, from its next step.
+func (e *Euclidean) SetTrack(i int, t EuclideanTrack) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setTrack(i, t)
+}
+
+// The Euclidean must be locked.
+func (e *Euclidean) setTrack(i int, t EuclideanTrack) {
+	if t.Hits > t.Steps {
+		t.Hits = t.Steps
+	}
+	e.tracks[i] = t
+	e.rhythms[i] = EuclideanRhythm(t.Hits, t.Steps, t.Rotation)
+}
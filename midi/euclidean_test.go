@@ -0,0 +1,104 @@
+package midi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEuclideanRhythm(t *testing.T) {
+	for _, c := range []struct {
+		hits, steps, rotation int
+		rhythm                string
+	}{
+		{3, 8, 0, "x..x..x."},
+		{3, 8, 2, "x.x..x.."},
+		{4, 4, 0, "xxxx"},
+		{0, 3, 0, "..."},
+		{2, 3, 0, "x.x"},
+	} {
+		var rhythm string
+		for _, hit := range EuclideanRhythm(c.hits, c.steps, c.rotation) {
+			if hit {
+				rhythm += "x"
+			} else {
+				rhythm += "."
+			}
+		}
+		if rhythm != c.rhythm {
+			t.Errorf("E(%v, %v) rotated by %v is %v instead of %v", c.hits, c.steps, c.rotation, rhythm, c.rhythm)
+		}
+	}
+}
+
+func TestEuclidean(t *testing.T) {
+	e := NewEuclidean(
+		EuclideanTrack{Hits: 3, Steps: 8, Length: 6, Key: 36, Velocity: 100},
+		EuclideanTrack{Hits: 2, Steps: 3, Length: 8, Channel: 1, Key: 38, Velocity: 100},
+	)
+	e.Follow(true)
+	go e.Connect()
+	defer e.Close()
+	go func() {
+		e.In <- Realtime{Status: START}
+		for i := 0; i < ClocksPerQuarter; i++ {
+			e.In <- Realtime{Status: TIMING_CLOCK, Time: Timestamp(i + 1)} // Clocks are stamped from 1, as 0 is unknown.
+		}
+		e.In <- ControlChange{Channel: 0, ID: EuclideanHits, Value: 8}
+		for i := 0; i < 6; i++ {
+			e.In <- Realtime{Status: TIMING_CLOCK, Time: Timestamp(ClocksPerQuarter + i + 1)}
+		}
+	}()
+
+	// A tresillo of sixteenth notes, against 2 of 3 triplet eighth notes, and
+	// then every sixteenth note once it has 8 hits.
+	type note struct {
+		on    bool
+		key   int
+		clock Timestamp
+	}
+	expected := []note{
+		{true, 36, 0}, {true, 38, 0}, {false, 36, 3}, {false, 38, 4},
+		{true, 38, 16}, {true, 36, 18}, {false, 38, 20}, {false, 36, 21},
+		{true, 36, 24}, {true, 38, 24}, {false, 36, 27}, {false, 38, 28},
+	}
+	for _, n := range expected {
+		var actual note
+		select {
+		case m := <-e.Out:
+			switch m := m.(type) {
+			case NoteOn:
+				actual = note{true, m.Key, m.Time - 1}
+			case NoteOff:
+				actual = note{false, m.Key, m.Time - 1}
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive %+v", n)
+		}
+		if actual != n {
+			t.Errorf("Received %+v instead of %+v", actual, n)
+		}
+	}
+}
+
+func TestEuclideanTempo(t *testing.T) {
+	e := NewEuclidean(EuclideanTrack{Hits: 1, Steps: 1, Length: 1, Key: 60, Velocity: 100})
+	e.SetTempo(600) // Clocks of about 4 milliseconds.
+	go e.Connect()
+	defer e.Close()
+	e.Start()
+	var last Timestamp
+	for i := 0; i < 4; i++ {
+		select {
+		case m := <-e.Out:
+			if n, ok := m.(NoteOn); ok {
+				if last != 0 && n.Time-last != Timestamp(time.Minute/600/ClocksPerQuarter) {
+					t.Errorf("Played notes %v apart instead of a clock apart", n.Time-last)
+				}
+				last = n.Time
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Did not play a note by the clock kept")
+		}
+	}
+	e.Stop()
+}
@@ -0,0 +1,208 @@
+package midi
+
+import (
+	"errors"
+	"sync"
+)
+
+// The number of messages an input stream of a Loopback buffers before dropping any.
+const loopbackBufferSize = 512
+
+// Implements Backend with virtual buses that loop the MIDI data written to them
+// back to whatever reads from them, like the IAC buses of OS X or the
+// "Midi Through" ports of ALSA, without any MIDI devices or C libraries.
+// Each bus is a device with an input stream and an output stream.
+// Buses that are added or removed are only found once the loopback is
+// reinitialized or refreshed.
+type Loopback struct {
+	mu      sync.Mutex
+	buses   []*loopbackBus
+	streams []StreamInfo
+	found   []*loopbackBus // The bus of each stream, by ID.
+}
+
+type loopbackBus struct {
+	name    string
+	removed bool
+	inputs  map[*loopbackInput]bool
+}
+
+// Creates a new loopback backend with buses of the specified names.
+func NewLoopback(names ...string) *Loopback {
+	l := &Loopback{}
+	for _, name := range names {
+		l.AddBus(name)
+	}
+	return l
+}
+
+// Adds a bus, as if a device was plugged in.
+func (l *Loopback) AddBus(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buses = append(l.buses, &loopbackBus{name: name, inputs: make(map[*loopbackInput]bool)})
+}
+
+// Removes a bus, as if a device was unplugged. Its open streams fail from then on.
+func (l *Loopback) RemoveBus(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, b := range l.buses {
+		if b.name == name {
+			b.removed = true
+			l.buses = append(l.buses[:i], l.buses[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *Loopback) Initialize() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.streams, l.found = nil, nil
+	for _, b := range l.buses {
+		id := len(l.streams)
+		l.streams = append(l.streams,
+			StreamInfo{ID: id, Name: b.name, IsInput: true},
+			StreamInfo{ID: id + 1, Name: b.name, IsOutput: true},
+		)
+		l.found = append(l.found, b, b)
+	}
+	return nil
+}
+
+// Finds the buses that were added or removed since the loopback was initialized.
+// Open streams of buses that were not removed remain usable.
+func (l *Loopback) Refresh() error {
+	return l.Initialize()
+}
+
+func (l *Loopback) Terminate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.streams, l.found = nil, nil
+	return nil
+}
+
+func (l *Loopback) Streams() []StreamInfo {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]StreamInfo{}, l.streams...)
+}
+
+// Returns the bus of a stream, if the stream is of the type specified.
+// The loopback's mutex must be held.
+func (l *Loopback) bus(id int, isInput bool) (*loopbackBus, error) {
+	if id < 0 || id >= len(l.streams) || l.streams[id].IsInput != isInput {
+		return nil, errors.New("Invalid stream ID.")
+	}
+	b := l.found[id]
+	if b.removed {
+		return nil, errors.New("The device of the stream was removed.")
+	}
+	return b, nil
+}
+
+func (l *Loopback) OpenInput(id int) (InputStream, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, err := l.bus(id, true)
+	if err != nil {
+		return nil, err
+	}
+	i := &loopbackInput{
+		loopback: l,
+		bus:      b,
+		messages: make(chan loopbackMessage, loopbackBufferSize),
+	}
+	b.inputs[i] = true
+	return i, nil
+}
+
+func (l *Loopback) OpenOutput(id int) (OutputStream, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, err := l.bus(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return &loopbackOutput{loopback: l, bus: b}, nil
+}
+
+type loopbackMessage struct {
+	message   uint32
+	timestamp Timestamp
+	sysex     []byte
+}
+
+type loopbackInput struct {
+	loopback *Loopback
+	bus      *loopbackBus
+	messages chan loopbackMessage
+	sysex    []byte // The system exclusive message that was last read.
+}
+
+func (i *loopbackInput) Poll() (bool, error) {
+	i.loopback.mu.Lock()
+	defer i.loopback.mu.Unlock()
+	if i.bus.removed {
+		return false, errors.New("The device of the stream was removed.")
+	}
+	return len(i.messages) > 0, nil
+}
+
+func (i *loopbackInput) Read() (uint32, Timestamp, error) {
+	select {
+	case m := <-i.messages:
+		i.sysex = m.sysex
+		return m.message, m.timestamp, nil
+	default:
+		return 0, 0, nil
+	}
+}
+
+func (i *loopbackInput) ReadSysEx() []byte {
+	return i.sysex
+}
+
+func (i *loopbackInput) Close() error {
+	i.loopback.mu.Lock()
+	defer i.loopback.mu.Unlock()
+	delete(i.bus.inputs, i)
+	return nil
+}
+
+type loopbackOutput struct {
+	loopback *Loopback
+	bus      *loopbackBus
+}
+
+// Sends a message to every input stream of the bus immediately, keeping its
+// Timestamp, or stamping it with the current time if it has none.
+// Messages are dropped for any input stream whose buffer is full.
+func (o *loopbackOutput) Write(m Message) error {
+	o.loopback.mu.Lock()
+	defer o.loopback.mu.Unlock()
+	if o.bus.removed {
+		return errors.New("The device of the stream was removed.")
+	}
+	t := m.Timestamp()
+	if t == 0 {
+		t = Now()
+	}
+	var sysex []byte
+	if s, ok := m.(SysEx); ok {
+		sysex = append([]byte{}, s.Data...)
+	}
+	for i := range o.bus.inputs {
+		select {
+		case i.messages <- loopbackMessage{m.Uint32(), t, sysex}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (o *loopbackOutput) Close() error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package midi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLoopback(t *testing.T) {
+	devices, err := GetBackendDevices(NewLoopback("Bus 1", "Bus 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("Found %v loopback devices instead of 2", len(devices))
+	}
+	pipe := NewPipe(devices["Bus 1"], devices["Bus 2"])
+	if err := pipe.Open(); err != nil {
+		t.Fatalf("Could not open pipe: %v", err)
+	}
+	go pipe.Connect()
+	defer pipe.Close()
+
+	expected := NoteOn{Channel: 0, Key: 64, Velocity: 127, Time: Now()}
+	devices["Bus 1"].In <- expected
+	if actual := <-devices["Bus 2"].Out; actual != expected {
+		t.Errorf("Received %v from loopback buses instead of %v", actual, expected)
+	}
+}
+
+func TestLoopbackSysEx(t *testing.T) {
+	devices, err := GetBackendDevices(NewLoopback("Bus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := devices["Bus"]
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	d.Connect()
+	defer d.Close()
+
+	inquiry := []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}
+	d.In <- SysEx{Data: inquiry}
+	if s, ok := (<-d.Out).(SysEx); !ok || !bytes.Equal(s.Data, inquiry) {
+		t.Errorf("Received %v instead of a device inquiry", s)
+	}
+}
+
+func TestLoopbackRealtime(t *testing.T) {
+	devices, err := GetBackendDevices(NewLoopback("Bus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := devices["Bus"]
+	if err := d.Open(); err != nil {
+		t.Fatal(err)
+	}
+	d.Connect()
+	defer d.Close()
+
+	for _, status := range []int{START, TIMING_CLOCK, STOP} {
+		d.In <- Realtime{Status: status}
+		if r, ok := (<-d.Out).(Realtime); !ok || r.Status != status {
+			t.Errorf("Received %v instead of a system real-time message of %#x", r, status)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 // Package midi defines high-level data types for MIDI data and high-level interfaces for MIDI Devices.
 package midi
 
+import "time"
+
 const (
 	BufferSize int = 1
 )
@@ -11,6 +13,14 @@ const (
 	CONTROL_CHANGE int = 176
 )
 
+// Statuses of system real-time messages.
+const (
+	TIMING_CLOCK int = 0xF8
+	START        int = 0xFA
+	CONTINUE     int = 0xFB
+	STOP         int = 0xFC
+)
+
 type Opener interface {
 	Open() error
 }
@@ -27,8 +37,56 @@ type Uint32er interface {
 	Uint32() uint32
 }
 
+type Timestamper interface {
+	Timestamp() Timestamp
+}
+
 type Message interface {
 	Uint32er
+	Timestamper
+}
+
+// A Timestamp is the time at which a Message was received or is to be sent,
+// as elapsed on the package's monotonic clock (see Now).
+// The zero Timestamp is for messages whose time is unknown or
+// that are to be sent as soon as possible.
+type Timestamp time.Duration
+
+var epoch = time.Now()
+
+// Returns the current time of the package's monotonic clock.
+func Now() Timestamp {
+	return Timestamp(time.Since(epoch))
+}
+
+// Returns the Timestamp of a point in time.
+func TimestampOf(t time.Time) Timestamp {
+	return Timestamp(t.Sub(epoch))
+}
+
+// Returns a copy of a Message with a different Timestamp.
+func Stamp(m Message, t Timestamp) Message {
+	switch n := m.(type) {
+	case NoteOn:
+		n.Time = t
+		return n
+	case NoteOff:
+		n.Time = t
+		return n
+	case ControlChange:
+		n.Time = t
+		return n
+	case SysEx:
+		n.Time = t
+		return n
+	case Realtime:
+		n.Time = t
+		return n
+	case message:
+		n.Time = t
+		return n
+	}
+	return m
 }
 
 type message struct {
@@ -36,6 +94,7 @@ type message struct {
 	Command int
 	Data1   int
 	Data2   int
+	Time    Timestamp
 }
 
 func newMessage(u uint32) *message {
@@ -51,6 +110,35 @@ func newMessage(u uint32) *message {
 	}
 }
 
+// Returns the Message of a raw message, with its status in the lowest byte as
+// returned by Uint32, or nil if its type is not supported by this package.
+func Decode(u uint32) Message {
+	return newMessage(u).typed()
+}
+
+// Returns the high-level Message for a raw message, or nil if its type is not supported.
+func (m message) typed() Message {
+	switch m.Command {
+	case NOTE_ON:
+		return NoteOn{m.Channel, m.Data1, m.Data2, m.Time}
+	case NOTE_OFF:
+		// A NoteOn with velocity 0 (Data2) is arguably a Note Off.
+		return NoteOff{m.Channel, m.Data1, 0, m.Time}
+	case CONTROL_CHANGE:
+		name, ok := ControlChangeNames[m.Data1]
+		if !ok {
+			name = "Unknown"
+		}
+		return ControlChange{m.Channel, m.Data1, m.Data2, name, m.Time}
+	case 0xF0:
+		switch status := m.Command + m.Channel; status {
+		case TIMING_CLOCK, START, CONTINUE, STOP:
+			return Realtime{status, m.Time}
+		}
+	}
+	return nil
+}
+
 func (m message) Uint32() uint32 {
 	status := m.Command + m.Channel
 	return ((uint32(m.Data2) << 16) & 0xFF0000) |
@@ -58,20 +146,33 @@ func (m message) Uint32() uint32 {
 		(uint32(status) & 0x0000FF)
 }
 
+func (m message) Timestamp() Timestamp {
+	return m.Time
+}
+
 type NoteOn struct {
 	Channel  int
 	Key      int
 	Velocity int
+	Time     Timestamp
 }
 
 func (n NoteOn) Uint32() uint32 {
-	return message{n.Channel, NOTE_ON, n.Key, n.Velocity}.Uint32()
+	return message{Channel: n.Channel, Command: NOTE_ON, Data1: n.Key, Data2: n.Velocity}.Uint32()
+}
+
+func (n NoteOn) Timestamp() Timestamp {
+	return n.Time
 }
 
 type NoteOff NoteOn
 
 func (n NoteOff) Uint32() uint32 {
-	return message{n.Channel, NOTE_OFF, n.Key, n.Velocity}.Uint32()
+	return message{Channel: n.Channel, Command: NOTE_OFF, Data1: n.Key, Data2: n.Velocity}.Uint32()
+}
+
+func (n NoteOff) Timestamp() Timestamp {
+	return n.Time
 }
 
 type ControlChange struct {
@@ -79,10 +180,46 @@ type ControlChange struct {
 	ID      int // a.k.a. Control Change "number"
 	Value   int
 	Name    string // What the ID is used for as per the General MIDI spec.
+	Time    Timestamp
 }
 
 func (c ControlChange) Uint32() uint32 {
-	return message{c.Channel, CONTROL_CHANGE, c.ID, c.Value}.Uint32()
+	return message{Channel: c.Channel, Command: CONTROL_CHANGE, Data1: c.ID, Data2: c.Value}.Uint32()
+}
+
+func (c ControlChange) Timestamp() Timestamp {
+	return c.Time
+}
+
+// A SysEx is a system exclusive message, of all of its bytes from 0xF0 to 0xF7.
+// Backends that can not transfer system exclusive messages drop them.
+type SysEx struct {
+	Data []byte
+	Time Timestamp
+}
+
+// Returns only the status of the system exclusive message.
+func (s SysEx) Uint32() uint32 {
+	return 0xF0
+}
+
+func (s SysEx) Timestamp() Timestamp {
+	return s.Time
+}
+
+// A Realtime is a system real-time message that synchronizes devices: a timing
+// clock, of which there are 24 per quarter note, or a start, continue or stop.
+type Realtime struct {
+	Status int
+	Time   Timestamp
+}
+
+func (r Realtime) Uint32() uint32 {
+	return uint32(r.Status) & 0xFF
+}
+
+func (r Realtime) Timestamp() Timestamp {
+	return r.Time
 }
 
 // General MIDI names for various ControlChange IDs.
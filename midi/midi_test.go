@@ -5,11 +5,10 @@ These tests require IAC buses to be created on an OS X system, named:
     Bus 1
     Bus 2
     Bus 3
+Tests of system devices that need no such buses use a Loopback backend instead.
 */
 
-import (
-	"testing"
-)
+import "testing"
 
 func testSystemDevice(t *testing.T) {
 	devices, _ := GetDevices()
@@ -28,12 +27,12 @@ func TestPipe(t *testing.T) {
 		t.Errorf("Could not open pipe: %v", err)
 	}
 	go pipe.Connect()
-	expected := NoteOn{0, 64, 127}
+	expected := NoteOn{Channel: 0, Key: 64, Velocity: 127, Time: Now()}
 	// Spoof a MIDI note coming into the device.
-	pipe.From.Out <- expected
-	actual := <-pipe.To.In
+	src.Out <- expected
+	actual := <-dst.In
 	if expected != actual {
-		t.Errorf("Received %q from pipe instead of %q", actual, expected)
+		t.Errorf("Received %v from pipe instead of %v", actual, expected)
 	}
 	pipe.Close()
 }
@@ -0,0 +1,287 @@
+package midi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/encoding/smf"
+)
+
+// A cue is a Message scheduled at an offset from the start of playback.
+type cue struct {
+	at time.Duration
+	Message
+}
+
+// A key of a note (on a channel) that has been played and not yet released.
+type soundingNote struct {
+	Channel int
+	Key     int
+}
+
+// Implements Device, playing a Standard MIDI File out of its Out channel in real-time.
+// Messages are timestamped with the time they were scheduled to be played at.
+type Player struct {
+	in  *Port
+	out *Port
+	*Wires
+	cues       []cue
+	length     time.Duration
+	mu         sync.Mutex
+	playing    bool
+	loop       bool
+	position   time.Duration // The position of playback while stopped.
+	started    time.Time     // When position 0 was (or would have been) played while playing.
+	next       int           // Index of the next cue to be played.
+	generation int           // Incremented on every change made by a control method.
+	silence    bool          // Set when notes that are still sounding need to be released.
+	sounding   map[soundingNote]bool
+	changed    chan bool
+	disconnect chan bool
+}
+
+// Creates a new player of the MIDI data within a Standard MIDI File.
+// Messages of types that are not supported by this package are skipped.
+func NewPlayer(f *smf.File) (*Player, error) {
+	timeline, err := f.Timeline()
+	if err != nil {
+		return &Player{}, err
+	}
+	p := &Player{
+		in:         NewPort(false),
+		out:        NewPort(false),
+		Wires:      NewWires(),
+		sounding:   make(map[soundingNote]bool),
+		changed:    make(chan bool, 1),
+		disconnect: make(chan bool, 1),
+	}
+	for _, e := range timeline {
+		p.length = e.Time
+		if !e.IsChannelMessage() {
+			continue
+		}
+		if m := newMessageFromEvent(e.Event); m != nil {
+			p.cues = append(p.cues, cue{e.Time, m})
+		}
+	}
+	return p, nil
+}
+
+// Creates a new player of the MIDI data within a Standard MIDI File on disk.
+func NewLoadedPlayer(fileName string) (*Player, error) {
+	f, err := smf.OpenFile(fileName)
+	if err != nil {
+		return &Player{}, err
+	}
+	return NewPlayer(f)
+}
+
+func newMessageFromEvent(e smf.Event) Message {
+	u := uint32(e.Status)
+	for i, b := range e.Data {
+		u |= uint32(b) << uint(8*(i+1))
+	}
+	m := newMessage(u)
+	if m.Command == NOTE_ON && m.Data2 == 0 {
+		return NoteOff{m.Channel, m.Data1, 0, 0}
+	}
+	return m.typed()
+}
+
+func (p *Player) Open() error {
+	if err := p.in.Open(); err != nil {
+		return err
+	}
+	return p.out.Open()
+}
+
+// Stops playback and closes the player.
+func (p *Player) Close() error {
+	p.disconnect <- true
+	if err := p.in.Close(); err != nil {
+		return err
+	}
+	return p.out.Close()
+}
+
+func (p *Player) Wire() *Wires {
+	return p.Wires
+}
+
+// Begins transmission of the player's MIDI data as it is played.
+func (p *Player) Connect() {
+	for {
+		p.mu.Lock()
+		silence := p.silence
+		p.silence = false
+		gen := p.generation
+		var due <-chan time.Time
+		var timer *time.Timer
+		var c cue
+		if p.playing {
+			at := p.length // Nothing left to play until the end of the file.
+			if p.next < len(p.cues) {
+				c = p.cues[p.next]
+				at = c.at
+			}
+			timer = time.NewTimer(time.Until(p.started.Add(at)))
+			due = timer.C
+		}
+		p.mu.Unlock()
+
+		if silence && !p.releaseNotes() {
+			return
+		}
+		select {
+		case <-due:
+			p.mu.Lock()
+			if gen != p.generation {
+				p.mu.Unlock()
+				continue
+			}
+			if c.Message == nil {
+				p.end()
+				p.mu.Unlock()
+				continue
+			}
+			p.next++
+			// Stamp the time the message was scheduled for rather than when the timer fired.
+			m := Stamp(c.Message, TimestampOf(p.started.Add(c.at)))
+			p.mu.Unlock()
+			if !p.send(m) {
+				return
+			}
+		case <-p.changed:
+		case <-p.disconnect:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Loops back to the start of the file or stops playback once the file has ended.
+// The player's mutex must be held.
+func (p *Player) end() {
+	p.next = 0
+	if p.loop && p.length > 0 {
+		p.started = p.started.Add(p.length)
+		return
+	}
+	p.playing = false
+	p.position = 0
+}
+
+func (p *Player) send(m Message) bool {
+	switch n := m.(type) {
+	case NoteOn:
+		p.sounding[soundingNote{n.Channel, n.Key}] = true
+	case NoteOff:
+		delete(p.sounding, soundingNote{n.Channel, n.Key})
+	}
+	select {
+	case p.Out <- m:
+		return true
+	case <-p.disconnect:
+		return false
+	}
+}
+
+// Sends a NoteOff for every note that the player has left sounding.
+func (p *Player) releaseNotes() bool {
+	for n := range p.sounding {
+		if !p.send(NoteOff{n.Channel, n.Key, 0, Now()}) {
+			return false
+		}
+	}
+	return true
+}
+
+// Signals the Connect loop that playback state was changed.
+// The player's mutex must be held.
+func (p *Player) notify() {
+	p.generation++
+	select {
+	case p.changed <- true:
+	default:
+	}
+}
+
+// Starts (or resumes) playback from the current position.
+func (p *Player) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.playing {
+		return
+	}
+	p.playing = true
+	p.started = time.Now().Add(-p.position)
+	p.notify()
+}
+
+// Stops (pauses) playback at the current position, releasing any sounding notes.
+func (p *Player) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.playing {
+		return
+	}
+	p.position = time.Since(p.started)
+	p.playing = false
+	p.silence = true
+	p.notify()
+}
+
+// Moves playback to a position from the start of the file, releasing any sounding notes.
+func (p *Player) Seek(position time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if position < 0 {
+		position = 0
+	}
+	if position > p.length {
+		position = p.length
+	}
+	p.next = len(p.cues)
+	for i, c := range p.cues {
+		if c.at >= position {
+			p.next = i
+			break
+		}
+	}
+	p.position = position
+	p.started = time.Now().Add(-position)
+	p.silence = true
+	p.notify()
+}
+
+// Sets whether playback restarts from the beginning once the end of the file is reached.
+func (p *Player) Loop(loop bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loop = loop
+	p.notify()
+}
+
+// Returns the current position of playback from the start of the file.
+func (p *Player) Position() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.playing {
+		return time.Since(p.started)
+	}
+	return p.position
+}
+
+// Returns true if the player is playing.
+func (p *Player) Playing() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.playing
+}
+
+// Returns the real-time playback length of the file.
+func (p *Player) Length() time.Duration {
+	return p.length
+}
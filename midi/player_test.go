@@ -0,0 +1,88 @@
+package midi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/encoding/smf"
+)
+
+// Creates a file where each quarter note lasts 10 milliseconds.
+func newTestFile() *smf.File {
+	f := smf.NewFile("")
+	f.Header.Division = 10
+	f.Tracks = []smf.Track{
+		{smf.NewTempoEvent(0, 10000)},
+		{
+			{Delta: 0, Status: 0x90, Data: []byte{60, 100}},
+			{Delta: 10, Status: 0x90, Data: []byte{60, 0}},
+			{Delta: 0, Status: 0xB1, Data: []byte{7, 64}},
+			{Delta: 10, Status: 0x80, Data: []byte{62, 0}},
+		},
+	}
+	return f
+}
+
+func TestPlayer(t *testing.T) {
+	player, err := NewPlayer(newTestFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := NewDevice()
+	pipe := NewPipe(player, dst)
+	if err := pipe.Open(); err != nil {
+		t.Errorf("Could not open pipe: %v", err)
+	}
+	go pipe.Connect()
+	start := time.Now()
+	player.Start()
+	expected := []Message{
+		NoteOn{0, 60, 100, 0},
+		NoteOff{0, 60, 0, 0},
+		ControlChange{1, 7, 64, ControlChangeNames[7], 0},
+		NoteOff{0, 62, 0, 0},
+	}
+	begin := TimestampOf(start)
+	at := []time.Duration{0, 10 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	for i, e := range expected {
+		actual := <-dst.In
+		if actual != Stamp(e, actual.Timestamp()) {
+			t.Errorf("Received %v from player instead of %v", actual, e)
+		}
+		// The player is started a moment after start.
+		if d := time.Duration(actual.Timestamp() - begin); d < at[i] || d > at[i]+5*time.Millisecond {
+			t.Errorf("Received %v stamped %v after start instead of %v", actual, d, at[i])
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Played a file of %v in %v", player.Length(), elapsed)
+	}
+	pipe.Close()
+}
+
+func TestPlayerSeekAndLoop(t *testing.T) {
+	player, err := NewPlayer(newTestFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.Open()
+	go player.Connect()
+	player.Seek(15 * time.Millisecond)
+	player.Loop(true)
+	player.Start()
+	expected := []Message{
+		NoteOff{0, 62, 0, 0},
+		NoteOn{0, 60, 100, 0},
+		NoteOff{0, 60, 0, 0},
+	}
+	for _, e := range expected {
+		if actual := <-player.Out; actual != Stamp(e, actual.Timestamp()) {
+			t.Errorf("Received %v from player instead of %v", actual, e)
+		}
+	}
+	player.Stop()
+	if player.Playing() {
+		t.Errorf("Player is playing after being stopped")
+	}
+	player.Close()
+}
@@ -0,0 +1,128 @@
+package portmidi
+
+import (
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// Makes portmidi the midi.DefaultBackend of programs that import this package,
+// unless they set another.
+func init() {
+	if midi.DefaultBackend == nil {
+		midi.DefaultBackend = NewBackend()
+	}
+}
+
+// Implements midi.Backend with the portmidi C library.
+type Backend struct {
+	Latency int32 // Milliseconds that data written to output streams is delayed by.
+}
+
+func NewBackend() *Backend {
+	return &Backend{Latency: DefaultLatency}
+}
+
+func (b *Backend) Initialize() error {
+	err := Initialize()
+	timeEpoch = midi.Now() - midi.Timestamp(time.Duration(Time())*time.Millisecond)
+	return err
+}
+
+func (b *Backend) Terminate() error {
+	return Terminate()
+}
+
+func (b *Backend) Streams() []midi.StreamInfo {
+	streams := make([]midi.StreamInfo, NumStreams())
+	for i := range streams {
+		info := NewStreamInfo(i)
+		streams[i] = midi.StreamInfo{
+			ID:       i,
+			Name:     info.Name,
+			IsInput:  info.IsInput,
+			IsOutput: info.IsOutput,
+		}
+	}
+	return streams
+}
+
+func (b *Backend) OpenInput(id int) (midi.InputStream, error) {
+	i := NewInput(id)
+	if err := i.Open(); err != nil {
+		return nil, err
+	}
+	return &backendInput{Input: i}, nil
+}
+
+func (b *Backend) OpenOutput(id int) (midi.OutputStream, error) {
+	o := NewOutput(id)
+	o.Latency = b.Latency
+	if err := o.Open(); err != nil {
+		return nil, err
+	}
+	return backendOutput{o}, nil
+}
+
+// The Timestamp at which the portmidi clock was at 0 milliseconds, set when initialized.
+var timeEpoch midi.Timestamp
+
+// Converts milliseconds of the portmidi clock to a Timestamp.
+func fromTime(ms int32) midi.Timestamp {
+	return timeEpoch + midi.Timestamp(time.Duration(ms)*time.Millisecond)
+}
+
+// Converts a Timestamp to milliseconds of the portmidi clock, where 0 is as soon as possible.
+func toTime(t midi.Timestamp) int32 {
+	if t == 0 || t <= timeEpoch {
+		return 0
+	}
+	return int32(time.Duration(t-timeEpoch) / time.Millisecond)
+}
+
+type backendInput struct {
+	*Input
+	partial []byte // The bytes of a system exclusive message that is being read.
+	sysex   []byte // The system exclusive message that was last read.
+}
+
+// Reads a message, or the next 4 bytes of a system exclusive message, which
+// portmidi reads as such. The status of 0xF0 is returned once all of the bytes
+// of a system exclusive message are read, and 0 before then.
+func (i *backendInput) Read() (uint32, midi.Timestamp, error) {
+	u, timestamp := i.Input.Read()
+	status := byte(u)
+	switch {
+	case status == 0xF0:
+		i.partial = i.partial[:0]
+	case i.partial == nil || status >= 0xF8: // Real-time messages may be within system exclusive messages.
+		return u, fromTime(timestamp), nil
+	case status >= 0x80 && status != 0xF7: // Another message ends the system exclusive message early.
+		i.partial = nil
+		return u, fromTime(timestamp), nil
+	}
+	for shift := uint(0); shift < 32; shift += 8 {
+		b := byte(u >> shift)
+		i.partial = append(i.partial, b)
+		if b == 0xF7 {
+			i.sysex, i.partial = i.partial, nil
+			return 0xF0, fromTime(timestamp), nil
+		}
+	}
+	return 0, fromTime(timestamp), nil
+}
+
+func (i *backendInput) ReadSysEx() []byte {
+	return i.sysex
+}
+
+type backendOutput struct {
+	*Output
+}
+
+func (o backendOutput) Write(m midi.Message) error {
+	if s, ok := m.(midi.SysEx); ok {
+		return o.Output.WriteSysEx(s.Data, toTime(s.Time))
+	}
+	return o.Output.Write(m, toTime(m.Timestamp()))
+}
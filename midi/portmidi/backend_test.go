@@ -0,0 +1,23 @@
+package portmidi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestTime(t *testing.T) {
+	timeEpoch = midi.Now()
+	defer func() { timeEpoch = 0 }()
+	expected := timeEpoch + midi.Timestamp(1500*time.Millisecond)
+	if actual := fromTime(1500); actual != expected {
+		t.Errorf("Converted 1500 milliseconds to %v instead of %v", actual, expected)
+	}
+	if actual := toTime(expected); actual != 1500 {
+		t.Errorf("Converted %v to %v milliseconds instead of 1500", expected, actual)
+	}
+	if actual := toTime(0); actual != 0 {
+		t.Errorf("Converted an unknown Timestamp to %v milliseconds instead of 0", actual)
+	}
+}
@@ -3,7 +3,9 @@ package portmidi
 
 // #cgo CFLAGS: -I/opt/local/include
 // #cgo LDFLAGS: -L/opt/local/lib -lportmidi
+// #include <stdlib.h>
 // #include <portmidi.h>
+// #include <porttime.h>
 import "C"
 import (
 	"errors"
@@ -15,6 +17,10 @@ const (
 	fiveTwelve C.int32_t = 512
 )
 
+// The latency, in milliseconds, that output streams are opened with by default.
+// Portmidi only honors timestamps of output streams opened with a latency above 0.
+const DefaultLatency = 10
+
 func newError(errNum C.PmError) error {
 	msg := C.GoString(C.Pm_GetErrorText(errNum))
 	if msg == "" {
@@ -23,7 +29,13 @@ func newError(errNum C.PmError) error {
 	return errors.New(msg)
 }
 
+// Initializes portmidi and starts the millisecond clock that timestamps are measured with.
 func Initialize() error {
+	if C.Pt_Started() == 0 {
+		if e := C.Pt_Start(1, nil, nil); e != C.ptNoError {
+			return errors.New("Could not start the portmidi clock.")
+		}
+	}
 	return newError(C.Pm_Initialize())
 }
 
@@ -35,6 +47,11 @@ func NumStreams() int {
 	return int(C.Pm_CountDevices())
 }
 
+// Returns the current time of the portmidi clock in milliseconds.
+func Time() int32 {
+	return int32(C.Pt_Time())
+}
+
 type Uint32er interface {
 	Uint32() uint32
 }
@@ -59,26 +76,53 @@ func NewStreamInfo(deviceID int) *StreamInfo {
 type Output struct {
 	deviceID C.PmDeviceID
 	stream   unsafe.Pointer
+	Latency  int32 // Milliseconds that written data is delayed by; set before opening.
 }
 
 func NewOutput(deviceID int) *Output {
-	return &Output{deviceID: C.PmDeviceID(deviceID)}
+	return &Output{deviceID: C.PmDeviceID(deviceID), Latency: DefaultLatency}
 }
 
 // Open makes a C call via portmidi to open an output stream used by input ports.
 func (o *Output) Open() error {
-	return newError(C.Pm_OpenOutput(&(o.stream), o.deviceID, nil, fiveTwelve, nil, nil, 0))
+	return newError(C.Pm_OpenOutput(&(o.stream), o.deviceID, nil, fiveTwelve, nil, nil, C.int32_t(o.Latency)))
 }
 
 func (o *Output) Close() error {
 	return newError(C.Pm_Close(o.stream))
 }
 
-func (o Output) Write(u Uint32er) error {
-	e := C.PmEvent{C.PmMessage(u.Uint32()), 0}
+// Write schedules data to be delivered at a time of the portmidi clock, compensating
+// for the latency of the stream. Data with a timestamp of 0, or timestamps that are
+// too soon to compensate for, are delivered as soon as possible.
+func (o Output) Write(u Uint32er, timestamp int32) error {
+	if timestamp > 0 && o.Latency > 0 {
+		timestamp -= o.Latency
+		if timestamp < 0 {
+			timestamp = 0
+		}
+	}
+	e := C.PmEvent{C.PmMessage(u.Uint32()), C.PmTimestamp(timestamp)}
 	return newError(C.Pm_Write(o.stream, &e, one))
 }
 
+// WriteSysEx schedules a system exclusive message, of all of its bytes from 0xF0
+// to 0xF7, like Write.
+func (o Output) WriteSysEx(data []byte, timestamp int32) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if timestamp > 0 && o.Latency > 0 {
+		timestamp -= o.Latency
+		if timestamp < 0 {
+			timestamp = 0
+		}
+	}
+	msg := C.CBytes(data)
+	defer C.free(msg)
+	return newError(C.Pm_WriteSysEx(o.stream, C.PmTimestamp(timestamp), (*C.uchar)(msg)))
+}
+
 type Input struct {
 	deviceID C.PmDeviceID
 	stream   unsafe.Pointer
@@ -98,14 +142,19 @@ func (i *Input) Close() error {
 }
 
 func (i *Input) Poll() (dataAvailable bool, err error) {
-	d, err := C.Pm_Poll(i.stream)
-	return d > 0, err
+	d := C.Pm_Poll(i.stream)
+	if d < 0 {
+		return false, newError(d)
+	}
+	return d > 0, nil
 }
 
-func (i *Input) Read() uint32 {
+// Read returns the next message of the stream and the time it was received
+// on the portmidi clock in milliseconds.
+func (i *Input) Read() (message uint32, timestamp int32) {
 	var e C.PmEvent
 	if n := C.Pm_Read(i.stream, &e, C.int32_t(1)); n > 0 {
-		return uint32(e.message)
+		return uint32(e.message), int32(e.timestamp)
 	}
-	return 0
+	return 0, 0
 }
@@ -10,8 +10,7 @@ that the OS uses to transfer data to them.
 */
 
 import (
-	"fmt"
-	"github.com/aoeu/audio/midi/portmidi"
+	"sync"
 	"time"
 )
 
@@ -47,7 +46,10 @@ func (p *Port) Connect() {}
 
 type SystemPort struct {
 	Port
-	id int
+	backend Backend
+	id      int        // The ID of the port's stream within its backend.
+	mu      sync.Mutex // Held while the stream of the port is used or replaced.
+	offline bool       // Set while the port has no usable stream, e.g. when its device is unplugged.
 }
 
 func (s *SystemPort) Close() error {
@@ -61,84 +63,190 @@ func (s *SystemPort) Close() error {
 
 type SystemInPort struct {
 	SystemPort
-	*portmidi.Output
+	output OutputStream
 }
 
 func (s *SystemInPort) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isOpen {
+		return nil
+	}
 	s.SystemPort.Close()
-	return s.Output.Close()
+	if s.offline {
+		return nil
+	}
+	s.offline = true
+	return s.output.Close()
 }
 
 func (s *SystemInPort) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.isOpen {
 		return nil
 	}
-	err := s.Output.Open()
-	if err == nil {
-		s.isOpen = true
+	if err := s.open(); err != nil {
+		return err
 	}
-	return err
+	s.isOpen = true
+	return nil
 }
 
-func (s SystemInPort) Connect() {
+// Opens the stream of the port. The port's mutex must be held.
+func (s *SystemInPort) open() error {
+	o, err := s.backend.OpenOutput(s.id)
+	if err != nil {
+		return err
+	}
+	s.output = o
+	s.offline = false
+	return nil
+}
+
+// Writes the data sent to the port to its stream. Data sent while the port is
+// offline is dropped, and the port goes offline if its stream can not be written to.
+func (s *SystemInPort) Connect() {
 	for {
 		select {
-		case m := <-s.messages:
-			if err := s.Output.Write(m); err != nil {
-				panic(err)
+		case m, ok := <-s.messages:
+			if !ok {
+				return
+			}
+			s.mu.Lock()
+			if !s.offline {
+				if err := s.output.Write(m); err != nil {
+					s.output.Close()
+					s.offline = true
+				}
 			}
+			s.mu.Unlock()
 		case <-s.disconnect:
 			return
 		}
 	}
 }
 
+// Closes the stream of the port, such as before its backend is reinitialized.
+// The port's mutex must be held.
+func (s *SystemInPort) suspend() {
+	if s.isOpen && !s.offline {
+		s.output.Close()
+	}
+	s.offline = true
+}
+
+// Reopens the stream of the port if the port is open but offline.
+// The port's mutex must be held.
+func (s *SystemInPort) resume() error {
+	if !s.isOpen || !s.offline {
+		return nil
+	}
+	return s.open()
+}
+
 type SystemOutPort struct {
 	SystemPort
-	*portmidi.Input
+	input InputStream
+}
+
+func (s *SystemOutPort) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isOpen {
+		return nil
+	}
+	s.SystemPort.Close()
+	if s.offline {
+		return nil
+	}
+	s.offline = true
+	return s.input.Close()
 }
 
 func (s *SystemOutPort) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.isOpen {
 		return nil
 	}
-	err := s.Input.Open()
-	if err == nil {
-		s.isOpen = true
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.isOpen = true
+	return nil
+}
+
+// Opens the stream of the port. The port's mutex must be held.
+func (s *SystemOutPort) open() error {
+	i, err := s.backend.OpenInput(s.id)
+	if err != nil {
+		return err
 	}
-	return err
+	s.input = i
+	s.offline = false
+	return nil
 }
 
-func (s SystemOutPort) Connect() {
+// Reads data from the stream of the port and sends it out of the port.
+// The port goes offline if its stream can not be read from.
+func (s *SystemOutPort) Connect() {
 	for {
 		select {
 		case <-s.disconnect:
 			return
 		default:
-			dataAvailable, err := s.Input.Poll()
-			if err != nil {
-				panic(err)
-			}
-			if !dataAvailable {
+			s.mu.Lock()
+			if s.offline {
+				s.mu.Unlock()
 				time.Sleep(1 * time.Millisecond)
 				continue
 			}
-			m := newMessage(s.Input.Read())
-			switch m.Command {
-			case NOTE_ON:
-				s.messages <- NoteOn{m.Channel, m.Data1, m.Data2}
-			case NOTE_OFF:
-				// A NoteOn with velocity 0 (Data2) is arguably a Note Off.
-				s.messages <- NoteOff{m.Channel, m.Data1, 0}
-			case CONTROL_CHANGE:
-				name, ok := ControlChangeNames[m.Data1]
-				if !ok {
-					name = "Unknown"
+			dataAvailable, err := s.input.Poll()
+			if err == nil && dataAvailable {
+				var u uint32
+				var t Timestamp
+				if u, t, err = s.input.Read(); err == nil {
+					var m Message
+					if r, ok := s.input.(SysExReader); ok && byte(u) == 0xF0 {
+						m = SysEx{Data: r.ReadSysEx(), Time: t}
+					} else {
+						raw := newMessage(u)
+						raw.Time = t
+						m = raw.typed()
+					}
+					s.mu.Unlock()
+					// Messages of types this package does not support are dropped.
+					if m != nil {
+						s.messages <- m
+					}
+					continue
 				}
-				s.messages <- ControlChange{m.Channel, m.Data1, m.Data2, name}
-			default:
-				fmt.Printf("Unknown message type received and ignored: %+v", m)
 			}
+			if err != nil {
+				s.input.Close()
+				s.offline = true
+			}
+			s.mu.Unlock()
+			time.Sleep(1 * time.Millisecond)
 		}
 	}
 }
+
+// Closes the stream of the port, such as before its backend is reinitialized.
+// The port's mutex must be held.
+func (s *SystemOutPort) suspend() {
+	if s.isOpen && !s.offline {
+		s.input.Close()
+	}
+	s.offline = true
+}
+
+// Reopens the stream of the port if the port is open but offline.
+// The port's mutex must be held.
+func (s *SystemOutPort) resume() error {
+	if !s.isOpen || !s.offline {
+		return nil
+	}
+	return s.open()
+}
@@ -0,0 +1,275 @@
+package midi
+
+import "math"
+
+// The number of semitones in an octave, for use with Transpose.
+const Octave = 12
+
+// A Process transforms a Message into any number of Messages, including none.
+type Process func(Message) []Message
+
+// Implements Device, sending each Message received on In through a series of
+// Processes and sending the resulting Messages out of Out.
+type Processor struct {
+	*ThruDevice
+	Processes []Process
+}
+
+// Creates a new Processor that applies the Processes in order.
+func NewProcessor(processes ...Process) *Processor {
+	return &Processor{ThruDevice: NewThruDevice(), Processes: processes}
+}
+
+// Routes data through the processor, processing it.
+func (p *Processor) Connect() {
+	for {
+		select {
+		case m := <-p.In:
+			for _, n := range p.process(m) {
+				select {
+				case p.Out <- n:
+				case <-p.disconnect:
+					return
+				}
+			}
+		case <-p.disconnect:
+			return
+		}
+	}
+}
+
+func (p *Processor) process(m Message) []Message {
+	messages := []Message{m}
+	for _, process := range p.Processes {
+		var next []Message
+		for _, m := range messages {
+			next = append(next, process(m)...)
+		}
+		messages = next
+	}
+	return messages
+}
+
+// Returns the channel of a Message, if it has one.
+func channelOf(m Message) (channel int, ok bool) {
+	switch n := m.(type) {
+	case NoteOn:
+		return n.Channel, true
+	case NoteOff:
+		return n.Channel, true
+	case ControlChange:
+		return n.Channel, true
+	}
+	return 0, false
+}
+
+// Returns a copy of a Message on a different channel, if it has one.
+func withChannel(m Message, channel int) Message {
+	switch n := m.(type) {
+	case NoteOn:
+		n.Channel = channel
+		return n
+	case NoteOff:
+		n.Channel = channel
+		return n
+	case ControlChange:
+		n.Channel = channel
+		return n
+	}
+	return m
+}
+
+// Returns a copy of a NoteOn or NoteOff with a different key.
+func withKey(m Message, key int) Message {
+	switch n := m.(type) {
+	case NoteOn:
+		n.Key = key
+		return n
+	case NoteOff:
+		n.Key = key
+		return n
+	}
+	return m
+}
+
+// Returns the key of a NoteOn or NoteOff.
+func keyOf(m Message) (key int, ok bool) {
+	switch n := m.(type) {
+	case NoteOn:
+		return n.Key, true
+	case NoteOff:
+		return n.Key, true
+	}
+	return 0, false
+}
+
+// Passes only Messages on the specified channels, and Messages without a channel.
+func ChannelFilter(channels ...int) Process {
+	pass := make(map[int]bool, len(channels))
+	for _, c := range channels {
+		pass[c] = true
+	}
+	return func(m Message) []Message {
+		if c, ok := channelOf(m); ok && !pass[c] {
+			return nil
+		}
+		return []Message{m}
+	}
+}
+
+// Moves Messages from one channel to another as per the map of channels.
+func ChannelMap(channelMap map[int]int) Process {
+	return func(m Message) []Message {
+		if c, ok := channelOf(m); ok {
+			if to, ok := channelMap[c]; ok {
+				m = withChannel(m, to)
+			}
+		}
+		return []Message{m}
+	}
+}
+
+// Passes only Messages of the specified commands, e.g. NOTE_ON and NOTE_OFF.
+func CommandFilter(commands ...int) Process {
+	pass := make(map[int]bool, len(commands))
+	for _, c := range commands {
+		pass[c] = true
+	}
+	return func(m Message) []Message {
+		if !pass[int(m.Uint32()&0xF0)] {
+			return nil
+		}
+		return []Message{m}
+	}
+}
+
+// A VelocityCurve maps the velocity of a note that is played to another velocity.
+type VelocityCurve func(velocity int) int
+
+// Plays every note with the same velocity.
+func FixedVelocity(velocity int) VelocityCurve {
+	return func(int) int {
+		return velocity
+	}
+}
+
+// Scales velocities linearly into the range of min to max.
+func CompressVelocity(min, max int) VelocityCurve {
+	return func(velocity int) int {
+		return min + (velocity-1)*(max-min)/126
+	}
+}
+
+// Scales velocities exponentially, where an exponent above 1 softens
+// and an exponent below 1 hardens the response to playing.
+func ExponentialVelocity(exponent float64) VelocityCurve {
+	return func(velocity int) int {
+		return int(math.Round(127 * math.Pow(float64(velocity)/127, exponent)))
+	}
+}
+
+// Applies a VelocityCurve to the velocity of NoteOns.
+// NoteOns with a velocity of 0 are left as is, as they are arguably NoteOffs.
+func Velocity(curve VelocityCurve) Process {
+	return func(m Message) []Message {
+		if n, ok := m.(NoteOn); ok && n.Velocity > 0 {
+			n.Velocity = curve(n.Velocity)
+			switch {
+			case n.Velocity < 1:
+				n.Velocity = 1
+			case n.Velocity > 127:
+				n.Velocity = 127
+			}
+			m = n
+		}
+		return []Message{m}
+	}
+}
+
+// Passes only notes with keys from low to high, inclusive, and Messages that are not notes.
+func KeyRange(low, high int) Process {
+	return func(m Message) []Message {
+		if key, ok := keyOf(m); ok && (key < low || key > high) {
+			return nil
+		}
+		return []Message{m}
+	}
+}
+
+// Splits the keys of notes across two channels: keys lower than the split key
+// are moved to the lower channel and the others to the upper channel.
+func KeySplit(split, lowerChannel, upperChannel int) Process {
+	return func(m Message) []Message {
+		if key, ok := keyOf(m); ok {
+			if key < split {
+				m = withChannel(m, lowerChannel)
+			} else {
+				m = withChannel(m, upperChannel)
+			}
+		}
+		return []Message{m}
+	}
+}
+
+// Transposes the keys of notes by a number of semitones (or octaves with the Octave constant).
+// Notes that are transposed beyond the range of MIDI keys are dropped.
+func Transpose(semitones int) Process {
+	return func(m Message) []Message {
+		if key, ok := keyOf(m); ok {
+			key += semitones
+			if key < 0 || key > 127 {
+				return nil
+			}
+			m = withKey(m, key)
+		}
+		return []Message{m}
+	}
+}
+
+// Changes the keys of notes as per the map of keys, leaving other keys as they are.
+func KeyMap(keyMap map[int]int) Process {
+	return func(m Message) []Message {
+		if key, ok := keyOf(m); ok {
+			if to, ok := keyMap[key]; ok {
+				m = withKey(m, to)
+			}
+		}
+		return []Message{m}
+	}
+}
+
+// Changes the IDs of ControlChanges as per the map of IDs.
+func ControlChangeMap(idMap map[int]int) Process {
+	return func(m Message) []Message {
+		if c, ok := m.(ControlChange); ok {
+			if id, ok := idMap[c.ID]; ok {
+				c.ID = id
+				c.Name, ok = ControlChangeNames[id]
+				if !ok {
+					c.Name = "Unknown"
+				}
+				m = c
+			}
+		}
+		return []Message{m}
+	}
+}
+
+// Turns ControlChanges into notes with keys as per the map of IDs to keys.
+// A value above 0 plays a NoteOn with the value as velocity and 0 plays a NoteOff.
+func ControlChangeToNote(keyMap map[int]int) Process {
+	return func(m Message) []Message {
+		c, ok := m.(ControlChange)
+		if !ok {
+			return []Message{m}
+		}
+		key, ok := keyMap[c.ID]
+		if !ok {
+			return []Message{m}
+		}
+		if c.Value == 0 {
+			return []Message{NoteOff{c.Channel, key, 0, c.Time}}
+		}
+		return []Message{NoteOn{c.Channel, key, c.Value, c.Time}}
+	}
+}
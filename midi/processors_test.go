@@ -0,0 +1,65 @@
+package midi
+
+import "testing"
+
+func TestProcessor(t *testing.T) {
+	processor := NewProcessor(
+		ChannelFilter(0, 1),
+		ChannelMap(map[int]int{1: 9}),
+		Transpose(-Octave),
+		Velocity(FixedVelocity(100)),
+	)
+	src := NewDevice()
+	dst := NewDevice()
+	chain := NewChain(src, processor, dst)
+	if err := chain.Open(); err != nil {
+		t.Errorf("Could not open chain: %v", err)
+	}
+	chain.Connect()
+	src.Out <- NoteOn{2, 60, 64, 0} // Filtered out by channel.
+	src.Out <- NoteOn{0, 5, 64, 0}  // Transposed out of range.
+	src.Out <- NoteOn{1, 60, 64, 0}
+	expected := NoteOn{9, 48, 100, 0}
+	if actual := <-dst.In; actual != expected {
+		t.Errorf("Received %v from processor instead of %v", actual, expected)
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	cc := ControlChange{0, 1, 64, ControlChangeNames[1], 0}
+	tests := []struct {
+		name     string
+		process  Process
+		in       Message
+		expected []Message
+	}{
+		{"CommandFilter", CommandFilter(NOTE_ON, NOTE_OFF), cc, nil},
+		{"CommandFilter", CommandFilter(CONTROL_CHANGE), cc, []Message{cc}},
+		{"CompressVelocity", Velocity(CompressVelocity(64, 127)), NoteOn{0, 60, 1, 0},
+			[]Message{NoteOn{0, 60, 64, 0}}},
+		{"ExponentialVelocity", Velocity(ExponentialVelocity(2)), NoteOn{0, 60, 127, 0},
+			[]Message{NoteOn{0, 60, 127, 0}}},
+		{"ExponentialVelocity", Velocity(ExponentialVelocity(2)), NoteOn{0, 60, 0, 0},
+			[]Message{NoteOn{0, 60, 0, 0}}},
+		{"KeyRange", KeyRange(36, 47), NoteOff{0, 48, 0, 0}, nil},
+		{"KeySplit", KeySplit(60, 1, 2), NoteOff{0, 59, 0, 0}, []Message{NoteOff{1, 59, 0, 0}}},
+		{"KeyMap", KeyMap(map[int]int{36: 38}), NoteOff{9, 36, 0, 0}, []Message{NoteOff{9, 38, 0, 0}}},
+		{"KeyMap", KeyMap(map[int]int{36: 38}), NoteOn{9, 40, 1, 0}, []Message{NoteOn{9, 40, 1, 0}}},
+		{"ControlChangeMap", ControlChangeMap(map[int]int{1: 7}), cc,
+			[]Message{ControlChange{0, 7, 64, ControlChangeNames[7], 0}}},
+		{"ControlChangeToNote", ControlChangeToNote(map[int]int{1: 36}), cc,
+			[]Message{NoteOn{0, 36, 64, 0}}},
+	}
+	for _, test := range tests {
+		actual := test.process(test.in)
+		if len(actual) != len(test.expected) {
+			t.Errorf("%v processed %v into %v instead of %v", test.name, test.in, actual, test.expected)
+			continue
+		}
+		for i := range actual {
+			if actual[i] != test.expected[i] {
+				t.Errorf("%v processed %v into %v instead of %v", test.name, test.in, actual, test.expected)
+			}
+		}
+	}
+}
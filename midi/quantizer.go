@@ -0,0 +1,142 @@
+package midi
+
+import "sync"
+
+// A Quantizer is a device that snaps the keys of the notes it receives to the
+// nearest keys of a scale from a root, and may play each note as a diatonic
+// chord of the scale. Other messages are passed through.
+//
+// It makes controllers of no musical layout, such as grids, playable as
+// instruments: every key pressed plays a note (or chord) in key.
+type Quantizer struct {
+	in  *Port
+	out *Port
+	*Wires
+	mu         sync.Mutex
+	scale      Scale
+	root       int
+	chord      int                   // The number of notes of the chord played for each note, or 1.
+	sounding   map[heldKey][]NoteOff // The note offs of the notes played for each key held.
+	playing    map[heldKey]int       // The number of keys held that play each key, which neighbouring keys may share.
+	disconnect chan bool
+}
+
+// A key held on a channel.
+type heldKey struct {
+	channel, key int
+}
+
+// Creates a new quantizer of a scale from a root key, which plays single notes.
+func NewQuantizer(s Scale, root int) *Quantizer {
+	return &Quantizer{
+		in:         NewPort(false),
+		out:        NewPort(false),
+		Wires:      NewWires(),
+		scale:      s,
+		root:       root,
+		chord:      1,
+		sounding:   make(map[heldKey][]NoteOff),
+		playing:    make(map[heldKey]int),
+		disconnect: make(chan bool, 1),
+	}
+}
+
+func (q *Quantizer) Open() error {
+	if err := q.in.Open(); err != nil {
+		return err
+	}
+	return q.out.Open()
+}
+
+// Stops quantizing and closes the quantizer.
+func (q *Quantizer) Close() error {
+	q.disconnect <- true
+	if err := q.in.Close(); err != nil {
+		return err
+	}
+	return q.out.Close()
+}
+
+// Quantizes the notes received until the quantizer is closed.
+func (q *Quantizer) Connect() {
+	for {
+		select {
+		case m := <-q.In:
+			for _, m := range q.quantize(m) {
+				select {
+				case q.Out <- m:
+				case <-q.disconnect:
+					return
+				}
+			}
+		case <-q.disconnect:
+			return
+		}
+	}
+}
+
+func (q *Quantizer) Wire() *Wires {
+	return q.Wires
+}
+
+// Returns the messages played for a message received. NoteOffs release the
+// notes that were played for their key, even if the scale has since changed.
+func (q *Quantizer) quantize(m Message) []Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch n := m.(type) {
+	case NoteOn:
+		if n.Velocity == 0 {
+			return q.release(heldKey{n.Channel, n.Key}, NoteOff{n.Channel, n.Key, 0, n.Time})
+		}
+		held := heldKey{n.Channel, n.Key}
+		messages := q.release(held, NoteOff{n.Channel, n.Key, 0, n.Time})
+		var offs []NoteOff
+		for _, key := range q.scale.Chord(q.root, n.Key, q.chord) {
+			if key < 0 || key > 127 {
+				continue
+			}
+			messages = append(messages, NoteOn{n.Channel, key, n.Velocity, n.Time})
+			offs = append(offs, NoteOff{n.Channel, key, 0, 0})
+			q.playing[heldKey{n.Channel, key}]++
+		}
+		q.sounding[held] = offs
+		return messages
+	case NoteOff:
+		return q.release(heldKey{n.Channel, n.Key}, n)
+	}
+	return []Message{m}
+}
+
+// Returns note offs of the notes played for a key held, like a note off received,
+// but for those that other keys held still play. The quantizer must be locked.
+func (q *Quantizer) release(held heldKey, n NoteOff) (messages []Message) {
+	for _, off := range q.sounding[held] {
+		played := heldKey{off.Channel, off.Key}
+		if q.playing[played]--; q.playing[played] > 0 {
+			continue
+		}
+		delete(q.playing, played)
+		off.Velocity, off.Time = n.Velocity, n.Time
+		messages = append(messages, off)
+	}
+	delete(q.sounding, held)
+	return messages
+}
+
+// Sets the scale and root key of the notes played from the next note.
+func (q *Quantizer) SetScale(s Scale, root int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.scale, q.root = s, root
+}
+
+// Sets the number of notes of the chord played for each note, such as 3 for
+// triads or 4 for seventh chords, or 1 for single notes.
+func (q *Quantizer) SetChord(notes int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if notes >= 1 {
+		q.chord = notes
+	}
+}
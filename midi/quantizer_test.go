@@ -0,0 +1,60 @@
+package midi
+
+import "testing"
+
+func TestQuantizer(t *testing.T) {
+	q := NewQuantizer(Major, 60)
+	go q.Connect()
+	defer q.Close()
+	expect := func(expected ...Message) {
+		for _, e := range expected {
+			if m := <-q.Out; m != e {
+				t.Errorf("Received %v from the quantizer instead of %v", m, e)
+			}
+		}
+	}
+
+	go func() { q.In <- NoteOn{Channel: 1, Key: 61, Velocity: 100} }()
+	expect(NoteOn{Channel: 1, Key: 60, Velocity: 100})
+	go func() { q.In <- ControlChange{Channel: 1, ID: 1, Value: 64} }()
+	expect(ControlChange{Channel: 1, ID: 1, Value: 64})
+
+	// Changing the scale or chord while a note is held still releases what it played.
+	q.SetScale(NaturalMinor, 57)
+	q.SetChord(3)
+	go func() { q.In <- NoteOff{Channel: 1, Key: 61} }()
+	expect(NoteOff{Channel: 1, Key: 60})
+	go func() { q.In <- NoteOn{Channel: 1, Key: 58, Velocity: 90} }()
+	expect(NoteOn{Channel: 1, Key: 57, Velocity: 90}, NoteOn{Channel: 1, Key: 60, Velocity: 90}, NoteOn{Channel: 1, Key: 64, Velocity: 90})
+	go func() { q.In <- NoteOn{Channel: 1, Key: 58} }()
+	expect(NoteOff{Channel: 1, Key: 57}, NoteOff{Channel: 1, Key: 60}, NoteOff{Channel: 1, Key: 64})
+}
+
+func TestQuantizerSharedKeys(t *testing.T) {
+	q := NewQuantizer(Major, 60)
+	q.SetChord(3)
+	go q.Connect()
+	defer q.Close()
+	go func() {
+		q.In <- NoteOn{Key: 61, Velocity: 100} // C, E and G.
+		q.In <- NoteOn{Key: 60, Velocity: 100} // C, E and G again.
+		q.In <- NoteOn{Key: 64, Velocity: 100} // E, G and B.
+		q.In <- NoteOff{Key: 61}
+		q.In <- NoteOff{Key: 64}
+		q.In <- NoteOff{Key: 60}
+	}()
+	for i := 0; i < 9; i++ {
+		if _, ok := (<-q.Out).(NoteOn); !ok {
+			t.Fatalf("Released a note while playing chords")
+		}
+	}
+	// Only the B is released until the last key that plays C, E and G is.
+	for _, expected := range []Message{
+		NoteOff{Key: 71},
+		NoteOff{Key: 60}, NoteOff{Key: 64}, NoteOff{Key: 67},
+	} {
+		if m := <-q.Out; m != expected {
+			t.Errorf("Received %v from the quantizer instead of %v", m, expected)
+		}
+	}
+}
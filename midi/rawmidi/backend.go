@@ -0,0 +1,224 @@
+//go:build !windows
+// +build !windows
+
+package rawmidi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// The patterns of the paths of raw MIDI device files that a Backend finds by default:
+// ALSA's raw MIDI devices (including USB-MIDI class devices), OSS's MIDI devices,
+// and the USB-MIDI devices of the BSDs.
+var DefaultPatterns = []string{"/dev/snd/midiC*D*", "/dev/midi*", "/dev/umidi*"}
+
+// The number of messages an input stream buffers before dropping any.
+const inputBufferSize = 512
+
+// Implements midi.Backend with raw MIDI device files, which are read and written
+// as streams of bytes. Each device file is a device named by its path,
+// with an input stream and an output stream.
+type Backend struct {
+	Patterns []string // The patterns of the paths of device files, as per filepath.Match.
+	mu       sync.Mutex
+	paths    []string
+}
+
+// Creates a new backend of the device files matching the DefaultPatterns.
+func NewBackend() *Backend {
+	return &Backend{Patterns: DefaultPatterns}
+}
+
+// Finds the device files matching the backend's patterns.
+func (b *Backend) Initialize() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	found := make(map[string]bool)
+	for _, pattern := range b.Patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			found[p] = true
+		}
+	}
+	b.paths = b.paths[:0]
+	for p := range found {
+		b.paths = append(b.paths, p)
+	}
+	sort.Strings(b.paths)
+	return nil
+}
+
+// Finds the device files matching the backend's patterns again.
+// Open streams remain usable, other than those of device files that were removed.
+func (b *Backend) Refresh() error {
+	return b.Initialize()
+}
+
+func (b *Backend) Terminate() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paths = nil
+	return nil
+}
+
+// Returns an input stream (with an even ID) and an output stream (with an odd ID)
+// for each device file.
+func (b *Backend) Streams() []midi.StreamInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	streams := make([]midi.StreamInfo, 0, 2*len(b.paths))
+	for i, p := range b.paths {
+		streams = append(streams,
+			midi.StreamInfo{ID: 2 * i, Name: p, IsInput: true},
+			midi.StreamInfo{ID: 2*i + 1, Name: p, IsOutput: true},
+		)
+	}
+	return streams
+}
+
+func (b *Backend) path(id int) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if id < 0 || id/2 >= len(b.paths) {
+		return "", errors.New("Invalid stream ID.")
+	}
+	return b.paths[id/2], nil
+}
+
+// Opens a device file to read from. Data is read as soon as the stream is opened.
+func (b *Backend) OpenInput(id int) (midi.InputStream, error) {
+	if id%2 != 0 {
+		return nil, errors.New("Invalid stream ID.")
+	}
+	path, err := b.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	i := &input{file: f, messages: make(chan received, inputBufferSize)}
+	go i.receive()
+	return i, nil
+}
+
+// Opens a device file to write to.
+func (b *Backend) OpenOutput(id int) (midi.OutputStream, error) {
+	if id%2 != 1 {
+		return nil, errors.New("Invalid stream ID.")
+	}
+	path, err := b.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &output{file: f}, nil
+}
+
+type received struct {
+	message   uint32
+	timestamp midi.Timestamp
+	sysex     []byte
+}
+
+type input struct {
+	file     *os.File
+	messages chan received
+	sysex    []byte // The system exclusive message that was last read.
+	mu       sync.Mutex
+	err      error // Why the device file can no longer be read, e.g. it was unplugged.
+}
+
+// Parses the bytes read from the device file until it can not be read,
+// timestamping messages when they are read.
+func (i *input) receive() {
+	p := Parser{KeepSysEx: true, KeepRealtime: true}
+	b := make([]byte, 256)
+	for {
+		n, err := i.file.Read(b)
+		now := midi.Now()
+		for _, c := range b[:n] {
+			if m, ok := p.Parse(c); ok {
+				r := received{message: m, timestamp: now}
+				if m == 0xF0 {
+					r.sysex = p.SysEx()
+				}
+				select {
+				case i.messages <- r:
+				default:
+				}
+			}
+		}
+		if err != nil {
+			i.mu.Lock()
+			i.err = err
+			i.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Returns an error once the device file can not be read and everything read has been.
+func (i *input) Poll() (bool, error) {
+	if len(i.messages) > 0 {
+		return true, nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return false, i.err
+}
+
+func (i *input) Read() (uint32, midi.Timestamp, error) {
+	select {
+	case r := <-i.messages:
+		i.sysex = r.sysex
+		return r.message, r.timestamp, nil
+	default:
+		return 0, 0, nil
+	}
+}
+
+func (i *input) ReadSysEx() []byte {
+	return i.sysex
+}
+
+func (i *input) Close() error {
+	return i.file.Close()
+}
+
+type output struct {
+	file *os.File
+}
+
+// Writes a message to the device file immediately, regardless of its Timestamp.
+// System common messages, other than system exclusive messages, are not written.
+func (o *output) Write(m midi.Message) error {
+	if s, ok := m.(midi.SysEx); ok {
+		_, err := o.file.Write(s.Data)
+		return err
+	}
+	u := m.Uint32()
+	if status := byte(u); status < 0x80 || status >= 0xF0 && status < 0xF8 {
+		return nil
+	}
+	_, err := o.file.Write(Encode(u))
+	return err
+}
+
+func (o *output) Close() error {
+	return o.file.Close()
+}
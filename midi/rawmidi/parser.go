@@ -0,0 +1,77 @@
+// Package rawmidi provides a MIDI backend of raw MIDI device files,
+// such as the /dev/snd/midi* files of ALSA and /dev/midi* files of OSS,
+// for systems without portmidi.
+package rawmidi
+
+import "github.com/aoeu/audio/encoding/smf"
+
+// A Parser parses a stream of MIDI bytes into channel messages, keeping track
+// of running status. System common messages are skipped, as are system real-time
+// and system exclusive messages unless they are kept.
+type Parser struct {
+	KeepSysEx    bool // Whether system exclusive messages are parsed, with a status of 0xF0.
+	KeepRealtime bool // Whether system real-time messages are parsed.
+	status       byte // The running status, or 0 if there is none.
+	data         []byte
+	sysex        bool
+	sysexData    []byte
+}
+
+// Parses the next byte of a stream, returning a message (with its status in the
+// lowest byte) once all of its bytes have been parsed.
+func (p *Parser) Parse(b byte) (message uint32, ok bool) {
+	switch {
+	case b >= 0xF8: // System real-time messages may occur anywhere, even within other messages.
+		return uint32(b), p.KeepRealtime
+	case b == 0xF0:
+		p.sysex = true
+		p.sysexData = append(p.sysexData[:0], b)
+		p.status = 0
+		return 0, false
+	case b == 0xF7 && p.sysex && p.KeepSysEx:
+		p.sysex = false
+		p.sysexData = append(p.sysexData, b)
+		return 0xF0, true
+	case b >= 0xF0: // System common messages and the end of system exclusive cancel running status.
+		p.sysex = false
+		p.status = 0
+		return 0, false
+	case b >= 0x80:
+		p.sysex = false
+		p.status = b
+		p.data = p.data[:0]
+		return 0, false
+	case p.sysex:
+		if p.KeepSysEx {
+			p.sysexData = append(p.sysexData, b)
+		}
+		return 0, false
+	case p.status == 0:
+		return 0, false
+	}
+	p.data = append(p.data, b)
+	if len(p.data) < smf.DataLen(p.status) {
+		return 0, false
+	}
+	message = uint32(p.status)
+	for i, d := range p.data {
+		message |= uint32(d) << uint(8*(i+1))
+	}
+	p.data = p.data[:0]
+	return message, true
+}
+
+// Returns a copy of the bytes of the system exclusive message that was last parsed.
+func (p *Parser) SysEx() []byte {
+	return append([]byte{}, p.sysexData...)
+}
+
+// Returns the bytes of a channel message, whose status is in its lowest byte.
+func Encode(message uint32) []byte {
+	status := byte(message)
+	if status >= 0xF8 {
+		return []byte{status}
+	}
+	b := []byte{status, byte(message>>8) & 0x7F, byte(message>>16) & 0x7F}
+	return b[:1+smf.DataLen(status)]
+}
@@ -0,0 +1,147 @@
+//go:build !windows
+// +build !windows
+
+package rawmidi
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestParser(t *testing.T) {
+	stream := []byte{
+		0x90, 60, 100, // Note On.
+		62, 0xF8, 101, // Running status interrupted by a timing clock.
+		0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7, // System exclusive.
+		64, 102, // Running status was cancelled by the system exclusive.
+		0xC1, 5, 6, // Program Change with running status.
+		0xB0, 7, // An incomplete Control Change...
+		0x80, 60, 0, // ...interrupted by a Note Off.
+	}
+	expected := []uint32{
+		0x90 | 60<<8 | 100<<16,
+		0x90 | 62<<8 | 101<<16,
+		0xC1 | 5<<8,
+		0xC1 | 6<<8,
+		0x80 | 60<<8,
+	}
+	var p Parser
+	var actual []uint32
+	for _, b := range stream {
+		if m, ok := p.Parse(b); ok {
+			actual = append(actual, m)
+		}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Parsed %#x instead of %#x", actual, expected)
+	}
+	p = Parser{KeepSysEx: true}
+	var sysex [][]byte
+	for _, b := range stream {
+		if m, ok := p.Parse(b); ok && m == 0xF0 {
+			sysex = append(sysex, p.SysEx())
+		}
+	}
+	if expected := [][]byte{stream[6:12]}; !reflect.DeepEqual(sysex, expected) {
+		t.Errorf("Parsed system exclusive messages % X instead of % X", sysex, expected)
+	}
+	p = Parser{KeepRealtime: true}
+	var realtime []uint32
+	for _, b := range stream {
+		if m, ok := p.Parse(b); ok && byte(m) >= 0xF8 {
+			realtime = append(realtime, m)
+		}
+	}
+	if !reflect.DeepEqual(realtime, []uint32{0xF8}) {
+		t.Errorf("Parsed system real-time messages %#x instead of a timing clock", realtime)
+	}
+	if b := Encode(0xC1 | 5<<8); !reflect.DeepEqual(b, []byte{0xC1, 5}) {
+		t.Errorf("Encoded a Program Change as %v", b)
+	}
+	if b := Encode(0xF8); !reflect.DeepEqual(b, []byte{0xF8}) {
+		t.Errorf("Encoded a timing clock as %v", b)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	dir := t.TempDir()
+	// Named pipes stand in for the device files of a keyboard and a synthesizer.
+	keyboard, synth := filepath.Join(dir, "midiC0D0"), filepath.Join(dir, "midiC1D0")
+	for _, p := range []string{keyboard, synth} {
+		if err := syscall.Mkfifo(p, 0600); err != nil {
+			t.Skip("Could not make a named pipe:", err)
+		}
+	}
+	keys, err := os.OpenFile(keyboard, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Close()
+	sound, err := os.OpenFile(synth, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sound.Close()
+
+	b := &Backend{Patterns: []string{filepath.Join(dir, "midi*")}}
+	if err := b.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	streams := b.Streams()
+	if len(streams) != 4 || streams[0].Name != keyboard || streams[3].Name != synth {
+		t.Fatalf("Found streams %+v", streams)
+	}
+	// Only the directions under test are opened, as a named pipe is read by whoever reads it first.
+	in, err := b.OpenInput(streams[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := b.OpenOutput(streams[3].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	keys.Write([]byte{0x91, 60, 100, 60, 0})
+	for _, expected := range []uint32{0x91 | 60<<8 | 100<<16, 0x91 | 60<<8} {
+		for {
+			available, err := in.Poll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if available {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		m, timestamp, _ := in.Read()
+		if m != expected || timestamp == 0 {
+			t.Errorf("Read %#x at %v from the keyboard instead of %#x", m, timestamp, expected)
+		}
+		if err := out.Write(midi.NoteOn{Channel: 1, Key: 60, Velocity: int(m >> 16)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, m := range []midi.Message{
+		midi.Realtime{Status: midi.START}, midi.Realtime{Status: midi.TIMING_CLOCK}, midi.Realtime{Status: midi.STOP},
+	} {
+		if err := out.Write(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(sound, buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x91, 60, 100, 0x91, 60, 0, 0xFA, 0xF8, 0xFC}; !reflect.DeepEqual(buf, expected) {
+		t.Errorf("Wrote %v to the synthesizer instead of %v", buf, expected)
+	}
+}
@@ -0,0 +1,161 @@
+package midi
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/encoding/smf"
+)
+
+const (
+	recorderDivision = 960              // Ticks per quarter note of recorded files.
+	recorderTempo    = smf.DefaultTempo // Microseconds per quarter note of recorded files.
+)
+
+// Implements Device, recording the MIDI data routed through it to a Standard MIDI File.
+// Messages are recorded at their Timestamp, or when they were routed if they have none.
+// A Recorder passes data through like a ThruDevice, recording onto its own track,
+// and records onto one more track for each source made with the Source method.
+type Recorder struct {
+	*RecorderSource
+	FileName  string
+	mu        sync.Mutex
+	recording bool
+	started   Timestamp
+	sources   []*RecorderSource
+	cues      map[*RecorderSource][]cue
+}
+
+// A RecorderSource is a thru device that records onto its own track of a Recorder.
+// One is meant to be placed after each source device whose data is to be recorded.
+type RecorderSource struct {
+	*ThruDevice
+	Name     string
+	recorder *Recorder
+}
+
+// Creates a new recorder that writes to the specified file when stopped.
+func NewRecorder(fileName string) *Recorder {
+	r := &Recorder{
+		FileName: fileName,
+		cues:     make(map[*RecorderSource][]cue),
+	}
+	r.RecorderSource = r.Source("")
+	return r
+}
+
+// Creates a new thru device whose data is recorded onto a track with the specified name.
+func (r *Recorder) Source(name string) *RecorderSource {
+	s := &RecorderSource{ThruDevice: NewThruDevice(), Name: name, recorder: r}
+	r.mu.Lock()
+	r.sources = append(r.sources, s)
+	r.mu.Unlock()
+	return s
+}
+
+// Routes data through the source, recording it.
+func (s *RecorderSource) Connect() {
+	for {
+		select {
+		case m := <-s.In:
+			s.recorder.record(s, m)
+			select {
+			case s.Out <- m:
+			case <-s.disconnect:
+				return
+			}
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+func (r *Recorder) record(s *RecorderSource, m Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.recording {
+		return
+	}
+	t := m.Timestamp()
+	if t == 0 {
+		t = Now()
+	}
+	at := time.Duration(t - r.started)
+	if at < 0 {
+		at = 0
+	}
+	r.cues[s] = append(r.cues[s], cue{at, m})
+}
+
+// Starts recording, discarding anything previously recorded.
+func (r *Recorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cues = make(map[*RecorderSource][]cue)
+	r.started = Now()
+	r.recording = true
+}
+
+// Stops recording and writes what was recorded to the recorder's file.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	r.recording = false
+	r.mu.Unlock()
+	return r.SMF().Write()
+}
+
+// Returns true if the recorder is recording.
+func (r *Recorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recording
+}
+
+// Returns a format 1 Standard MIDI File of what has been recorded:
+// a tempo track, the recorder's own track, then a track for each source,
+// omitting any track that recorded nothing.
+func (r *Recorder) SMF() *smf.File {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := smf.NewFile(r.FileName)
+	f.Header.Format = smf.FormatMultiTrack
+	f.Header.Division = recorderDivision
+	f.Tracks = append(f.Tracks, smf.Track{smf.NewTempoEvent(0, recorderTempo)})
+	for _, s := range r.sources {
+		if len(r.cues[s]) == 0 {
+			continue
+		}
+		// Messages may be routed out of the order of their Timestamps, such as
+		// those stamped by a backend amongst those stamped when routed.
+		cues := append([]cue(nil), r.cues[s]...)
+		sort.SliceStable(cues, func(i, j int) bool { return cues[i].at < cues[j].at })
+		var track smf.Track
+		if s.Name != "" {
+			track = append(track, smf.NewTrackNameEvent(0, s.Name))
+		}
+		var lastTick uint64
+		for _, c := range cues {
+			e, ok := newEventFromMessage(c.Message)
+			if !ok {
+				continue
+			}
+			tick := uint64(c.at/time.Microsecond) * recorderDivision / recorderTempo
+			e.Delta = uint32(tick - lastTick)
+			lastTick = tick
+			track = append(track, e)
+		}
+		f.Tracks = append(f.Tracks, append(track, smf.NewEndOfTrackEvent(0)))
+	}
+	return f
+}
+
+func newEventFromMessage(m Message) (smf.Event, bool) {
+	u := m.Uint32()
+	status := byte(u & 0xFF)
+	if status < 0x80 || status >= 0xF0 {
+		return smf.Event{}, false
+	}
+	data := []byte{byte((u >> 8) & 0x7F), byte((u >> 16) & 0x7F)}
+	return smf.Event{Status: status, Data: data[:smf.DataLen(status)]}, true
+}
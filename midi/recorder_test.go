@@ -0,0 +1,74 @@
+package midi
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/encoding/smf"
+)
+
+func TestRecorder(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "recording.mid")
+	recorder := NewRecorder(fileName)
+	src := NewDevice()
+	pads := recorder.Source("pads")
+	dst := NewDevice()
+	chain := NewChain(src, pads, recorder, dst)
+	if err := chain.Open(); err != nil {
+		t.Errorf("Could not open chain: %v", err)
+	}
+	chain.Connect()
+	recorder.Start()
+	expected := []Message{NoteOn{0, 64, 127, 0}, NoteOff{0, 64, 0, 0}}
+	for _, e := range expected {
+		src.Out <- e
+		if actual := <-dst.In; actual != e {
+			t.Errorf("Received %v from chain instead of %v", actual, e)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := recorder.Stop(); err != nil {
+		t.Fatalf("Could not write recording: %v", err)
+	}
+
+	f, err := smf.OpenFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.Format != smf.FormatMultiTrack || len(f.Tracks) != 3 {
+		t.Fatalf("Recorded format %d with %d tracks instead of format 1 with 3 tracks",
+			f.Header.Format, len(f.Tracks))
+	}
+	pad := f.Tracks[2]
+	if name := string(pad[0].Data); pad[0].Meta != smf.MetaTrackName || name != "pads" {
+		t.Errorf("Recorded track named %q instead of %q", name, "pads")
+	}
+	if pad[1].Status != 0x90 || pad[2].Status != 0x80 {
+		t.Errorf("Recorded %+v instead of a Note On and Note Off", pad[1:3])
+	}
+	// 10 milliseconds are 19.2 ticks at 960 ticks per half second.
+	if pad[2].Delta < 19 {
+		t.Errorf("Recorded a Note Off %d ticks after a Note On", pad[2].Delta)
+	}
+	if thru := f.Tracks[1]; len(thru) != 3 || thru[0].Status != 0x90 {
+		t.Errorf("Recorded %+v onto the recorder's own track", thru)
+	}
+}
+
+func TestRecorderOrder(t *testing.T) {
+	recorder := NewRecorder(filepath.Join(t.TempDir(), "recording.mid"))
+	keys := recorder.Source("keys")
+	recorder.Start()
+	started := recorder.started
+	// A note stamped by a backend is routed after one stamped later.
+	recorder.record(keys, NoteOff{0, 60, 0, started + Timestamp(time.Second)})
+	recorder.record(keys, NoteOn{0, 60, 100, started + Timestamp(500*time.Millisecond)})
+	track := recorder.SMF().Tracks[1]
+	if len(track) != 4 || track[1].Status != 0x90 || track[2].Status != 0x80 {
+		t.Fatalf("Recorded %+v instead of a Note On and then a Note Off", track)
+	}
+	if track[1].Delta != recorderDivision || track[2].Delta != recorderDivision {
+		t.Errorf("Recorded notes at deltas of %v and %v ticks instead of %v", track[1].Delta, track[2].Delta, recorderDivision)
+	}
+}
@@ -0,0 +1,469 @@
+// Package route builds and runs graphs of connected MIDI devices described by configuration files.
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// Types of devices, as found in DeviceEntry.Type.
+// Other types of devices may be added with Register.
+const (
+	System     = "system"     // A MIDI device plugged into the system.
+	Thru       = "thru"       // A midi.ThruDevice.
+	Processor  = "processor"  // A midi.Processor.
+	Transposer = "transposer" // A midi.Processor that changes the keys of notes as per a NoteMap.
+	Player     = "player"     // A midi.Player of a Standard MIDI File.
+	Quantizer  = "quantizer"  // A midi.Quantizer of a Scale (or Intervals) from a Root.
+)
+
+// A Factory creates the device of a DeviceEntry of a type added with Register,
+// and a function that closes anything besides the device that it is made with, if anything.
+type Factory func(e DeviceEntry) (device midi.Wirer, close func() error, err error)
+
+var (
+	factoriesMu sync.Mutex
+	factories   = make(map[string]Factory)
+)
+
+// Adds a type of device that graphs may be configured with, such as a device
+// of a package that this package does not depend upon.
+func Register(deviceType string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[deviceType] = f
+}
+
+// Configuration is a list of named devices and the connections between them.
+type Configuration struct {
+	Devices     []DeviceEntry
+	Connections []ConnectionEntry
+}
+
+// DeviceEntry is an individual named device of a routing graph.
+// Which fields are used depends upon the type of the device.
+type DeviceEntry struct {
+	Name      string
+	Type      string
+	Device    string         // The name of a system device; defaults to Name.
+	Processes []ProcessEntry // Processes of a processor, applied in order.
+	NoteMap   map[int]int    // The note map of a transposer.
+	FileName  string         // The MIDI file of a player, or a file of a registered type.
+	Volume    float32        // The volume of a registered type of device, e.g. a sampler.
+	Loop      bool           // Whether a player loops.
+	Scale     string         // The name of the scale of a quantizer, as in midi.Scales.
+	Intervals []int          // The intervals of the scale of a quantizer, rather than a named Scale.
+	Root      int            // The root key of the scale of a quantizer.
+	Chord     int            // The number of notes of the chords that a quantizer plays, if more than 1.
+}
+
+// ProcessEntry is an individual midi.Process of a processor,
+// with fields named as per the arguments of the Process functions of the midi package.
+type ProcessEntry struct {
+	Type         string // The name of a Process function, e.g. "Transpose".
+	Channels     []int
+	ChannelMap   map[int]int
+	Commands     []int
+	Curve        string // The velocity curve: "fixed", "compress" or "exponential".
+	Velocity     int
+	Min          int
+	Max          int
+	Exponent     float64
+	Low          int
+	High         int
+	Split        int
+	LowerChannel int
+	UpperChannel int
+	Semitones    int
+	IDMap        map[int]int
+	KeyMap       map[int]int
+}
+
+// ConnectionEntry transmits MIDI data out of one device into many devices.
+type ConnectionEntry struct {
+	From string
+	To   []string
+}
+
+// Loads a routing graph configuration from a JSON file.
+func LoadConfig(configFileName string) (Configuration, error) {
+	config := Configuration{}
+	data, err := ioutil.ReadFile(configFileName)
+	if err != nil {
+		s := fmt.Sprintf("Could not read config file: %v\nError: %v",
+			configFileName, err.Error())
+		return Configuration{}, errors.New(s)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Configuration{}, err
+	}
+	return config, nil
+}
+
+func (p ProcessEntry) process() (midi.Process, error) {
+	switch p.Type {
+	case "ChannelFilter":
+		return midi.ChannelFilter(p.Channels...), nil
+	case "ChannelMap":
+		return midi.ChannelMap(p.ChannelMap), nil
+	case "CommandFilter":
+		return midi.CommandFilter(p.Commands...), nil
+	case "Velocity":
+		switch p.Curve {
+		case "fixed":
+			return midi.Velocity(midi.FixedVelocity(p.Velocity)), nil
+		case "compress":
+			return midi.Velocity(midi.CompressVelocity(p.Min, p.Max)), nil
+		case "exponential":
+			return midi.Velocity(midi.ExponentialVelocity(p.Exponent)), nil
+		}
+		return nil, fmt.Errorf("Unknown velocity curve %q.", p.Curve)
+	case "KeyRange":
+		return midi.KeyRange(p.Low, p.High), nil
+	case "KeySplit":
+		return midi.KeySplit(p.Split, p.LowerChannel, p.UpperChannel), nil
+	case "Transpose":
+		return midi.Transpose(p.Semitones), nil
+	case "ControlChangeMap":
+		return midi.ControlChangeMap(p.IDMap), nil
+	case "ControlChangeToNote":
+		return midi.ControlChangeToNote(p.KeyMap), nil
+	case "KeyMap":
+		return midi.KeyMap(p.KeyMap), nil
+	}
+	return nil, fmt.Errorf("Unknown process type %q.", p.Type)
+}
+
+// A node is a device of a running graph.
+type node struct {
+	entry  DeviceEntry
+	device midi.Wirer
+	close  func() error // Closes anything besides the device that the node is made with.
+	done   chan bool    // Closed once the node is removed from the graph.
+}
+
+// Creates (but does not open) the device of a node.
+func (g *Graph) newNode(e DeviceEntry) (*node, error) {
+	n := &node{entry: e, close: func() error { return nil }, done: make(chan bool)}
+	switch e.Type {
+	case System:
+		d, ok := g.devices[e.systemName()]
+		if !ok {
+			return nil, fmt.Errorf("No system device named %q.", e.systemName())
+		}
+		n.device = d
+	case Thru:
+		n.device = midi.NewThruDevice()
+	case Processor:
+		processes := make([]midi.Process, len(e.Processes))
+		for i, p := range e.Processes {
+			var err error
+			if processes[i], err = p.process(); err != nil {
+				return nil, fmt.Errorf("Device %q: %v", e.Name, err)
+			}
+		}
+		n.device = midi.NewProcessor(processes...)
+	case Transposer:
+		n.device = midi.NewProcessor(midi.KeyMap(e.NoteMap))
+	case Quantizer:
+		scale := midi.Scale(e.Intervals)
+		if len(scale) == 0 {
+			var ok bool
+			if scale, ok = midi.Scales[e.Scale]; !ok {
+				return nil, fmt.Errorf("Device %q has unknown scale %q.", e.Name, e.Scale)
+			}
+		}
+		q := midi.NewQuantizer(scale, e.Root)
+		q.SetChord(e.Chord)
+		n.device = q
+	case Player:
+		p, err := midi.NewLoadedPlayer(e.FileName)
+		if err != nil {
+			return nil, err
+		}
+		p.Loop(e.Loop)
+		p.Start()
+		n.device = p
+	default:
+		factoriesMu.Lock()
+		f, ok := factories[e.Type]
+		factoriesMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("Device %q has unknown type %q.", e.Name, e.Type)
+		}
+		d, close, err := f(e)
+		if err != nil {
+			return nil, fmt.Errorf("Device %q: %v", e.Name, err)
+		}
+		n.device = d
+		if close != nil {
+			n.close = close
+		}
+	}
+	return n, nil
+}
+
+// A note that was played through the graph and may still be sounding.
+type heldNote struct {
+	from    *node
+	channel int
+	key     int
+}
+
+// A Graph runs a set of devices, transmitting MIDI data between them as per
+// the connections of its configuration. A graph may be reloaded with a new
+// configuration while running: devices that are configured the same are kept
+// running, and NoteOffs are sent to where their NoteOns were sent to so that
+// no note is left sounding. Devices that are removed from the graph while
+// notes played through them are held are retired with their connections
+// intact until those notes are released.
+type Graph struct {
+	mu      sync.Mutex
+	devices midi.SystemDevices
+	nodes   map[string]*node
+	system  map[string]*node // By system device name; these stay open until the graph is closed.
+	retired map[*node]bool
+	routes  map[*node][]*node
+	held    map[heldNote][]*node
+}
+
+// Creates and runs a new graph of the devices and connections of a configuration.
+func NewGraph(config Configuration, devices midi.SystemDevices) (*Graph, error) {
+	g := &Graph{
+		devices: devices,
+		nodes:   make(map[string]*node),
+		system:  make(map[string]*node),
+		retired: make(map[*node]bool),
+		routes:  make(map[*node][]*node),
+		held:    make(map[heldNote][]*node),
+	}
+	return g, g.Load(config)
+}
+
+func (e DeviceEntry) systemName() string {
+	if e.Device == "" {
+		return e.Name
+	}
+	return e.Device
+}
+
+// Loads a new configuration into the running graph.
+// The graph is left unchanged if the configuration can not be loaded.
+func (g *Graph) Load(config Configuration) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	nodes := make(map[string]*node, len(config.Devices))
+	system := make(map[string]*node)
+	var added []*node
+	fail := func(err error) error {
+		for _, n := range added {
+			g.closeNode(n)
+		}
+		return err
+	}
+	for _, e := range config.Devices {
+		if _, ok := nodes[e.Name]; ok {
+			return fail(fmt.Errorf("More than one device named %q.", e.Name))
+		}
+		if e.Type == System {
+			if n, ok := g.system[e.systemName()]; ok {
+				nodes[e.Name] = n
+				continue
+			}
+			if n, ok := system[e.systemName()]; ok {
+				nodes[e.Name] = n
+				continue
+			}
+		} else if n, ok := g.nodes[e.Name]; ok && reflect.DeepEqual(n.entry, e) {
+			nodes[e.Name] = n
+			continue
+		}
+		n, err := g.newNode(e)
+		if err != nil {
+			return fail(err)
+		}
+		if err := n.device.Open(); err != nil {
+			n.close()
+			return fail(fmt.Errorf("Could not open device %q: %v", e.Name, err))
+		}
+		nodes[e.Name] = n
+		added = append(added, n)
+		if e.Type == System {
+			system[e.systemName()] = n
+		}
+	}
+	routes := make(map[*node][]*node)
+	for _, c := range config.Connections {
+		from, ok := nodes[c.From]
+		if !ok {
+			return fail(fmt.Errorf("Connection from unknown device %q.", c.From))
+		}
+		for _, to := range c.To {
+			n, ok := nodes[to]
+			if !ok {
+				return fail(fmt.Errorf("Connection to unknown device %q.", to))
+			}
+			routes[from] = append(routes[from], n)
+		}
+	}
+
+	for _, n := range added {
+		go n.device.Connect()
+		go g.forward(n)
+	}
+	for name, n := range system {
+		g.system[name] = n
+	}
+	for name, n := range g.nodes {
+		if nodes[name] != n && n.entry.Type != System {
+			g.retired[n] = true
+		}
+	}
+	for n := range g.retired {
+		if g.referenced(n) {
+			routes[n] = g.routes[n]
+			continue
+		}
+		delete(g.retired, n)
+		g.closeNode(n)
+	}
+	g.nodes = nodes
+	g.routes = routes
+	return nil
+}
+
+// Returns true if any held note was played into or out of a node.
+// The graph's mutex must be held.
+func (g *Graph) referenced(n *node) bool {
+	for h, to := range g.held {
+		if h.from == n {
+			return true
+		}
+		for _, m := range to {
+			if m == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Closes a node that was retired once the notes played through it are released.
+func (g *Graph) release(n *node) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.retired[n] && !g.referenced(n) {
+		delete(g.retired, n)
+		delete(g.routes, n)
+		g.closeNode(n)
+	}
+}
+
+func (g *Graph) closeNode(n *node) error {
+	close(n.done)
+	if err := n.device.Close(); err != nil {
+		return err
+	}
+	return n.close()
+}
+
+// Transmits the MIDI data out of a node's device to where it is routed.
+func (g *Graph) forward(n *node) {
+	for {
+		select {
+		case m := <-n.device.Wire().Out:
+			for _, to := range g.route(n, m) {
+				select {
+				case to.device.Wire().In <- m:
+				case <-to.done:
+				}
+			}
+			g.release(n)
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// Returns the nodes a message from a node is to be sent to.
+func (g *Graph) route(from *node, m midi.Message) []*node {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	to := g.routes[from]
+	switch n := m.(type) {
+	case midi.NoteOn:
+		h := heldNote{from, n.Channel, n.Key}
+		if n.Velocity > 0 {
+			g.held[h] = to
+			break
+		}
+		to = union(to, g.held[h])
+		delete(g.held, h)
+	case midi.NoteOff:
+		h := heldNote{from, n.Channel, n.Key}
+		to = union(to, g.held[h])
+		delete(g.held, h)
+	}
+	return to
+}
+
+func union(a, b []*node) []*node {
+	u := append([]*node{}, a...)
+	for _, n := range b {
+		found := false
+		for _, m := range a {
+			found = found || m == n
+		}
+		if !found {
+			u = append(u, n)
+		}
+	}
+	return u
+}
+
+// Returns the device of a node of the graph, such as to send MIDI data into the graph.
+func (g *Graph) Device(name string) (midi.Wirer, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n, ok := g.nodes[name]
+	if !ok {
+		return nil, false
+	}
+	return n.device, true
+}
+
+// Stops the graph and closes all of its devices.
+func (g *Graph) Close() (err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, n := range g.nodes {
+		if n.entry.Type != System {
+			if e := g.closeNode(n); e != nil {
+				err = e
+			}
+		}
+	}
+	for n := range g.retired {
+		if e := g.closeNode(n); e != nil {
+			err = e
+		}
+	}
+	for _, n := range g.system {
+		if e := g.closeNode(n); e != nil {
+			err = e
+		}
+	}
+	g.nodes = make(map[string]*node)
+	g.system = make(map[string]*node)
+	g.retired = make(map[*node]bool)
+	g.routes = make(map[*node][]*node)
+	g.held = make(map[heldNote][]*node)
+	return err
+}
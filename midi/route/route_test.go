@@ -0,0 +1,177 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func transposing(name string, semitones int) DeviceEntry {
+	return DeviceEntry{
+		Name:      name,
+		Type:      Processor,
+		Processes: []ProcessEntry{{Type: "Transpose", Semitones: semitones}},
+	}
+}
+
+// A sink is a device whose received MIDI data is read from its In channel by the test.
+const sink = "sink"
+
+type sinkDevice struct {
+	wires *midi.Wires
+}
+
+func (s sinkDevice) Open() error       { return nil }
+func (s sinkDevice) Close() error      { return nil }
+func (s sinkDevice) Connect()          {}
+func (s sinkDevice) Wire() *midi.Wires { return s.wires }
+
+func init() {
+	Register(sink, func(DeviceEntry) (midi.Wirer, func() error, error) {
+		return sinkDevice{midi.NewWires()}, nil, nil
+	})
+}
+
+func TestGraphReload(t *testing.T) {
+	config := Configuration{
+		Devices: []DeviceEntry{
+			{Name: "in", Type: Thru},
+			transposing("up", midi.Octave),
+			{Name: "out", Type: sink},
+		},
+		Connections: []ConnectionEntry{
+			{From: "in", To: []string{"up"}},
+			{From: "up", To: []string{"out"}},
+		},
+	}
+	g, err := NewGraph(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	in, _ := g.Device("in")
+	sunk, _ := g.Device("out")
+	out := sunk.Wire().In
+
+	in.Wire().In <- midi.NoteOn{Channel: 0, Key: 60, Velocity: 100}
+	if actual, expected := <-out, (midi.NoteOn{Channel: 0, Key: 72, Velocity: 100}); actual != expected {
+		t.Errorf("Received %v from graph instead of %v", actual, expected)
+	}
+
+	config.Devices[1] = transposing("down", -midi.Octave)
+	config.Connections = []ConnectionEntry{
+		{From: "in", To: []string{"down"}},
+		{From: "down", To: []string{"out"}},
+	}
+	if err := g.Load(config); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := g.Device("out"); d != sunk {
+		t.Errorf("Reloading replaced a device that was configured the same")
+	}
+
+	// The NoteOff is sent through both the retired and the new processor.
+	in.Wire().In <- midi.NoteOff{Channel: 0, Key: 60, Velocity: 0}
+	received := map[midi.Message]bool{}
+	received[<-out] = true
+	received[<-out] = true
+	for _, expected := range []midi.Message{midi.NoteOff{Channel: 0, Key: 72, Velocity: 0}, midi.NoteOff{Channel: 0, Key: 48, Velocity: 0}} {
+		if !received[expected] {
+			t.Errorf("Did not receive %v from graph after reloading", expected)
+		}
+	}
+
+	in.Wire().In <- midi.NoteOn{Channel: 0, Key: 60, Velocity: 100}
+	if actual, expected := <-out, (midi.NoteOn{Channel: 0, Key: 48, Velocity: 100}); actual != expected {
+		t.Errorf("Received %v from reloaded graph instead of %v", actual, expected)
+	}
+}
+
+func TestGraphLoadError(t *testing.T) {
+	config := Configuration{
+		Devices:     []DeviceEntry{{Name: "in", Type: Thru}},
+		Connections: []ConnectionEntry{{From: "in", To: []string{"nowhere"}}},
+	}
+	if _, err := NewGraph(config, nil); err == nil {
+		t.Errorf("Loaded a graph with a connection to an unknown device")
+	}
+}
+
+func TestGraphTransposer(t *testing.T) {
+	config := Configuration{
+		Devices: []DeviceEntry{
+			{Name: "in", Type: Thru},
+			{Name: "swap", Type: Transposer, NoteMap: map[int]int{36: 38, 38: 36}},
+			{Name: "out", Type: sink},
+		},
+		Connections: []ConnectionEntry{
+			{From: "in", To: []string{"swap"}},
+			{From: "swap", To: []string{"out"}},
+		},
+	}
+	g, err := NewGraph(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := g.Device("in")
+	out, _ := g.Device("out")
+	for key, expected := range map[int]int{36: 38, 38: 36, 40: 40} {
+		in.Wire().In <- midi.NoteOn{Channel: 9, Key: key, Velocity: 100}
+		if actual := <-out.Wire().In; actual.(midi.NoteOn).Key != expected {
+			t.Errorf("Transposed key %v to %v instead of %v", key, actual.(midi.NoteOn).Key, expected)
+		}
+		in.Wire().In <- midi.NoteOff{Channel: 9, Key: key}
+		<-out.Wire().In
+	}
+	// With no notes held, removing the transposer closes it rather than retiring it.
+	config.Devices = config.Devices[:1]
+	config.Connections = nil
+	if err := g.Load(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.Device("swap"); ok {
+		t.Errorf("The transposer remained in the graph after reloading")
+	}
+	g.mu.Lock()
+	retired := len(g.retired)
+	g.mu.Unlock()
+	if retired != 0 {
+		t.Errorf("Retired %v devices instead of closing them", retired)
+	}
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGraphQuantizer(t *testing.T) {
+	config := Configuration{
+		Devices: []DeviceEntry{
+			{Name: "in", Type: Thru},
+			{Name: "snap", Type: Quantizer, Scale: "minorpentatonic", Root: 57},
+			{Name: "out", Type: sink},
+		},
+		Connections: []ConnectionEntry{
+			{From: "in", To: []string{"snap"}},
+			{From: "snap", To: []string{"out"}},
+		},
+	}
+	g, err := NewGraph(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	in, _ := g.Device("in")
+	out, _ := g.Device("out")
+	for key, expected := range map[int]int{58: 57, 61: 60, 66: 67, 69: 69} {
+		in.Wire().In <- midi.NoteOn{Key: key, Velocity: 100}
+		if actual := <-out.Wire().In; actual.(midi.NoteOn).Key != expected {
+			t.Errorf("Quantized key %v to %v instead of %v", key, actual.(midi.NoteOn).Key, expected)
+		}
+		in.Wire().In <- midi.NoteOff{Key: key}
+		<-out.Wire().In
+	}
+	config.Devices[1].Scale = "nonexistent"
+	if err := g.Load(config); err == nil {
+		t.Errorf("Loaded a quantizer of an unknown scale")
+	}
+}
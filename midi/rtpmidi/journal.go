@@ -0,0 +1,279 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// Flags of the headers of recovery journals and their channel journals.
+const (
+	journalChannels = 0x20 // A: The journal has channel journals.
+	journalSystem   = 0x40 // Y: The journal has a system journal.
+	chapterP        = 0x80 // Program change.
+	chapterC        = 0x40 // Control change.
+	chapterM        = 0x20 // Parameter system.
+	chapterW        = 0x10 // Pitch wheel.
+	chapterN        = 0x08 // Note on and off.
+)
+
+// A value of a note or controller that was sent or received with a packet.
+// The value of a note is its velocity, or 0 if it was released.
+type logged struct {
+	value uint8
+	seq   uint16
+}
+
+type channelState struct {
+	notes    map[uint8]logged // By key.
+	controls map[uint8]logged // By controller number.
+}
+
+func (c *channelState) empty() bool {
+	return len(c.notes) == 0 && len(c.controls) == 0
+}
+
+// A journal is the state of the notes and controllers of each channel of a stream
+// of MIDI data. A sender's journal keeps the state that was sent after the
+// checkpoint packet, which every peer has reported receiving, and is sent with
+// each packet as a recovery journal (RFC 6295 chapters C and N), from which
+// receivers recover what was sent with the packets that they lost.
+// A receiver's journal keeps the state that was received.
+type journal struct {
+	checkpoint uint16
+	channels   [16]channelState
+}
+
+// Records the notes and controllers of a message sent or received with a packet.
+func (j *journal) record(seq uint16, message uint32) {
+	c := &j.channels[message&0x0F]
+	data1, data2 := uint8(message>>8&0x7F), uint8(message>>16&0x7F)
+	switch message & 0xF0 {
+	case 0x80:
+		data2 = 0
+		fallthrough
+	case 0x90:
+		if c.notes == nil {
+			c.notes = make(map[uint8]logged)
+		}
+		c.notes[data1] = logged{data2, seq}
+	case 0xB0:
+		if c.controls == nil {
+			c.controls = make(map[uint8]logged)
+		}
+		c.controls[data1] = logged{data2, seq}
+	}
+}
+
+// Forgets the state sent with the checkpoint packet and the packets before it.
+func (j *journal) trim(checkpoint uint16) {
+	j.checkpoint = checkpoint
+	for i := range j.channels {
+		c := &j.channels[i]
+		for key, l := range c.notes {
+			if int16(l.seq-checkpoint) <= 0 {
+				delete(c.notes, key)
+			}
+		}
+		for id, l := range c.controls {
+			if int16(l.seq-checkpoint) <= 0 {
+				delete(c.controls, id)
+			}
+		}
+	}
+}
+
+func sortedKeys(m map[uint8]logged) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Encodes the journal as a recovery journal, or returns nil if it is empty.
+func (j *journal) encode() []byte {
+	var channels bytes.Buffer
+	total := 0
+	for i := range j.channels {
+		c := &j.channels[i]
+		if c.empty() {
+			continue
+		}
+		total++
+		var chapters byte
+		var body bytes.Buffer
+		if len(c.controls) > 0 {
+			chapters |= chapterC
+			body.WriteByte(byte(len(c.controls) - 1))
+			for _, id := range sortedKeys(c.controls) {
+				body.Write([]byte{byte(id), c.controls[uint8(id)].value})
+			}
+		}
+		if len(c.notes) > 0 {
+			chapters |= chapterN
+			var logs []byte
+			var offBits [16]byte
+			low, high := 15, 0
+			for _, key := range sortedKeys(c.notes) {
+				if v := c.notes[uint8(key)].value; v > 0 {
+					if len(logs) < 2*127 {
+						logs = append(logs, byte(key), 0x80|v)
+					}
+					continue
+				}
+				offBits[key/8] |= 0x80 >> uint(key%8)
+				if key/8 < low {
+					low = key / 8
+				}
+				if key/8 > high {
+					high = key / 8
+				}
+			}
+			body.Write([]byte{byte(len(logs) / 2), byte(low<<4 | high)})
+			body.Write(logs)
+			if low <= high {
+				body.Write(offBits[low : high+1])
+			}
+		}
+		length := 3 + body.Len()
+		channels.Write([]byte{byte(i<<3 | length>>8&0x03), byte(length), chapters})
+		channels.Write(body.Bytes())
+	}
+	if total == 0 {
+		return nil
+	}
+	b := []byte{journalChannels | byte(total-1), 0, 0}
+	binary.BigEndian.PutUint16(b[1:], j.checkpoint)
+	return append(b, channels.Bytes()...)
+}
+
+// Returns the messages that change the state of a receiver's journal to the state
+// of a recovery journal, changing the receiver's journal accordingly.
+// Chapters of the recovery journal other than C and N are skipped.
+func (j *journal) recover(seq uint16, b []byte) (messages []uint32, err error) {
+	if len(b) < 3 {
+		return nil, errInvalidPacket
+	}
+	flags := b[0]
+	b = b[3:]
+	if flags&journalSystem != 0 {
+		if len(b) < 2 {
+			return nil, errInvalidPacket
+		}
+		length := int(binary.BigEndian.Uint16(b) & 0x03FF)
+		if length < 2 || len(b) < length {
+			return nil, errInvalidPacket
+		}
+		b = b[length:]
+	}
+	if flags&journalChannels == 0 {
+		return nil, nil
+	}
+	for i := 0; i <= int(flags&0x0F); i++ {
+		if len(b) < 3 {
+			return messages, errInvalidPacket
+		}
+		channel := int(b[0] >> 3 & 0x0F)
+		length := int(binary.BigEndian.Uint16(b) & 0x03FF)
+		if length < 3 || len(b) < length {
+			return messages, errInvalidPacket
+		}
+		recovered, err := j.recoverChannel(seq, channel, b[2], b[3:length])
+		messages = append(messages, recovered...)
+		if err != nil {
+			return messages, err
+		}
+		b = b[length:]
+	}
+	return messages, nil
+}
+
+func (j *journal) recoverChannel(seq uint16, channel int, chapters byte, b []byte) (messages []uint32, err error) {
+	status := uint32(channel)
+	if chapters&chapterP != 0 {
+		if len(b) < 3 {
+			return nil, errInvalidPacket
+		}
+		b = b[3:]
+	}
+	if chapters&chapterC != 0 {
+		if len(b) < 1 {
+			return nil, errInvalidPacket
+		}
+		n := int(b[0]&0x7F) + 1
+		if len(b) < 1+2*n {
+			return nil, errInvalidPacket
+		}
+		for i := 0; i < n; i++ {
+			id, value := b[1+2*i]&0x7F, b[2+2*i]
+			if value&0x80 != 0 { // Toggle and count tools are not supported.
+				continue
+			}
+			if l, ok := j.channels[channel].controls[id]; !ok || l.value != value {
+				m := 0xB0 | status | uint32(id)<<8 | uint32(value)<<16
+				j.record(seq, m)
+				messages = append(messages, m)
+			}
+		}
+		b = b[1+2*n:]
+	}
+	if chapters&chapterM != 0 {
+		if len(b) < 2 {
+			return messages, errInvalidPacket
+		}
+		length := int(binary.BigEndian.Uint16(b) & 0x03FF)
+		if length < 2 || len(b) < length {
+			return messages, errInvalidPacket
+		}
+		b = b[length:]
+	}
+	if chapters&chapterW != 0 {
+		if len(b) < 2 {
+			return messages, errInvalidPacket
+		}
+		b = b[2:]
+	}
+	if chapters&chapterN == 0 {
+		return messages, nil
+	}
+	if len(b) < 2 {
+		return messages, errInvalidPacket
+	}
+	n := int(b[0] & 0x7F)
+	low, high := int(b[1]>>4), int(b[1]&0x0F)
+	if n == 127 && low == 15 && high == 0 {
+		n = 128
+	}
+	b = b[2:]
+	if len(b) < 2*n {
+		return messages, errInvalidPacket
+	}
+	notes := j.channels[channel].notes
+	var ons []uint32
+	for i := 0; i < n; i++ {
+		key, velocity := b[2*i]&0x7F, b[2*i+1]&0x7F
+		if l, ok := notes[key]; velocity > 0 && (!ok || l.value == 0) {
+			ons = append(ons, 0x90|status|uint32(key)<<8|uint32(velocity)<<16)
+		}
+	}
+	b = b[2*n:]
+	for octet := low; octet <= high && octet-low < len(b); octet++ {
+		for bit := 0; bit < 8; bit++ {
+			key := uint8(8*octet + bit)
+			if b[octet-low]&(0x80>>uint(bit)) == 0 {
+				continue
+			}
+			if l, ok := j.channels[channel].notes[key]; ok && l.value > 0 {
+				m := 0x80 | status | uint32(key)<<8
+				j.record(seq, m)
+				messages = append(messages, m)
+			}
+		}
+	}
+	for _, m := range ons {
+		j.record(seq, m)
+	}
+	return append(messages, ons...), nil
+}
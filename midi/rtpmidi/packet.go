@@ -0,0 +1,254 @@
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/aoeu/audio/encoding/smf"
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/midi/rawmidi"
+)
+
+// The RTP payload type of RTP-MIDI, as AppleMIDI uses it.
+const payloadType = 0x61
+
+// Flags of the header of the MIDI command section of a packet.
+const (
+	flagLongLength = 0x80 // B: The length of the section takes 12 bits rather than 4.
+	flagJournal    = 0x40 // J: The section is followed by a recovery journal.
+	flagFirstDelta = 0x20 // Z: The first command of the section has a delta time.
+)
+
+var errInvalidPacket = errors.New("Invalid RTP-MIDI packet.")
+
+// The most bytes of the MIDI list of a packet, whose length takes 12 bits.
+const maxListLength = 0x0FFF
+
+// A MIDI message of a packet, with its status in the lowest byte, that is a
+// number of ticks of the session clock after the packet's timestamp. System
+// exclusive messages have their data, from 0xF0 to 0xF7.
+type event struct {
+	delta   uint32
+	message uint32
+	sysex   []byte
+}
+
+// Returns the event of a message, if it is a channel message, a complete system
+// exclusive message or a system real-time message, which packets carry.
+func newEvent(m midi.Message) (e event, ok bool) {
+	e.message = m.Uint32()
+	if s, ok := m.(midi.SysEx); ok {
+		e.sysex = s.Data
+		return e, len(s.Data) >= 2 && s.Data[0] == 0xF0 && s.Data[len(s.Data)-1] == 0xF7
+	}
+	status := byte(e.message)
+	return e, status >= 0x80 && status < 0xF0 || status >= 0xF8
+}
+
+// Returns the MIDI data of the event.
+func (e event) bytes() []byte {
+	if e.sysex != nil {
+		return e.sysex
+	}
+	return rawmidi.Encode(e.message)
+}
+
+// Returns the message of the event, or nil if its type is not supported by the midi package.
+func (e event) midiMessage() midi.Message {
+	if e.sysex != nil {
+		return midi.SysEx{Data: e.sysex}
+	}
+	return midi.Decode(e.message)
+}
+
+// An RTP packet of MIDI data: channel messages, complete system exclusive
+// messages and system real-time messages. Segments of system exclusive messages
+// and system common messages are skipped.
+type packet struct {
+	seq       uint16
+	timestamp uint32
+	ssrc      uint32
+	events    []event // In order of time.
+	journal   []byte  // The recovery journal, if the packet has one.
+}
+
+func (p packet) encode() []byte {
+	var list bytes.Buffer
+	var last uint32
+	for i, e := range p.events {
+		if i > 0 || e.delta > 0 {
+			writeDelta(&list, e.delta-last)
+		}
+		last = e.delta
+		list.Write(e.bytes())
+	}
+	var b bytes.Buffer
+	b.Write([]byte{0x80, payloadType})
+	binary.Write(&b, binary.BigEndian, p.seq)
+	binary.Write(&b, binary.BigEndian, p.timestamp)
+	binary.Write(&b, binary.BigEndian, p.ssrc)
+	var flags byte
+	if len(p.journal) > 0 {
+		flags |= flagJournal
+	}
+	if len(p.events) > 0 && p.events[0].delta > 0 {
+		flags |= flagFirstDelta
+	}
+	if n := list.Len(); n > 0x0F {
+		b.Write([]byte{flags | flagLongLength | byte(n>>8&0x0F), byte(n)})
+	} else {
+		b.WriteByte(flags | byte(n))
+	}
+	b.Write(list.Bytes())
+	b.Write(p.journal)
+	return b.Bytes()
+}
+
+func decodePacket(b []byte) (p packet, err error) {
+	if len(b) < 13 || b[0]>>6 != 2 {
+		return p, errInvalidPacket
+	}
+	p.seq = binary.BigEndian.Uint16(b[2:4])
+	p.timestamp = binary.BigEndian.Uint32(b[4:8])
+	p.ssrc = binary.BigEndian.Uint32(b[8:12])
+	hasExtension := b[0]&0x10 != 0
+	skip := 12 + 4*int(b[0]&0x0F) // Skip any contributing sources.
+	if len(b) < skip {
+		return p, errInvalidPacket
+	}
+	b = b[skip:]
+	if hasExtension {
+		if len(b) < 4 {
+			return p, errInvalidPacket
+		}
+		skip = 4 + 4*int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < skip {
+			return p, errInvalidPacket
+		}
+		b = b[skip:]
+	}
+	if len(b) < 1 {
+		return p, errInvalidPacket
+	}
+	flags := b[0]
+	length := int(flags & 0x0F)
+	b = b[1:]
+	if flags&flagLongLength != 0 {
+		if len(b) < 1 {
+			return p, errInvalidPacket
+		}
+		length = length<<8 | int(b[0])
+		b = b[1:]
+	}
+	if len(b) < length {
+		return p, errInvalidPacket
+	}
+	if flags&flagJournal != 0 {
+		p.journal = b[length:]
+	}
+	p.events, err = decodeEvents(b[:length], flags&flagFirstDelta != 0)
+	return p, err
+}
+
+// Decodes the MIDI list of a packet, keeping its channel messages, complete system
+// exclusive messages and system real-time messages. Running status is supported,
+// other than for the status of a previous packet.
+func decodeEvents(b []byte, firstDelta bool) (events []event, err error) {
+	var status byte
+	var delta uint32
+	for i := 0; len(b) > 0; i++ {
+		if i > 0 || firstDelta {
+			d, n := readDelta(b)
+			if n == 0 {
+				return events, errInvalidPacket
+			}
+			delta += d
+			b = b[n:]
+			if len(b) == 0 {
+				return events, errInvalidPacket
+			}
+		}
+		if b[0] >= 0xF0 {
+			n := systemLen(b)
+			switch {
+			case b[0] >= 0xF8:
+				events = append(events, event{delta: delta, message: uint32(b[0])})
+			case b[0] == 0xF0 && b[n-1] == 0xF7:
+				events = append(events, event{delta: delta, message: 0xF0, sysex: append([]byte(nil), b[:n]...)})
+			}
+			if b[0] < 0xF8 { // Only system real-time messages keep running status.
+				status = 0
+			}
+			b = b[n:]
+			continue
+		}
+		if b[0] >= 0x80 {
+			status = b[0]
+			b = b[1:]
+		}
+		if status == 0 {
+			return events, errInvalidPacket
+		}
+		n := smf.DataLen(status)
+		if len(b) < n {
+			return events, errInvalidPacket
+		}
+		message := uint32(status)
+		for j, d := range b[:n] {
+			message |= uint32(d&0x7F) << uint(8*(j+1))
+		}
+		events = append(events, event{delta: delta, message: message})
+		b = b[n:]
+	}
+	return events, nil
+}
+
+// Returns the length of a system message, including any system exclusive data
+// up to the byte that ends it or the end of the list.
+func systemLen(b []byte) (n int) {
+	n = 1
+	switch b[0] {
+	case 0xF0, 0xF7: // System exclusive, or a segment of it.
+		for i := 1; i < len(b); i++ {
+			if b[i] == 0xF7 || b[i] == 0xF0 || b[i] == 0xF4 {
+				return i + 1
+			}
+		}
+		return len(b)
+	case 0xF1, 0xF3:
+		n = 2
+	case 0xF2:
+		n = 3
+	}
+	if n > len(b) {
+		return len(b)
+	}
+	return n
+}
+
+// Writes a delta time as a variable length quantity, as in Standard MIDI Files.
+func writeDelta(b *bytes.Buffer, delta uint32) {
+	delta &= 0x0FFFFFFF
+	var v [4]byte
+	i := len(v) - 1
+	v[i] = byte(delta & 0x7F)
+	for delta >>= 7; delta > 0; delta >>= 7 {
+		i--
+		v[i] = byte(delta&0x7F) | 0x80
+	}
+	b.Write(v[i:])
+}
+
+// Reads a delta time of up to 4 bytes, returning the number of bytes read,
+// or 0 if it is truncated.
+func readDelta(b []byte) (delta uint32, n int) {
+	for n < len(b) && n < 4 {
+		delta = delta<<7 | uint32(b[n]&0x7F)
+		n++
+		if b[n-1]&0x80 == 0 {
+			return delta, n
+		}
+	}
+	return 0, 0
+}
@@ -0,0 +1,107 @@
+// Package rtpmidi sends and receives MIDI data over a network with RTP-MIDI
+// (RFC 6295), using the session protocol of AppleMIDI as the network MIDI drivers
+// of OS X and iOS and rtpMIDI on Windows do. Peers are configured by their
+// addresses rather than found with mDNS (Bonjour).
+package rtpmidi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// The version of the session protocol.
+const protocolVersion = 2
+
+// The commands of the session protocol, which are sent on both the control and
+// data ports of a session, other than clock synchronization on the data port only.
+const (
+	cmdInvitation = "IN"
+	cmdAccepted   = "OK"
+	cmdRejected   = "NO"
+	cmdEnd        = "BY"
+	cmdSync       = "CK"
+	cmdFeedback   = "RS"
+)
+
+var errInvalidCommand = errors.New("Invalid session protocol command.")
+
+// A command of the session protocol. Which fields are used depends on its name.
+type command struct {
+	name       string
+	token      uint32 // Of invitations, used to match the replies to them.
+	ssrc       uint32 // Of the sender.
+	peerName   string // Of invitations and their acceptance.
+	count      uint8  // Of clock synchronization, the number of timestamps that are set.
+	timestamps [3]uint64
+	seq        uint16 // Of receiver feedback, the last RTP sequence number received.
+}
+
+// Returns true if a packet is a command of the session protocol rather than RTP-MIDI.
+func isCommand(b []byte) bool {
+	return len(b) >= 4 && b[0] == 0xFF && b[1] == 0xFF
+}
+
+func (c command) encode() []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xFF, c.name[0], c.name[1]})
+	switch c.name {
+	case cmdInvitation, cmdAccepted, cmdRejected, cmdEnd:
+		binary.Write(&b, binary.BigEndian, [3]uint32{protocolVersion, c.token, c.ssrc})
+		if c.name == cmdInvitation || c.name == cmdAccepted {
+			b.WriteString(c.peerName)
+			b.WriteByte(0)
+		}
+	case cmdSync:
+		binary.Write(&b, binary.BigEndian, c.ssrc)
+		b.Write([]byte{c.count, 0, 0, 0})
+		binary.Write(&b, binary.BigEndian, c.timestamps)
+	case cmdFeedback:
+		binary.Write(&b, binary.BigEndian, c.ssrc)
+		binary.Write(&b, binary.BigEndian, c.seq)
+		b.Write([]byte{0, 0})
+	}
+	return b.Bytes()
+}
+
+func decodeCommand(b []byte) (c command, err error) {
+	if !isCommand(b) {
+		return c, errInvalidCommand
+	}
+	c.name = string(b[2:4])
+	b = b[4:]
+	switch c.name {
+	case cmdInvitation, cmdAccepted, cmdRejected, cmdEnd:
+		if len(b) < 12 {
+			return c, errInvalidCommand
+		}
+		c.token = binary.BigEndian.Uint32(b[4:8])
+		c.ssrc = binary.BigEndian.Uint32(b[8:12])
+		name := b[12:]
+		for i, n := range name {
+			if n == 0 {
+				name = name[:i]
+				break
+			}
+		}
+		c.peerName = string(name)
+	case cmdSync:
+		if len(b) < 32 {
+			return c, errInvalidCommand
+		}
+		c.ssrc = binary.BigEndian.Uint32(b[0:4])
+		c.count = b[4]
+		for i := range c.timestamps {
+			c.timestamps[i] = binary.BigEndian.Uint64(b[8+8*i:])
+		}
+	case cmdFeedback:
+		if len(b) < 6 {
+			return c, errInvalidCommand
+		}
+		c.ssrc = binary.BigEndian.Uint32(b[0:4])
+		c.seq = binary.BigEndian.Uint16(b[4:6])
+	default:
+		return c, errInvalidCommand
+	}
+	return c, nil
+}
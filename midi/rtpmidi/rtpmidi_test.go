@@ -0,0 +1,212 @@
+package rtpmidi
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestCommands(t *testing.T) {
+	commands := []command{
+		{name: cmdInvitation, token: 0x01020304, ssrc: 0xCAFEBABE, peerName: "Session"},
+		{name: cmdEnd, token: 7, ssrc: 8},
+		{name: cmdSync, ssrc: 9, count: 2, timestamps: [3]uint64{1, 1 << 40, 3}},
+		{name: cmdFeedback, ssrc: 10, seq: 65535},
+	}
+	for _, expected := range commands {
+		actual, err := decodeCommand(expected.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("Decoded %+v instead of %+v", actual, expected)
+		}
+	}
+	if _, err := decodeCommand([]byte{0xFF, 0xFF, 'C', 'K', 0}); err == nil {
+		t.Errorf("Decoded a truncated command")
+	}
+}
+
+func TestPackets(t *testing.T) {
+	expected := packet{
+		seq:       65535,
+		timestamp: 123456789,
+		ssrc:      42,
+		events: []event{
+			{delta: 0, message: 0x7F3C90},
+			{delta: 0, message: 0x403C80},
+			{delta: 300, message: 0x0507B3},
+			{delta: 300, message: 0xF8},
+			{delta: 300, message: 0xF0, sysex: []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+			{delta: 20000, message: 0x00000AC1},
+			{delta: 20000, message: 0x402AE0},
+		},
+		journal: []byte{journalChannels, 0, 1, 0, 5, chapterW, 0, 0x40},
+	}
+	actual, err := decodePacket(expected.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoded %+v instead of %+v", actual, expected)
+	}
+
+	// A list of a note with a delta time, then a note with running status, a
+	// timing clock, a system exclusive message, a song position that is skipped
+	// and a note with the running status of before the timing clock.
+	b := []byte{0x80, payloadType, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+		flagFirstDelta | flagLongLength, 21, 0x05, 0x91, 60, 100, 0x81, 0x00, 62, 0, 0, 0xF8, 0, 62, 0, 0, 0xF0, 1, 0xF7, 0, 0xF2, 0, 0}
+	p, err := decodePacket(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = packet{seq: 1, events: []event{
+		{delta: 5, message: 0x643C91},
+		{delta: 133, message: 0x003E91},
+		{delta: 133, message: 0xF8},
+		{delta: 133, message: 0x003E91},
+		{delta: 133, message: 0xF0, sysex: []byte{0xF0, 1, 0xF7}},
+	}}
+	if !reflect.DeepEqual(p.events, expected.events) {
+		t.Errorf("Decoded %+v instead of %+v", p.events, expected)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	var sent, received journal
+	sent.trim(0)
+	messages := []uint32{0x403C90, 0x6407B0, 0x504090, 0x4080, 0x302891}
+	for i, m := range messages {
+		sent.record(uint16(i+1), m)
+	}
+	received.record(3, 0x504090) // Only the note of key 64 was received.
+	recovered, err := received.recover(6, sent.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint32{0x6407B0, 0x4080, 0x403C90, 0x302891}
+	if !reflect.DeepEqual(recovered, expected) {
+		t.Errorf("Recovered %#x instead of %#x", recovered, expected)
+	}
+	if recovered, _ := received.recover(7, sent.encode()); len(recovered) != 0 {
+		t.Errorf("Recovered %#x again", recovered)
+	}
+
+	sent.trim(4)
+	if len(sent.channels[0].notes) != 0 || len(sent.channels[0].controls) != 0 || len(sent.channels[1].notes) != 1 {
+		t.Errorf("Trimmed the journal to %+v", sent.channels[:2])
+	}
+	if sent.trim(5); sent.encode() != nil {
+		t.Errorf("Encoded a journal with no history")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var sent journal
+	receiver := NewSession("Receiver", 0)
+	receiver.participants[1] = &participant{ssrc: 1}
+	// Sends a packet of messages, returning what is received of it unless it is lost.
+	send := func(seq uint16, lost bool, messages ...midi.Message) (received []uint32) {
+		p := packet{seq: seq, ssrc: 1, journal: sent.encode()}
+		for _, m := range messages {
+			p.events = append(p.events, event{message: m.Uint32()})
+			sent.record(seq, m.Uint32())
+		}
+		if lost {
+			return nil
+		}
+		done := make(chan bool)
+		go func() {
+			receiver.receivePacket(p, nil)
+			close(done)
+		}()
+		for {
+			select {
+			case m := <-receiver.Out:
+				received = append(received, m.Uint32())
+			case <-done:
+				return received
+			}
+		}
+	}
+	on := func(key int) midi.Message { return midi.NoteOn{Channel: 0, Key: key, Velocity: 100} }
+	off := func(key int) midi.Message { return midi.NoteOff{Channel: 0, Key: key} }
+
+	send(1, false, on(60))
+	send(2, true, off(60), on(62))
+	received := send(3, false, on(64))
+	expected := []uint32{off(60).Uint32(), on(62).Uint32(), on(64).Uint32()}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Received %#x after a packet was lost instead of %#x", received, expected)
+	}
+	if received := send(3, false, on(65)); len(received) != 0 {
+		t.Errorf("Received %#x from a duplicated packet", received)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	a := NewSession("Session A", 0)
+	if err := a.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b := NewSession("Session B", 0, "127.0.0.1:"+strconv.Itoa(a.Port))
+	if err := b.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	go a.Connect()
+	go b.Connect()
+
+	for _, s := range []*Session{a, b} {
+		deadline := time.Now().Add(time.Second)
+		for {
+			if p := s.Participants(); len(p) == 1 && p[0].Synchronized {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%v did not synchronize clocks: %+v", s.Name, s.Participants())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if p := b.Participants()[0]; p.Name != "Session A" {
+		t.Errorf("Session B joined %+v instead of Session A", p)
+	}
+
+	for _, pair := range [][2]*Session{{a, b}, {b, a}} {
+		now := midi.Now()
+		expected := midi.NoteOn{Channel: 2, Key: 64, Velocity: 127, Time: now}
+		pair[0].In <- expected
+		select {
+		case m := <-pair[1].Out:
+			n, ok := m.(midi.NoteOn)
+			if !ok || n.Channel != 2 || n.Key != 64 || n.Velocity != 127 {
+				t.Errorf("%v received %+v instead of %+v", pair[1].Name, m, expected)
+			}
+			if d := time.Duration(n.Time - now); d < -time.Millisecond || d > 100*time.Millisecond {
+				t.Errorf("%v received a note stamped %v after it was sent", pair[1].Name, d)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v received nothing from %v", pair[1].Name, pair[0].Name)
+		}
+	}
+
+	inquiry := midi.SysEx{Data: []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}}
+	a.In <- inquiry
+	a.In <- midi.Realtime{Status: midi.TIMING_CLOCK}
+	for _, expected := range []midi.Message{inquiry, midi.Realtime{Status: midi.TIMING_CLOCK}} {
+		select {
+		case m := <-b.Out:
+			s, isSysEx := m.(midi.SysEx)
+			if m.Uint32() != expected.Uint32() || isSysEx && !reflect.DeepEqual(s.Data, inquiry.Data) {
+				t.Errorf("Session B received %+v instead of %+v", m, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Session B did not receive %+v", expected)
+		}
+	}
+}
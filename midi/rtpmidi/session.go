@@ -0,0 +1,538 @@
+package rtpmidi
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// The number of ticks per second of the session clock, which RTP timestamps and
+// clock synchronization count in.
+const clockRate = 10000
+
+const tick = time.Second / clockRate
+
+// How often receiver feedback is sent, which lets peers shorten their journals.
+const feedbackInterval = time.Second
+
+// How often the clocks of peers are synchronized by default.
+const DefaultSyncInterval = 10 * time.Second
+
+// How long an invitation is waited for a reply to, and how many times it is sent.
+const (
+	invitationTimeout = time.Second
+	invitationRetries = 3
+)
+
+// The number of messages that are sent in one packet at most.
+const maxEvents = 64
+
+// Returns the time of the session clock, in ticks.
+func clock(t midi.Timestamp) uint64 {
+	return uint64(time.Duration(t) / tick)
+}
+
+// Implements midi.Wirer as an RTP-MIDI session with any number of peers.
+// MIDI data sent to the session is sent to every peer, and MIDI data received
+// from any peer is sent out of the session, timestamped with when it was sent
+// once the clocks of the session and the peer are synchronized.
+//
+// A session listens on a control port and on the data port after it, and accepts
+// every invitation that it receives. The session invites its Peers when opened,
+// and synchronizes the clocks of the peers that it invites every SyncInterval.
+//
+// Channel messages, system exclusive messages and system real-time messages are
+// sent and received. System common messages, system exclusive messages of more
+// than a packet's MIDI list (4091 bytes) and segments of them are not, and only
+// the notes and controllers of lost packets are recovered from journals.
+type Session struct {
+	*midi.Wires
+	Name         string   // The name of the session, as peers see it.
+	Port         int      // The control port, or 0 to pick a free pair of ports.
+	Peers        []string // The control ports of the peers to invite, as "host:port".
+	SyncInterval time.Duration
+	ssrc         uint32
+	control      *net.UDPConn
+	data         *net.UDPConn
+	mu           sync.Mutex
+	participants map[uint32]*participant // By SSRC.
+	replies      map[uint32]chan command // Of the invitations that were sent, by token.
+	seq          uint16                  // Of the last packet sent.
+	sent         journal
+	closed       chan bool
+	disconnect   chan bool
+}
+
+// A peer that has joined a session.
+type participant struct {
+	name         string
+	ssrc         uint32
+	control      *net.UDPAddr
+	data         *net.UDPAddr
+	invited      bool  // Set if the session invited the peer, and so synchronizes clocks.
+	offset       int64 // The peer's clock minus the session's, in ticks.
+	latency      int64 // In ticks.
+	synchronized bool
+	lastSync     time.Time
+	seq          uint16 // Of the last packet received.
+	receiving    bool   // Set once a packet has been received.
+	feedback     bool   // Set when packets have been received since feedback was last sent.
+	acknowledged uint16 // The last packet that the peer reported receiving.
+	acked        bool
+	received     journal
+}
+
+// Describes a peer that has joined a session.
+type Participant struct {
+	Name         string
+	Addr         string        // Of the peer's control port.
+	Synchronized bool          // True once the clocks of the session and the peer are synchronized.
+	Offset       time.Duration // The peer's clock minus the session's.
+	Latency      time.Duration // Of the network, one way.
+}
+
+// Creates a new session of a name, listening on a control port (and the data port after it)
+// once opened, that invites peers of the specified addresses.
+func NewSession(name string, port int, peers ...string) *Session {
+	return &Session{
+		Wires:        midi.NewWires(),
+		Name:         name,
+		Port:         port,
+		Peers:        peers,
+		SyncInterval: DefaultSyncInterval,
+		ssrc:         rand.Uint32(),
+		participants: make(map[uint32]*participant),
+		replies:      make(map[uint32]chan command),
+		seq:          uint16(rand.Uint32()),
+		disconnect:   make(chan bool, 1),
+	}
+}
+
+// Listens on the session's ports and invites its peers.
+func (s *Session) Open() error {
+	control, data, err := listen(s.Port)
+	if err != nil {
+		return err
+	}
+	s.control, s.data = control, data
+	s.Port = control.LocalAddr().(*net.UDPAddr).Port
+	s.sent.checkpoint = s.seq
+	s.closed = make(chan bool)
+	go s.receive(s.control, s.closed)
+	go s.receive(s.data, s.closed)
+	go s.maintain(s.closed)
+	for _, peer := range s.Peers {
+		if err := s.Invite(peer); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Listens on a control port and the data port after it. If the port is 0,
+// free ports are picked.
+func listen(port int) (control, data *net.UDPConn, err error) {
+	for tries := 0; tries < 10; tries++ {
+		control, err = net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			return nil, nil, err
+		}
+		p := control.LocalAddr().(*net.UDPAddr).Port
+		data, err = net.ListenUDP("udp", &net.UDPAddr{Port: p + 1})
+		if err == nil {
+			return control, data, nil
+		}
+		control.Close()
+		if port != 0 {
+			break
+		}
+	}
+	return nil, nil, err
+}
+
+// Ends the session with every peer and stops listening.
+func (s *Session) Close() error {
+	s.disconnect <- true
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed == nil {
+		return nil
+	}
+	for _, p := range s.participants {
+		s.control.WriteToUDP(command{name: cmdEnd, ssrc: s.ssrc}.encode(), p.control)
+	}
+	s.participants = make(map[uint32]*participant)
+	close(s.closed)
+	s.closed = nil
+	s.control.Close()
+	return s.data.Close()
+}
+
+func (s *Session) Wire() *midi.Wires {
+	return s.Wires
+}
+
+// Sends the MIDI data sent to the session to its peers, in packets of the
+// messages that are sent together.
+func (s *Session) Connect() {
+	for {
+		select {
+		case m := <-s.In:
+			messages := []midi.Message{m}
+		batch:
+			for len(messages) < maxEvents {
+				select {
+				case m := <-s.In:
+					messages = append(messages, m)
+				default:
+					break batch
+				}
+			}
+			s.send(messages)
+		case <-s.disconnect:
+			return
+		}
+	}
+}
+
+// Sends packets of messages to every peer, with the recovery journal of the
+// packets that were sent since the checkpoint. Messages are split between
+// packets as the length of their MIDI lists allows.
+func (s *Session) send(messages []midi.Message) {
+	start := messages[0].Timestamp()
+	if start == 0 {
+		start = midi.Now()
+	}
+	var events []event
+	var delta uint32
+	length := 0
+	for _, m := range messages {
+		e, ok := newEvent(m)
+		size := 4 + len(e.bytes()) // The most that the event and its delta time take.
+		if !ok || size > maxListLength {
+			continue
+		}
+		// Messages are sent in order, at their Timestamps if they are in order.
+		if t := m.Timestamp(); t > start && uint32(clock(t)-clock(start)) > delta {
+			delta = uint32(clock(t) - clock(start))
+		}
+		e.delta = delta
+		if length+size > maxListLength {
+			s.sendPacket(start, events)
+			events, length = nil, 0
+		}
+		events = append(events, e)
+		length += size
+	}
+	s.sendPacket(start, events)
+}
+
+// Sends a packet of events after a time to every peer.
+func (s *Session) sendPacket(start midi.Timestamp, events []event) {
+	if len(events) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	p := packet{
+		seq:       s.seq,
+		timestamp: uint32(clock(start)),
+		ssrc:      s.ssrc,
+		events:    events,
+		journal:   s.sent.encode(),
+	}
+	b := p.encode()
+	for _, participant := range s.participants {
+		if participant.data != nil {
+			s.data.WriteToUDP(b, participant.data)
+		}
+	}
+	for _, e := range events {
+		s.sent.record(p.seq, e.message)
+	}
+}
+
+// Invites a peer to the session by the address of its control port, waiting for it to accept.
+func (s *Session) Invite(addr string) error {
+	control, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	data := &net.UDPAddr{IP: control.IP, Port: control.Port + 1, Zone: control.Zone}
+	token := rand.Uint32()
+	reply, err := s.invite(s.control, control, token)
+	if err != nil {
+		return err
+	}
+	if _, err := s.invite(s.data, data, token); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	p := s.join(reply.ssrc, reply.peerName, control)
+	p.data = data
+	p.invited = true
+	s.mu.Unlock()
+	s.synchronize(p)
+	return nil
+}
+
+// Sends an invitation from a port of the session, returning the peer's acceptance.
+func (s *Session) invite(conn *net.UDPConn, addr *net.UDPAddr, token uint32) (command, error) {
+	replies := make(chan command, 1)
+	s.mu.Lock()
+	s.replies[token] = replies
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.replies, token)
+		s.mu.Unlock()
+	}()
+	invitation := command{name: cmdInvitation, token: token, ssrc: s.ssrc, peerName: s.Name}
+	for i := 0; i < invitationRetries; i++ {
+		if _, err := conn.WriteToUDP(invitation.encode(), addr); err != nil {
+			return command{}, err
+		}
+		select {
+		case c := <-replies:
+			if c.name == cmdRejected {
+				return c, errors.New("The invitation to " + addr.String() + " was rejected.")
+			}
+			return c, nil
+		case <-time.After(invitationTimeout):
+		}
+	}
+	return command{}, errors.New("The invitation to " + addr.String() + " was not answered.")
+}
+
+// Adds a peer to the session if it has not joined it yet. The session's mutex must be held.
+func (s *Session) join(ssrc uint32, name string, control *net.UDPAddr) *participant {
+	p, ok := s.participants[ssrc]
+	if !ok {
+		p = &participant{ssrc: ssrc}
+		s.participants[ssrc] = p
+	}
+	p.name = name
+	if control != nil {
+		p.control = control
+	}
+	return p
+}
+
+// Returns the peers that have joined the session.
+func (s *Session) Participants() []Participant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var participants []Participant
+	for _, p := range s.participants {
+		addr := ""
+		if p.control != nil {
+			addr = p.control.String()
+		}
+		participants = append(participants, Participant{
+			Name:         p.name,
+			Addr:         addr,
+			Synchronized: p.synchronized,
+			Offset:       time.Duration(p.offset) * tick,
+			Latency:      time.Duration(p.latency) * tick,
+		})
+	}
+	return participants
+}
+
+// Receives the packets of a port of the session until the session is closed.
+func (s *Session) receive(conn *net.UDPConn, closed chan bool) {
+	b := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFromUDP(b)
+		if err != nil {
+			return
+		}
+		if isCommand(b[:n]) {
+			if c, err := decodeCommand(b[:n]); err == nil {
+				s.handle(conn, addr, c)
+			}
+			continue
+		}
+		if p, err := decodePacket(b[:n]); err == nil {
+			if !s.receivePacket(p, closed) {
+				return
+			}
+		}
+	}
+}
+
+// Handles a command of the session protocol received from an address on a port of the session.
+func (s *Session) handle(conn *net.UDPConn, addr *net.UDPAddr, c command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch c.name {
+	case cmdInvitation:
+		if conn == s.control {
+			s.join(c.ssrc, c.peerName, addr)
+		} else {
+			s.join(c.ssrc, c.peerName, nil).data = addr
+		}
+		reply := command{name: cmdAccepted, token: c.token, ssrc: s.ssrc, peerName: s.Name}
+		conn.WriteToUDP(reply.encode(), addr)
+	case cmdAccepted, cmdRejected:
+		if replies, ok := s.replies[c.token]; ok {
+			select {
+			case replies <- c:
+			default:
+			}
+		}
+	case cmdEnd:
+		delete(s.participants, c.ssrc)
+	case cmdSync:
+		if p, ok := s.participants[c.ssrc]; ok && conn == s.data {
+			s.sync(p, c)
+		}
+	case cmdFeedback:
+		if p, ok := s.participants[c.ssrc]; ok {
+			p.acknowledged, p.acked = c.seq, true
+			s.trim()
+		}
+	}
+}
+
+// Shortens the journal to the packets that a peer has not reported receiving.
+// The session's mutex must be held.
+func (s *Session) trim() {
+	checkpoint := s.seq
+	for _, p := range s.participants {
+		if !p.acked {
+			return
+		}
+		if int16(p.acknowledged-checkpoint) < 0 {
+			checkpoint = p.acknowledged
+		}
+	}
+	s.sent.trim(checkpoint)
+}
+
+// Exchanges the timestamps of clock synchronization, where the peer that sends the
+// first of them learns the offset and latency of the other from the second, and the
+// other learns them from the third. The session's mutex must be held.
+func (s *Session) sync(p *participant, c command) {
+	now := clock(midi.Now())
+	ts := c.timestamps
+	switch c.count {
+	case 0:
+		c.count, c.timestamps[1] = 1, now
+	case 1:
+		c.count, c.timestamps[2] = 2, now
+		p.offset = int64(ts[1]) - int64(ts[0]+now)/2
+		p.latency = int64(now-ts[0]) / 2
+		p.synchronized = true
+	case 2:
+		p.offset = int64(ts[0]+ts[2])/2 - int64(ts[1])
+		p.latency = int64(ts[2]-ts[0]) / 2
+		p.synchronized = true
+		return
+	default:
+		return
+	}
+	c.ssrc = s.ssrc
+	s.data.WriteToUDP(c.encode(), p.data)
+}
+
+// Begins synchronizing the clocks of the session and a peer that it invited.
+func (s *Session) synchronize(p *participant) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.lastSync = time.Now()
+	c := command{name: cmdSync, ssrc: s.ssrc, timestamps: [3]uint64{clock(midi.Now())}}
+	s.data.WriteToUDP(c.encode(), p.data)
+}
+
+// Synchronizes clocks and sends receiver feedback until the session is closed.
+func (s *Session) maintain(closed chan bool) {
+	ticker := time.NewTicker(feedbackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+		var due []*participant
+		s.mu.Lock()
+		for _, p := range s.participants {
+			if p.feedback && p.control != nil {
+				c := command{name: cmdFeedback, ssrc: s.ssrc, seq: p.seq}
+				s.control.WriteToUDP(c.encode(), p.control)
+				p.feedback = false
+			}
+			if p.invited && time.Since(p.lastSync) >= s.SyncInterval {
+				due = append(due, p)
+			}
+		}
+		s.mu.Unlock()
+		for _, p := range due {
+			s.synchronize(p)
+		}
+	}
+}
+
+// Sends the MIDI data of a packet out of the session, first recovering the data
+// of any packets that were lost from the packet's journal. Returns false once
+// the session is closed.
+func (s *Session) receivePacket(pk packet, closed chan bool) bool {
+	s.mu.Lock()
+	p, ok := s.participants[pk.ssrc]
+	if !ok {
+		s.mu.Unlock()
+		return true
+	}
+	if p.receiving && int16(pk.seq-p.seq) <= 0 { // A packet that is late or duplicated.
+		s.mu.Unlock()
+		return true
+	}
+	var messages []midi.Message
+	if p.receiving && pk.seq != p.seq+1 && len(pk.journal) > 0 {
+		recovered, _ := p.received.recover(pk.seq, pk.journal)
+		for _, u := range recovered {
+			if m := midi.Decode(u); m != nil {
+				messages = append(messages, midi.Stamp(m, midi.Now()))
+			}
+		}
+	}
+	p.seq, p.receiving, p.feedback = pk.seq, true, true
+	for _, e := range pk.events {
+		p.received.record(pk.seq, e.message)
+		if m := e.midiMessage(); m != nil {
+			messages = append(messages, midi.Stamp(m, p.localTime(pk.timestamp+e.delta)))
+		}
+	}
+	s.mu.Unlock()
+	for _, m := range messages {
+		select {
+		case s.Out <- m:
+		case <-closed:
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the time of the session clock at which a peer sent data with an RTP
+// timestamp, or the current time if their clocks are not synchronized.
+func (p *participant) localTime(timestamp uint32) midi.Timestamp {
+	now := midi.Now()
+	if !p.synchronized {
+		return now
+	}
+	local := uint32(int64(timestamp) - p.offset)
+	ago := int32(uint32(clock(now)) - local)
+	t := now - midi.Timestamp(time.Duration(ago)*tick)
+	if t <= 0 || t > now {
+		return now
+	}
+	return t
+}
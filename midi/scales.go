@@ -0,0 +1,103 @@
+package midi
+
+// A Scale is the intervals of the degrees of a scale, in semitones from its root
+// and ascending within an octave.
+type Scale []int
+
+var (
+	Chromatic       = Scale{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	Major           = Scale{0, 2, 4, 5, 7, 9, 11}
+	NaturalMinor    = Scale{0, 2, 3, 5, 7, 8, 10}
+	HarmonicMinor   = Scale{0, 2, 3, 5, 7, 8, 11}
+	MelodicMinor    = Scale{0, 2, 3, 5, 7, 9, 11}
+	MajorPentatonic = Scale{0, 2, 4, 7, 9}
+	MinorPentatonic = Scale{0, 3, 5, 7, 10}
+	Blues           = Scale{0, 3, 5, 6, 7, 10}
+	WholeTone       = Scale{0, 2, 4, 6, 8, 10}
+)
+
+// The modes of the major scale.
+var (
+	Ionian     = Major
+	Dorian     = Scale{0, 2, 3, 5, 7, 9, 10}
+	Phrygian   = Scale{0, 1, 3, 5, 7, 8, 10}
+	Lydian     = Scale{0, 2, 4, 6, 7, 9, 11}
+	Mixolydian = Scale{0, 2, 4, 5, 7, 9, 10}
+	Aeolian    = NaturalMinor
+	Locrian    = Scale{0, 1, 3, 5, 6, 8, 10}
+)
+
+// The scales by the names that configuration files and flags use.
+var Scales = map[string]Scale{
+	"chromatic":       Chromatic,
+	"major":           Major,
+	"minor":           NaturalMinor,
+	"harmonicminor":   HarmonicMinor,
+	"melodicminor":    MelodicMinor,
+	"pentatonic":      MajorPentatonic,
+	"minorpentatonic": MinorPentatonic,
+	"blues":           Blues,
+	"wholetone":       WholeTone,
+	"ionian":          Ionian,
+	"dorian":          Dorian,
+	"phrygian":        Phrygian,
+	"lydian":          Lydian,
+	"mixolydian":      Mixolydian,
+	"aeolian":         Aeolian,
+	"locrian":         Locrian,
+}
+
+// Returns the key of a degree of the scale from a root key, where degrees
+// beyond the scale continue into the octaves above and below.
+func (s Scale) Key(root, degree int) int {
+	octave := degree / len(s)
+	if degree%len(s) < 0 {
+		octave--
+	}
+	return root + octave*Octave + s[degree-octave*len(s)]
+}
+
+// Returns the degree of the key of the scale from a root key that is nearest a
+// key, or the lower of the two that are as near.
+func (s Scale) Degree(root, key int) int {
+	octave := (key - root) / Octave
+	if (key-root)%Octave < 0 {
+		octave--
+	}
+	interval := key - root - octave*Octave
+	// The last degree of the octave below and the first of the octave above may be nearer.
+	degree, distance := -1, interval-(s[len(s)-1]-Octave)
+	for d, i := range s {
+		if abs(i-interval) < distance {
+			degree, distance = d, abs(i-interval)
+		}
+	}
+	if s[0]+Octave-interval < distance {
+		degree = len(s)
+	}
+	return octave*len(s) + degree
+}
+
+// Returns the key of the scale from a root key that is nearest a key, or the
+// lower of the two that are as near.
+func (s Scale) Quantize(root, key int) int {
+	return s.Key(root, s.Degree(root, key))
+}
+
+// Returns the keys of a diatonic chord of a number of notes, of thirds of the
+// scale stacked upon the key of the scale nearest a key.
+func (s Scale) Chord(root, key, notes int) []int {
+	degree := s.Degree(root, key)
+	keys := make([]int, notes)
+	for i := range keys {
+		keys[i] = s.Key(root, degree+2*i)
+	}
+	return keys
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,58 @@
+package midi
+
+import "testing"
+
+func TestScaleKey(t *testing.T) {
+	for _, c := range []struct {
+		scale       Scale
+		degree, key int
+	}{
+		{Major, 0, 60}, {Major, 2, 64}, {Major, 7, 72}, {Major, -1, 59}, {Major, -7, 48},
+		{MajorPentatonic, 5, 72}, {NaturalMinor, 2, 63},
+	} {
+		if key := c.scale.Key(60, c.degree); key != c.key {
+			t.Errorf("Degree %v of %v from 60 is %v instead of %v", c.degree, c.scale, key, c.key)
+		}
+	}
+}
+
+func TestScaleQuantize(t *testing.T) {
+	for _, c := range []struct {
+		scale         Scale
+		root, key, to int
+	}{
+		{Major, 60, 61, 60}, {Major, 60, 63, 62}, {Major, 60, 66, 65}, {Major, 60, 71, 71},
+		{Major, 62, 61, 61}, {MajorPentatonic, 60, 70, 69}, {MajorPentatonic, 60, 71, 72},
+		{MajorPentatonic, 60, 59, 60}, {Dorian, 62, 66, 65}, {Scale{2, 9}, 60, 59, 57},
+	} {
+		if to := c.scale.Quantize(c.root, c.key); to != c.to {
+			t.Errorf("Quantized %v to %v of %v from %v instead of %v", c.key, to, c.scale, c.root, c.to)
+		}
+	}
+}
+
+func TestScaleChord(t *testing.T) {
+	for _, c := range []struct {
+		scale      Scale
+		key, notes int
+		keys       []int
+	}{
+		{Major, 60, 3, []int{60, 64, 67}},
+		{Major, 62, 3, []int{62, 65, 69}},
+		{Major, 71, 4, []int{71, 74, 77, 81}},
+		{NaturalMinor, 61, 3, []int{60, 63, 67}},
+		{Major, 64, 1, []int{64}},
+	} {
+		keys := c.scale.Chord(60, c.key, c.notes)
+		if len(keys) != len(c.keys) {
+			t.Errorf("Played chord %v of %v instead of %v", keys, c.key, c.keys)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != c.keys[i] {
+				t.Errorf("Played chord %v of %v instead of %v", keys, c.key, c.keys)
+				break
+			}
+		}
+	}
+}
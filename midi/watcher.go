@@ -0,0 +1,197 @@
+package midi
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// How often a Watcher rescans the system's devices by default.
+const DefaultWatchInterval = 2 * time.Second
+
+// A DeviceEvent is sent by a Watcher when a device is plugged in or unplugged.
+type DeviceEvent struct {
+	Device SystemDevice
+	Added  bool  // True if the device was plugged in, false if it was unplugged.
+	Err    error // Why a device that was found could not be reopened, in which case it is not added.
+}
+
+// A Watcher periodically rescans the devices of a Backend.
+//
+// If the backend is a Refresher, its streams are enumerated again while those
+// that are open carry on being used, and only the streams of devices that were
+// unplugged are closed. Otherwise, as with portmidi, which only enumerates devices
+// when it is initialized, the backend is reinitialized with the streams of all
+// watched devices closed on every scan, and the streams of the devices that are
+// still present are then reopened. MIDI data sent to or from any device while its
+// streams are closed is lost, which can leave notes stuck on if NoteOffs are lost,
+// so a long Interval should be used with such backends.
+//
+// A watched device that is unplugged goes offline: data sent to it is dropped
+// and none is received from it. Once a device of the same name is plugged back in,
+// the streams of the same SystemDevice are replaced so that it carries on running
+// within any Pipe, Router, or other Connector that it is connected with.
+//
+// All of the SystemDevices of a backend that are in use must be watched by the
+// same Watcher, as reinitializing a backend invalidates the streams of any other device.
+type Watcher struct {
+	Events   chan DeviceEvent // Must be received from while the watcher is watching.
+	Interval time.Duration
+	backend  Backend
+	mu       sync.Mutex
+	devices  SystemDevices
+	present  map[string]bool
+	stop     chan bool
+}
+
+// Creates a new watcher of the devices returned by GetDevices or GetBackendDevices,
+// which are all assumed to be plugged in.
+func NewWatcher(devices SystemDevices) *Watcher {
+	w := &Watcher{
+		Events:   make(chan DeviceEvent),
+		Interval: DefaultWatchInterval,
+		backend:  DefaultBackend,
+		devices:  make(SystemDevices, len(devices)),
+		present:  make(map[string]bool, len(devices)),
+		stop:     make(chan bool, 1),
+	}
+	for name, d := range devices {
+		w.devices[name] = d
+		w.present[name] = true
+		w.backend = d.backend
+	}
+	return w
+}
+
+// Rescans the system's devices every interval and sends the changes found
+// out of the Events channel until the watcher is stopped.
+func (w *Watcher) Watch() {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, e := range w.Scan() {
+				select {
+				case w.Events <- e:
+				case <-w.stop:
+					return
+				}
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Stops watching.
+func (w *Watcher) Stop() {
+	w.stop <- true
+}
+
+// Rescans the system's devices once, returning the changes found.
+// A device whose streams can not be reopened stays offline, and is reported
+// as not present, until a later scan reopens them. If the backend can not be
+// rescanned, nothing changes, other than that every device stays offline until
+// the next scan if the backend is not a Refresher.
+func (w *Watcher) Scan() []DeviceEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if r, ok := w.backend.(Refresher); ok {
+		if r.Refresh() != nil {
+			return nil
+		}
+	} else {
+		for _, d := range w.devices {
+			d.lock()
+			d.suspend()
+			d.unlock()
+		}
+		if w.backend.Terminate() != nil || w.backend.Initialize() != nil {
+			return nil
+		}
+	}
+	streams := systemStreams(w.backend)
+	added, removed := deviceChanges(w.present, streams)
+	var events []DeviceEvent
+	for _, name := range removed {
+		d := w.devices[name]
+		d.lock()
+		d.suspend()
+		d.unlock()
+		delete(w.present, name)
+		events = append(events, DeviceEvent{Device: d, Added: false})
+	}
+	for name := range w.present {
+		if err := w.resume(w.devices[name], streams[name]); err != nil {
+			delete(w.present, name)
+			events = append(events, DeviceEvent{Device: w.devices[name], Added: false, Err: err})
+		}
+	}
+	for _, name := range added {
+		d, ok := w.devices[name]
+		if !ok {
+			d = newSystemDevice(w.backend, name, streams[name])
+			w.devices[name] = d
+		} else if err := w.resume(d, streams[name]); err != nil {
+			events = append(events, DeviceEvent{Device: d, Added: false, Err: err})
+			continue
+		}
+		w.present[name] = true
+		events = append(events, DeviceEvent{Device: d, Added: true})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Added != events[j].Added {
+			return !events[i].Added
+		}
+		return events[i].Device.Name < events[j].Device.Name
+	})
+	return events
+}
+
+// Replaces the streams of a watched device, leaving it offline if they can not be reopened.
+func (w *Watcher) resume(d SystemDevice, streams []StreamInfo) error {
+	d.lock()
+	defer d.unlock()
+	err := d.resume(streams)
+	if err != nil {
+		d.suspend()
+	}
+	return err
+}
+
+// Returns the names of the devices that are not present and have streams
+// and of the devices that are present but have none, in order.
+func deviceChanges(present map[string]bool, streams map[string][]StreamInfo) (added, removed []string) {
+	for name := range streams {
+		if !present[name] {
+			added = append(added, name)
+		}
+	}
+	for name := range present {
+		if _, ok := streams[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Returns the devices that have been watched, whether or not they are plugged in.
+func (w *Watcher) Devices() SystemDevices {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	devices := make(SystemDevices, len(w.devices))
+	for name, d := range w.devices {
+		devices[name] = d
+	}
+	return devices
+}
+
+// Returns true if a watched device of a name is plugged in.
+func (w *Watcher) Present(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.present[name]
+}
@@ -0,0 +1,120 @@
+package midi
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDeviceChanges(t *testing.T) {
+	present := map[string]bool{"Bus 1": true, "Bus 2": true}
+	streams := map[string][]StreamInfo{
+		"Bus 1": {{ID: 0, Name: "Bus 1", IsInput: true}, {ID: 1, Name: "Bus 1", IsOutput: true}},
+		"Bus 3": {{ID: 2, Name: "Bus 3", IsInput: true}},
+		"Bus 4": {{ID: 3, Name: "Bus 4", IsInput: true}, {ID: 4, Name: "Bus 4", IsOutput: true}},
+	}
+	added, removed := deviceChanges(present, streams)
+	if expected := []string{"Bus 3", "Bus 4"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("Found %v added instead of %v", added, expected)
+	}
+	if expected := []string{"Bus 2"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Found %v removed instead of %v", removed, expected)
+	}
+	if added, removed := deviceChanges(present, nil); len(added) != 0 || len(removed) != 2 {
+		t.Errorf("Found %v added and %v removed when no streams were found", added, removed)
+	}
+}
+
+// Hides the Refresh method of a backend, so that it is reinitialized when rescanned.
+type reinitializing struct {
+	Backend
+}
+
+// Fails to open output streams while failing is set.
+type failing struct {
+	*Loopback
+	failing bool
+}
+
+func (f *failing) OpenOutput(id int) (OutputStream, error) {
+	if f.failing {
+		return nil, errors.New("Could not open the stream.")
+	}
+	return f.Loopback.OpenOutput(id)
+}
+
+func TestWatcherReconnects(t *testing.T) {
+	loopback := NewLoopback("Bus 1", "Bus 2")
+	testWatcherReconnects(t, loopback, loopback)
+}
+
+func TestWatcherReinitializes(t *testing.T) {
+	loopback := NewLoopback("Bus 1", "Bus 2")
+	testWatcherReconnects(t, loopback, reinitializing{loopback})
+}
+
+func testWatcherReconnects(t *testing.T, loopback *Loopback, b Backend) {
+	devices, err := GetBackendDevices(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus1, bus2 := devices["Bus 1"], devices["Bus 2"]
+	pipe := NewPipe(bus1, bus2)
+	if err := pipe.Open(); err != nil {
+		t.Fatalf("Could not open pipe: %v", err)
+	}
+	go pipe.Connect()
+	defer pipe.Close()
+	watcher := NewWatcher(devices)
+	_, refreshed := b.(Refresher)
+
+	input := bus1.out.input
+	loopback.RemoveBus("Bus 2")
+	events := watcher.Scan()
+	if len(events) != 1 || events[0].Added || events[0].Device.Name != "Bus 2" {
+		t.Errorf("Scanned %+v after removing a bus", events)
+	}
+	loopback.AddBus("Bus 2")
+	loopback.AddBus("Bus 3")
+	events = watcher.Scan()
+	if len(events) != 2 || !events[0].Added || events[0].Device.Name != "Bus 2" ||
+		events[0].Device.out != bus2.out || events[1].Device.Name != "Bus 3" {
+		t.Errorf("Scanned %+v after adding buses", events)
+	}
+	if reopened := bus1.out.input != input; reopened == refreshed {
+		t.Errorf("Reopened the stream of a bus that was not removed: %v", reopened)
+	}
+
+	expected := NoteOn{Channel: 1, Key: 60, Velocity: 100, Time: Now()}
+	bus1.In <- expected
+	if actual := <-bus2.Out; actual != expected {
+		t.Errorf("Received %v from a reconnected bus instead of %v", actual, expected)
+	}
+}
+
+func TestWatcherReportsErrors(t *testing.T) {
+	backend := &failing{Loopback: NewLoopback("Bus 1")}
+	devices, err := GetBackendDevices(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := devices["Bus 1"].Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer devices["Bus 1"].Close()
+	watcher := NewWatcher(devices)
+
+	backend.RemoveBus("Bus 1")
+	watcher.Scan()
+	backend.AddBus("Bus 1")
+	backend.failing = true
+	events := watcher.Scan()
+	if len(events) != 1 || events[0].Added || events[0].Err == nil || watcher.Present("Bus 1") {
+		t.Errorf("Scanned %+v after a bus could not be reopened", events)
+	}
+	backend.failing = false
+	events = watcher.Scan()
+	if len(events) != 1 || !events[0].Added || events[0].Err != nil || !watcher.Present("Bus 1") {
+		t.Errorf("Scanned %+v after a bus could be reopened", events)
+	}
+}
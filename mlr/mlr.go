@@ -0,0 +1,396 @@
+// Package mlr provides live slicing of clips on a grid, after the mlr of monome:
+// each row below the top row of the grid plays a clip, whose columns are the
+// divisions of the clip that its playhead jumps to when they are pressed.
+//
+// Pressing a second button of a row while holding the first loops the divisions
+// between them. The top row holds two pattern recorders, which record presses
+// and then loop them, and keys that are held while pressing a row to mute it,
+// reverse it, set its speed by the column pressed, or stop it.
+package mlr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/aoeu/audio"
+	"github.com/aoeu/audio/midi/controller"
+	"github.com/aoeu/audio/transport"
+)
+
+// The columns of the keys of the top row.
+const (
+	RecorderKey = iota // The first of the pattern recorders.
+	_                  // The second of the pattern recorders.
+	MuteKey
+	ReverseKey
+	SpeedKey
+	StopKey
+)
+
+const numRecorders = 2
+
+// A Track is a clip that is played by a row of the grid.
+type Track struct {
+	File    string      // The name of the wave file of the clip.
+	Clip    *audio.Clip `json:"-"` // Loaded from the file by LoadConfig.
+	Volume  float32     // From 0 to 1.
+	Speed   float64     // The rate of playback, with 0 for 1.
+	Beats   float64     // If set, the clip is sped up or slowed down to last the beats at the tempo.
+	Reverse bool
+}
+
+// A Config is the tracks of rows from the second row of the grid, and the timing of presses.
+type Config struct {
+	Tracks   []Track
+	Tempo    float64 // The tempo of the transport, with 0 for its default.
+	Quantize int     // The number of times per beat that presses take effect, or 0 for immediately.
+}
+
+// Reads a configuration from a JSON file, loading the clips of its tracks.
+func LoadConfig(configFileName string) (Config, error) {
+	var c Config
+	b, err := ioutil.ReadFile(configFileName)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	for i := range c.Tracks {
+		if c.Tracks[i].Clip, err = audio.NewClipFromWave(c.Tracks[i].File); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// A row is the playback of a track.
+type row struct {
+	Track
+	playing    bool
+	muted      bool
+	head       float64 // The position of the playhead, in frames of the clip.
+	start, end int     // The divisions that are looped, from start up to end.
+	held       []int   // The columns that are held down, in the order they were pressed.
+}
+
+// The recorded presses of a pattern recorder, at ticks from the start of the pattern.
+type recorded struct {
+	tick    int64
+	x, y    int
+	pressed bool
+}
+
+// The states of a pattern recorder.
+const (
+	empty = iota
+	recording
+	looping
+)
+
+type recorder struct {
+	state   int
+	start   int64 // The tick that recording, or each loop of the pattern, starts from.
+	length  int64
+	presses []recorded
+}
+
+// An MLR is the playback of clips by the rows of a grid, which is rendered into
+// the audio of a sampler and quantized to a transport that the sampler advances.
+type MLR struct {
+	Transport  *transport.Transport // Must be playing for presses to take effect when they are quantized.
+	mu         sync.Mutex
+	columns    int
+	sampleRate int
+	quantize   int64 // In ticks.
+	rows       []*row
+	held       [StopKey + 1]bool
+	queued     []func() // Changes that take effect at the next quantized tick.
+	recorders  [numRecorders]recorder
+}
+
+// Creates a new MLR of a configuration, for a grid of a number of columns and
+// audio of a sample rate.
+func New(c Config, columns, sampleRate int) *MLR {
+	m := &MLR{
+		Transport:  transport.New(sampleRate),
+		columns:    columns,
+		sampleRate: sampleRate,
+	}
+	if c.Tempo > 0 {
+		m.Transport.SetTempo(c.Tempo)
+	}
+	if c.Quantize > 0 {
+		m.quantize = int64(m.Transport.PPQN / c.Quantize)
+	}
+	for _, t := range c.Tracks {
+		if t.Speed == 0 {
+			t.Speed = 1
+		}
+		m.rows = append(m.rows, &row{Track: t, end: columns})
+	}
+	m.Transport.Every(1, m.tick)
+	return m
+}
+
+// Returns the length of a division of a row's clip, in frames. The MLR must be locked.
+func (m *MLR) division(r *row) float64 {
+	return float64(len(r.Clip.Samples[0])) / float64(m.columns)
+}
+
+// Returns the column of the playhead of a row, or -1 if the row is not playing.
+func (m *MLR) Playhead(track int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.rows[track]
+	if !r.playing {
+		return -1
+	}
+	return int(r.head / m.division(r))
+}
+
+// Changes playback when a button of the grid is pressed or released.
+func (m *MLR) Press(x, y int, pressed bool) {
+	m.press(x, y, pressed, true)
+}
+
+// Changes playback for a press, recording it if record is set and a pattern is recording.
+func (m *MLR) press(x, y int, pressed, record bool) {
+	if x < 0 || x >= m.columns || y < 0 || y > len(m.rows) {
+		return
+	}
+	now := m.Transport.Ticks()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if y == 0 && x < numRecorders {
+		if pressed && record {
+			m.recorders[x].toggle(now, m.loopLength())
+		}
+		return
+	}
+	if record {
+		for i := range m.recorders {
+			if rec := &m.recorders[i]; rec.state == recording {
+				rec.presses = append(rec.presses, recorded{now - rec.start, x, y, pressed})
+			}
+		}
+	}
+	if y == 0 {
+		if x < len(m.held) {
+			m.held[x] = pressed
+		}
+		return
+	}
+
+	r := m.rows[y-1]
+	if !pressed {
+		for i, column := range r.held {
+			if column == x {
+				r.held = append(r.held[:i], r.held[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+	switch {
+	case m.held[MuteKey]:
+		r.muted = !r.muted
+		return
+	case m.held[ReverseKey]:
+		r.Reverse = !r.Reverse
+		return
+	case m.held[SpeedKey]:
+		r.Speed = math.Max(0.25, math.Min(4, math.Pow(2, float64(x-m.columns/2))))
+		return
+	case m.held[StopKey]:
+		m.schedule(func() { r.playing = false })
+		return
+	}
+	r.held = append(r.held, x)
+	if len(r.held) > 1 {
+		from, to := r.held[0], x
+		if from > to {
+			from, to = to, from
+		}
+		m.schedule(func() { r.start, r.end = from, to+1 })
+		return
+	}
+	m.schedule(func() {
+		r.start, r.end = 0, m.columns
+		r.head = float64(x) * m.division(r)
+		r.playing = true
+	})
+}
+
+// Makes a change at the next quantized tick, or immediately if presses are not
+// quantized. The MLR must be locked.
+func (m *MLR) schedule(f func()) {
+	if m.quantize == 0 {
+		f()
+		return
+	}
+	m.queued = append(m.queued, f)
+}
+
+// Returns the ticks that recorded patterns are a multiple of. The MLR must be locked.
+func (m *MLR) loopLength() int64 {
+	if m.quantize > 0 {
+		return m.quantize
+	}
+	return int64(m.Transport.PPQN)
+}
+
+// Starts recording a pattern if it is empty, loops it if it is recording, and
+// clears it if it is looping.
+func (r *recorder) toggle(now, multiple int64) {
+	switch r.state {
+	case empty:
+		r.state, r.start, r.presses = recording, now, nil
+	case recording:
+		if len(r.presses) == 0 {
+			r.state = empty
+			return
+		}
+		r.length = (now - r.start + multiple - 1) / multiple * multiple
+		if r.length == 0 {
+			r.length = multiple
+		}
+		r.state, r.start = looping, r.start+r.length
+	case looping:
+		r.state = empty
+	}
+}
+
+// Makes the changes that are due at a tick, and replays the presses of patterns.
+func (m *MLR) tick(tick int64) {
+	m.mu.Lock()
+	var due []func()
+	if m.quantize == 0 || tick%m.quantize == 0 {
+		due, m.queued = m.queued, nil
+	}
+	for _, f := range due {
+		f()
+	}
+	var replayed []recorded
+	for i := range m.recorders {
+		rec := &m.recorders[i]
+		if rec.state != looping || tick < rec.start {
+			continue
+		}
+		offset := (tick - rec.start) % rec.length
+		for _, p := range rec.presses {
+			if p.tick == offset {
+				replayed = append(replayed, p)
+			}
+		}
+	}
+	m.mu.Unlock()
+	for _, p := range replayed {
+		m.press(p.x, p.y, p.pressed, false)
+	}
+}
+
+// Mixes the rows that are playing into a buffer of two interleaved channels,
+// advancing their playheads. It is called by the audio callback of a sampler.
+func (m *MLR) Render(out []int16) {
+	tempo := m.Transport.Tempo()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.rows {
+		if !r.playing || len(r.Clip.Samples) == 0 || len(r.Clip.Samples[0]) == 0 {
+			continue
+		}
+		rate := 1.0
+		if r.Beats > 0 {
+			rate = float64(len(r.Clip.Samples[0])) / (r.Beats * 60 / tempo * float64(m.sampleRate))
+		} else if r.Clip.SampleRate > 0 {
+			rate = float64(r.Clip.SampleRate) / float64(m.sampleRate)
+		}
+		rate *= r.Speed
+		if r.Reverse {
+			rate = -rate
+		}
+		division := m.division(r)
+		from, to := float64(r.start)*division, float64(r.end)*division
+		for i := 0; i+1 < len(out); i += 2 {
+			if !r.muted {
+				frame := int(r.head)
+				for c := 0; c < 2; c++ {
+					sample := r.Clip.Samples[c%len(r.Clip.Samples)][frame]
+					out[i+c] = mix(out[i+c], float32(sample)*r.Volume)
+				}
+			}
+			r.head += rate
+			if r.head >= to || int(r.head) >= len(r.Clip.Samples[0]) {
+				r.head = from + math.Mod(r.head-from, to-from)
+			} else if r.head < from {
+				r.head = to - math.Mod(from-r.head, to-from)
+				if int(r.head) >= len(r.Clip.Samples[0]) {
+					r.head = from
+				}
+			}
+		}
+	}
+}
+
+// Returns a sample added to another, limited to the range of samples.
+func mix(a int16, b float32) int16 {
+	sum := float32(a) + b
+	switch {
+	case sum > float32(audio.MaxInt16):
+		return audio.MaxInt16
+	case sum < float32(audio.MinInt16):
+		return audio.MinInt16
+	}
+	return int16(sum)
+}
+
+// The colours of the grid.
+var (
+	playheadColor = controller.Color{G: 255}
+	loopColor     = controller.Color{G: 85}
+	mutedColor    = controller.Color{R: 85}
+	recordColor   = controller.Color{R: 255}
+	heldColor     = controller.Color{R: 255, G: 255}
+)
+
+// Draws the rows, their playheads and the keys of the top row into a frame of the grid.
+func (m *MLR) Draw(frame [][]controller.Color) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for y := range frame {
+		for x := range frame[y] {
+			frame[y][x] = controller.Off
+		}
+	}
+	if len(frame) == 0 {
+		return
+	}
+	for x := range frame[0] {
+		switch {
+		case x < numRecorders && m.recorders[x].state == recording:
+			frame[0][x] = recordColor
+		case x < numRecorders && m.recorders[x].state == looping:
+			frame[0][x] = playheadColor
+		case x >= numRecorders && x < len(m.held) && m.held[x]:
+			frame[0][x] = heldColor
+		}
+	}
+	for i, r := range m.rows {
+		if i+1 >= len(frame) || !r.playing {
+			continue
+		}
+		color := loopColor
+		if r.muted {
+			color = mutedColor
+		}
+		for x := r.start; x < r.end && x < len(frame[i+1]); x++ {
+			frame[i+1][x] = color
+		}
+		if x := int(r.head / m.division(r)); x < len(frame[i+1]) && !r.muted {
+			frame[i+1][x] = playheadColor
+		}
+	}
+}
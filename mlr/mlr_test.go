@@ -0,0 +1,103 @@
+package mlr
+
+import (
+	"testing"
+
+	"github.com/aoeu/audio"
+	"github.com/aoeu/audio/transport"
+)
+
+// Returns a mono clip of 8 divisions of 100 frames, whose samples count up from 0.
+func countingClip() *audio.Clip {
+	c := audio.NewClip(1)
+	for i := 0; i < 800; i++ {
+		c.Samples[0] = append(c.Samples[0], int16(i))
+	}
+	return c
+}
+
+// Returns an MLR of a row of a counting clip at 48000 Hz, which is advanced by
+// audio from the first tick.
+func newTestMLR(quantize int) *MLR {
+	c := countingClip()
+	c.SampleRate = 48000
+	m := New(Config{Tracks: []Track{{Clip: c, Volume: 1}}, Quantize: quantize}, 8, 48000)
+	m.Transport.SetSource(transport.Audio)
+	m.Transport.Start()
+	m.Transport.Advance(1)
+	return m
+}
+
+func TestJump(t *testing.T) {
+	m := newTestMLR(4) // Quantized to 24 ticks, or 6000 frames at 120 BPM.
+	m.Press(3, 1, true)
+	m.Press(3, 1, false)
+	if p := m.Playhead(0); p != -1 {
+		t.Errorf("The playhead is at %v before the press was quantized", p)
+	}
+	m.Transport.Advance(6000)
+	if p := m.Playhead(0); p != 3 {
+		t.Fatalf("The playhead is at %v instead of 3", p)
+	}
+	out := make([]int16, 2*4)
+	m.Render(out)
+	for i, s := range []int16{300, 300, 301, 301, 302, 302, 303, 303} {
+		if out[i] != s {
+			t.Errorf("Rendered %v instead of the fourth division", out)
+			break
+		}
+	}
+}
+
+func TestLoop(t *testing.T) {
+	m := newTestMLR(0)
+	m.Press(6, 1, true)
+	m.Press(7, 1, true)
+	if r := m.rows[0]; r.start != 6 || r.end != 8 {
+		t.Fatalf("Looping %v to %v instead of 6 to 8", r.start, r.end)
+	}
+	m.Press(6, 1, false)
+	m.Press(7, 1, false)
+	m.Render(make([]int16, 2*250))
+	if p := m.Playhead(0); p != 6 {
+		t.Errorf("The playhead is at %v instead of looping back to 6", p)
+	}
+
+	m.Press(ReverseKey, 0, true)
+	m.Press(0, 1, true)
+	m.Press(ReverseKey, 0, false)
+	out := make([]int16, 2*2)
+	m.Render(out)
+	if out[0] != 650 || out[2] != 649 {
+		t.Errorf("Rendered %v instead of the loop reversed", out)
+	}
+	m.Press(MuteKey, 0, true)
+	m.Press(0, 1, true)
+	m.Press(MuteKey, 0, false)
+	out = make([]int16, 2*2)
+	m.Render(out)
+	if out[0] != 0 || out[2] != 0 {
+		t.Errorf("Rendered %v from a muted row", out)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	m := newTestMLR(4) // Quantized to 24 ticks, or 6000 frames at 120 BPM.
+	m.Press(RecorderKey, 0, true)
+	m.Transport.Advance(3000)
+	m.Press(5, 1, true)
+	m.Press(5, 1, false)
+	m.Transport.Advance(9000)
+	m.Press(RecorderKey, 0, true) // Loops the pattern of 48 ticks, with the press at tick 12.
+	m.Transport.Advance(6000)
+	m.Press(2, 1, true)
+	m.Press(2, 1, false)
+	m.Transport.Advance(6000)
+	if p := m.Playhead(0); p != 2 {
+		t.Fatalf("The playhead is at %v instead of 2", p)
+	}
+	m.Transport.Advance(6000)
+	if p := m.Playhead(0); p != 5 {
+		t.Errorf("The playhead is at %v instead of 5 from the pattern", p)
+	}
+}
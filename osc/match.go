@@ -0,0 +1,82 @@
+package osc
+
+import "strings"
+
+// Returns true if an address pattern matches the address of a method.
+// Within each part of a pattern between slashes, '?' matches any character,
+// '*' matches any sequence of characters, "[abc]" and "[a-z]" match any of the
+// characters (or any other character if the list begins with '!'),
+// and "{foo,bar}" matches any of the strings.
+func Match(pattern, address string) bool {
+	patterns, parts := strings.Split(pattern, "/"), strings.Split(address, "/")
+	if len(patterns) != len(parts) {
+		return false
+	}
+	for i := range parts {
+		if !matchPart(patterns[i], parts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPart(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchPart(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			end := strings.IndexByte(pattern, ']')
+			if end < 0 || len(s) == 0 || !matchSet(pattern[1:end], s[0]) {
+				return false
+			}
+			pattern, s = pattern[end+1:], s[1:]
+		case '{':
+			end := strings.IndexByte(pattern, '}')
+			if end < 0 {
+				return false
+			}
+			for _, alternative := range strings.Split(pattern[1:end], ",") {
+				if strings.HasPrefix(s, alternative) && matchPart(pattern[end+1:], s[len(alternative):]) {
+					return true
+				}
+			}
+			return false
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// Returns true if a character is in a set of characters and ranges of them, such as "a-z0".
+func matchSet(set string, c byte) bool {
+	negated := len(set) > 0 && set[0] == '!'
+	if negated {
+		set = set[1:]
+	}
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= c && c <= set[i+2] {
+				return !negated
+			}
+			i += 2
+		} else if set[i] == c {
+			return !negated
+		}
+	}
+	return negated
+}
@@ -0,0 +1,170 @@
+package osc
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// A Translator translates between OSC messages and MIDI data.
+type Translator interface {
+	ToMIDI(m Message) []midi.Message
+	FromMIDI(m midi.Message) []Message
+}
+
+// An AddressTranslator translates the MIDI data of messages at addresses under a
+// prefix, such as "/midi":
+//
+//	/midi/note_on  channel key velocity
+//	/midi/note_off channel key velocity
+//	/midi/cc       channel ID value
+//
+// The arguments are sent as int32s, and may be received as any number, as
+// controllers such as TouchOSC send float32s. Messages at the prefix itself with
+// MIDI arguments are received as well.
+type AddressTranslator struct {
+	Prefix string
+}
+
+// Returns the MIDI data of a message, or nil if it is not at an address under the prefix.
+func (t AddressTranslator) ToMIDI(m Message) []midi.Message {
+	if m.Address == t.Prefix {
+		var messages []midi.Message
+		for _, a := range m.Arguments {
+			if v, ok := a.(MIDI); ok {
+				// Types that the midi package does not support are skipped.
+				if d := midi.Decode(uint32(v[1]) | uint32(v[2])<<8 | uint32(v[3])<<16); d != nil {
+					messages = append(messages, d)
+				}
+			}
+		}
+		return messages
+	}
+	if !strings.HasPrefix(m.Address, t.Prefix+"/") || len(m.Arguments) != 3 {
+		return nil
+	}
+	var v [3]int
+	for i, a := range m.Arguments {
+		n, ok := number(a)
+		if !ok {
+			return nil
+		}
+		v[i] = n
+	}
+	switch m.Address[len(t.Prefix):] {
+	case "/note_on":
+		return []midi.Message{midi.NoteOn{Channel: v[0], Key: v[1], Velocity: v[2]}}
+	case "/note_off":
+		return []midi.Message{midi.NoteOff{Channel: v[0], Key: v[1], Velocity: v[2]}}
+	case "/cc":
+		return []midi.Message{midi.ControlChange{Channel: v[0], ID: v[1], Value: v[2]}}
+	}
+	return nil
+}
+
+// Returns the messages of MIDI data, or nil if the data is of another type.
+func (t AddressTranslator) FromMIDI(m midi.Message) []Message {
+	var address string
+	var v [3]int
+	switch m := m.(type) {
+	case midi.NoteOn:
+		address, v = "/note_on", [3]int{m.Channel, m.Key, m.Velocity}
+	case midi.NoteOff:
+		address, v = "/note_off", [3]int{m.Channel, m.Key, m.Velocity}
+	case midi.ControlChange:
+		address, v = "/cc", [3]int{m.Channel, m.ID, m.Value}
+	default:
+		return nil
+	}
+	return []Message{{
+		Address:   t.Prefix + address,
+		Arguments: []interface{}{int32(v[0]), int32(v[1]), int32(v[2])},
+	}}
+}
+
+// Returns the value of a numeric argument, rounded to an int.
+func number(a interface{}) (int, bool) {
+	switch v := a.(type) {
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float32:
+		return int(math.Floor(float64(v) + 0.5)), true
+	case float64:
+		return int(math.Floor(v + 0.5)), true
+	}
+	return 0, false
+}
+
+// A Device is a MIDI device of an OSC endpoint, so that it can be connected to
+// others with Pipes, Routers, Funnels and Chains. The MIDI data sent to the device
+// is sent to its client as OSC messages, and the OSC messages that it serves,
+// as the Handler of a Server, are sent out of it as MIDI data.
+type Device struct {
+	*midi.Wires
+	Translator Translator
+	Client     *Client // The client that messages are sent with, if any.
+	disconnect chan bool
+	closed     chan bool
+	closing    sync.Once
+}
+
+// Creates a new device that translates with a translator and sends messages with a client.
+func NewDevice(t Translator, c *Client) *Device {
+	return &Device{
+		Wires:      midi.NewWires(),
+		Translator: t,
+		Client:     c,
+		disconnect: make(chan bool, 1),
+		closed:     make(chan bool),
+	}
+}
+
+func (d *Device) Open() error {
+	return nil
+}
+
+// Stops sending messages, and drops those served after, without closing the client.
+func (d *Device) Close() error {
+	d.closing.Do(func() {
+		d.disconnect <- true
+		close(d.closed)
+	})
+	return nil
+}
+
+// Sends the MIDI data sent to the device as OSC messages, dropping messages that can not be sent.
+func (d *Device) Connect() {
+	for {
+		select {
+		case m := <-d.In:
+			if d.Client == nil {
+				continue
+			}
+			for _, message := range d.Translator.FromMIDI(m) {
+				d.Client.Send(message)
+			}
+		case <-d.disconnect:
+			return
+		}
+	}
+}
+
+func (d *Device) Wire() *midi.Wires {
+	return d.Wires
+}
+
+// Sends the MIDI data of a message out of the device.
+func (d *Device) ServeOSC(m Message, from net.Addr) {
+	for _, message := range d.Translator.ToMIDI(m) {
+		select {
+		case d.Out <- midi.Stamp(message, midi.Now()):
+		case <-d.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Package osc implements Open Sound Control 1.0: the encoding of messages and
+// bundles, a UDP server that dispatches messages to methods by address pattern,
+// a client, and a device that translates between OSC messages and MIDI data.
+package osc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// A Packet is a Message or a Bundle.
+type Packet interface {
+	MarshalBinary() ([]byte, error)
+}
+
+// A Message is sent to the methods at the addresses that its Address pattern matches.
+// Its Arguments may be of the types int32, float32, string, []byte, int64,
+// float64, Timetag, MIDI, bool, and nil (including Impulse).
+type Message struct {
+	Address   string
+	Arguments []interface{}
+}
+
+// A Bundle is a group of Packets that are to be dispatched at the same time.
+type Bundle struct {
+	Time     Timetag
+	Elements []Packet
+}
+
+// A Timetag is an NTP timestamp: seconds since 1900 in the upper 32 bits and
+// fractions of a second in the lower 32 bits.
+type Timetag uint64
+
+// The Timetag of packets that are to be dispatched as soon as they are received.
+const Immediately Timetag = 1
+
+// The seconds from the NTP epoch to the Unix epoch.
+const ntpEpochOffset = 2208988800
+
+// Returns the Timetag of a point in time.
+func TimetagOf(t time.Time) Timetag {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return Timetag(seconds<<32 | fraction)
+}
+
+// Returns the time of a Timetag, or the current time if it is Immediately.
+func (t Timetag) Time() time.Time {
+	if t == Immediately {
+		return time.Now()
+	}
+	seconds := int64(t>>32) - ntpEpochOffset
+	nanoseconds := int64(uint64(t&0xFFFFFFFF) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
+
+// A MIDI argument is a MIDI message of a port: the port's ID, then a status byte
+// and two data bytes.
+type MIDI [4]byte
+
+// An Impulse argument carries no data, such as to trigger an event.
+type Impulse struct{}
+
+// Encodes the message in the binary format of OSC.
+func (m Message) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	if len(m.Address) == 0 || m.Address[0] != '/' {
+		return nil, fmt.Errorf("Invalid OSC address %q.", m.Address)
+	}
+	writeString(&b, m.Address)
+	tags := []byte{','}
+	var args bytes.Buffer
+	for _, a := range m.Arguments {
+		switch v := a.(type) {
+		case int32:
+			tags = append(tags, 'i')
+			binary.Write(&args, binary.BigEndian, v)
+		case float32:
+			tags = append(tags, 'f')
+			binary.Write(&args, binary.BigEndian, math.Float32bits(v))
+		case string:
+			tags = append(tags, 's')
+			writeString(&args, v)
+		case []byte:
+			tags = append(tags, 'b')
+			binary.Write(&args, binary.BigEndian, int32(len(v)))
+			args.Write(v)
+			pad(&args)
+		case int64:
+			tags = append(tags, 'h')
+			binary.Write(&args, binary.BigEndian, v)
+		case float64:
+			tags = append(tags, 'd')
+			binary.Write(&args, binary.BigEndian, math.Float64bits(v))
+		case Timetag:
+			tags = append(tags, 't')
+			binary.Write(&args, binary.BigEndian, uint64(v))
+		case MIDI:
+			tags = append(tags, 'm')
+			args.Write(v[:])
+		case bool:
+			if v {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		case nil:
+			tags = append(tags, 'N')
+		case Impulse:
+			tags = append(tags, 'I')
+		default:
+			return nil, fmt.Errorf("Unsupported OSC argument type %T.", a)
+		}
+	}
+	writeString(&b, string(tags))
+	b.Write(args.Bytes())
+	return b.Bytes(), nil
+}
+
+// Encodes the bundle in the binary format of OSC.
+func (bundle Bundle) MarshalBinary() ([]byte, error) {
+	var b bytes.Buffer
+	writeString(&b, "#bundle")
+	binary.Write(&b, binary.BigEndian, uint64(bundle.Time))
+	for _, e := range bundle.Elements {
+		data, err := e.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(&b, binary.BigEndian, int32(len(data)))
+		b.Write(data)
+	}
+	return b.Bytes(), nil
+}
+
+// Writes a string terminated by at least one null byte and padded to a multiple of 4 bytes.
+func writeString(b *bytes.Buffer, s string) {
+	b.WriteString(s)
+	b.WriteByte(0)
+	pad(b)
+}
+
+func pad(b *bytes.Buffer) {
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+}
+
+var (
+	errInvalidPacket = errors.New("Invalid OSC packet.")
+	errNotServing    = errors.New("The OSC server is not serving.")
+)
+
+// Decodes a Message or a Bundle from the binary format of OSC.
+func ParsePacket(b []byte) (Packet, error) {
+	if len(b) == 0 || len(b)%4 != 0 {
+		return nil, errInvalidPacket
+	}
+	if b[0] == '#' {
+		return parseBundle(b)
+	}
+	return parseMessage(b)
+}
+
+func parseBundle(b []byte) (Bundle, error) {
+	var bundle Bundle
+	s, b, err := readString(b)
+	if err != nil || s != "#bundle" || len(b) < 8 {
+		return bundle, errInvalidPacket
+	}
+	bundle.Time = Timetag(binary.BigEndian.Uint64(b))
+	for b = b[8:]; len(b) > 0; {
+		if len(b) < 4 {
+			return bundle, errInvalidPacket
+		}
+		size := int(int32(binary.BigEndian.Uint32(b)))
+		if size < 0 || len(b) < 4+size {
+			return bundle, errInvalidPacket
+		}
+		e, err := ParsePacket(b[4 : 4+size])
+		if err != nil {
+			return bundle, err
+		}
+		bundle.Elements = append(bundle.Elements, e)
+		b = b[4+size:]
+	}
+	return bundle, nil
+}
+
+func parseMessage(b []byte) (Message, error) {
+	var m Message
+	var err error
+	if m.Address, b, err = readString(b); err != nil || len(m.Address) == 0 || m.Address[0] != '/' {
+		return m, errInvalidPacket
+	}
+	if len(b) == 0 { // Type tags may be omitted by older implementations.
+		return m, nil
+	}
+	var tags string
+	if tags, b, err = readString(b); err != nil || len(tags) == 0 || tags[0] != ',' {
+		return m, errInvalidPacket
+	}
+	for _, tag := range tags[1:] {
+		var a interface{}
+		size := 0
+		switch tag {
+		case 'i', 'f', 'm':
+			size = 4
+		case 'h', 'd', 't':
+			size = 8
+		}
+		if len(b) < size {
+			return m, errInvalidPacket
+		}
+		switch tag {
+		case 'i':
+			a = int32(binary.BigEndian.Uint32(b))
+		case 'f':
+			a = math.Float32frombits(binary.BigEndian.Uint32(b))
+		case 'm':
+			var v MIDI
+			copy(v[:], b)
+			a = v
+		case 'h':
+			a = int64(binary.BigEndian.Uint64(b))
+		case 'd':
+			a = math.Float64frombits(binary.BigEndian.Uint64(b))
+		case 't':
+			a = Timetag(binary.BigEndian.Uint64(b))
+		case 's', 'S':
+			if a, b, err = readString(b); err != nil {
+				return m, err
+			}
+		case 'b':
+			if len(b) < 4 {
+				return m, errInvalidPacket
+			}
+			n := int(int32(binary.BigEndian.Uint32(b)))
+			padded := 4 + (n+3)/4*4
+			if n < 0 || len(b) < padded {
+				return m, errInvalidPacket
+			}
+			a = append([]byte{}, b[4:4+n]...)
+			b = b[padded:]
+		case 'T':
+			a = true
+		case 'F':
+			a = false
+		case 'N':
+			a = nil
+		case 'I':
+			a = Impulse{}
+		default:
+			return m, fmt.Errorf("Unsupported OSC type tag %q.", tag)
+		}
+		b = b[size:]
+		m.Arguments = append(m.Arguments, a)
+	}
+	return m, nil
+}
+
+// Reads a string that is padded to a multiple of 4 bytes, returning the bytes after it.
+func readString(b []byte) (string, []byte, error) {
+	n := bytes.IndexByte(b, 0)
+	if n < 0 {
+		return "", b, errInvalidPacket
+	}
+	padded := (n + 4) / 4 * 4
+	if padded > len(b) {
+		return "", b, errInvalidPacket
+	}
+	return string(b[:n]), b[padded:], nil
+}
@@ -0,0 +1,183 @@
+package osc
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestPackets(t *testing.T) {
+	expected := Bundle{
+		Time: TimetagOf(time.Unix(1500000000, 500000000)),
+		Elements: []Packet{
+			Message{Address: "/grid/led/set", Arguments: []interface{}{
+				int32(-1), float32(0.5), "abcd", []byte{1, 2, 3}, int64(1) << 40,
+				2.25, Immediately, MIDI{0, 0x90, 60, 100}, true, false, nil, Impulse{}}},
+			Bundle{Time: Immediately, Elements: []Packet{Message{Address: "/a"}}},
+		},
+	}
+	b, err := expected.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ParsePacket(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Decoded %+v instead of %+v", actual, expected)
+	}
+	if _, err := ParsePacket(b[:len(b)-4]); err == nil {
+		t.Errorf("Decoded a truncated bundle")
+	}
+	if _, err := (Message{Address: "a"}).MarshalBinary(); err == nil {
+		t.Errorf("Encoded a message with an invalid address")
+	}
+}
+
+func TestTimetags(t *testing.T) {
+	now := time.Unix(1500000000, 123456789)
+	if d := TimetagOf(now).Time().Sub(now); d < -time.Nanosecond || d > time.Nanosecond {
+		t.Errorf("Converted %v to a timetag of %v", now, now.Add(d))
+	}
+	if tag := TimetagOf(time.Unix(0, 0)); tag != ntpEpochOffset<<32 {
+		t.Errorf("The timetag of the Unix epoch is %x", uint64(tag))
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, address string
+		matches          bool
+	}{
+		{"/grid/led/set", "/grid/led/set", true},
+		{"/grid/led/set", "/grid/led/map", false},
+		{"/grid/*", "/grid/led/set", false},
+		{"/grid/*/set", "/grid/led/set", true},
+		{"/grid/l?d/*t", "/grid/led/set", true},
+		{"/track/[1-4]", "/track/3", true},
+		{"/track/[!1-4]", "/track/3", false},
+		{"/track/[!1-4]", "/track/5", true},
+		{"/track/[abc]", "/track/b", true},
+		{"/{grid,tilt}/key", "/tilt/key", true},
+		{"/{grid,tilt}/key", "/arc/key", false},
+		{"/*/*", "/a/b", true},
+		{"/*", "/a/b", false},
+	}
+	for _, test := range tests {
+		if Match(test.pattern, test.address) != test.matches {
+			t.Errorf("Match(%q, %q) is %v", test.pattern, test.address, !test.matches)
+		}
+	}
+}
+
+// Returns a server of a handler that is listening on a free port of the loopback interface.
+func serve(t *testing.T, h Handler) *Server {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Handler: h}
+	go s.Serve(conn)
+	for s.LocalAddr() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	return s
+}
+
+func TestServer(t *testing.T) {
+	received := make(chan Message, 4)
+	mux := NewMux()
+	mux.HandleFunc("/grid/key", func(m Message, from net.Addr) {
+		received <- m
+	})
+	mux.HandleFunc("/sys/port", func(m Message, from net.Addr) {
+		received <- m
+	})
+	s := serve(t, mux)
+	defer s.Close()
+	c, err := Dial(s.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	later := time.Now().Add(50 * time.Millisecond)
+	c.Send(Bundle{Time: TimetagOf(later), Elements: []Packet{Message{Address: "/sys/port"}}})
+	c.Send(Message{Address: "/*/key", Arguments: []interface{}{int32(1), int32(2), int32(1)}})
+	c.Send(Message{Address: "/grid/led/set"})
+	for _, expected := range []string{"/*/key", "/sys/port"} {
+		select {
+		case m := <-received:
+			if m.Address != expected {
+				t.Errorf("Received %v instead of a message at %v", m, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive a message at %v", expected)
+		}
+	}
+	if time.Now().Before(later) {
+		t.Errorf("Dispatched a bundle before its timetag")
+	}
+}
+
+func TestDevice(t *testing.T) {
+	received := make(chan Message, 1)
+	peer := serve(t, HandlerFunc(func(m Message, from net.Addr) {
+		received <- m
+	}))
+	defer peer.Close()
+	c, err := Dial(peer.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	d := NewDevice(AddressTranslator{Prefix: "/midi"}, c)
+	s := serve(t, d)
+	defer s.Close()
+	d.Open()
+	go d.Connect()
+	defer d.Close()
+
+	d.In <- midi.ControlChange{Channel: 1, ID: 7, Value: 100}
+	select {
+	case m := <-received:
+		expected := Message{Address: "/midi/cc", Arguments: []interface{}{int32(1), int32(7), int32(100)}}
+		if !reflect.DeepEqual(m, expected) {
+			t.Errorf("Sent %v instead of %v", m, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Did not send a message")
+	}
+
+	peer.SendTo(Message{Address: "/midi/note_on", Arguments: []interface{}{int32(2), float32(60), float32(99.6)}}, s.LocalAddr())
+	peer.SendTo(Message{Address: "/midi", Arguments: []interface{}{MIDI{0, 0x82, 60, 0}}}, s.LocalAddr())
+	for _, expected := range []midi.Message{
+		midi.NoteOn{Channel: 2, Key: 60, Velocity: 100},
+		midi.NoteOff{Channel: 2, Key: 60},
+	} {
+		select {
+		case m := <-d.Out:
+			if m.Uint32() != expected.Uint32() {
+				t.Errorf("Received %v instead of %v", m, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not receive %v", expected)
+		}
+	}
+}
+
+func TestAddressTranslator(t *testing.T) {
+	tr := AddressTranslator{Prefix: "/midi"}
+	m := Message{Address: "/midi", Arguments: []interface{}{MIDI{0, 0xE0, 0, 64}, MIDI{0, 0x91, 60, 100}}}
+	messages := tr.ToMIDI(m)
+	if len(messages) != 1 || messages[0] == nil || messages[0].Uint32() != (midi.NoteOn{Channel: 1, Key: 60, Velocity: 100}).Uint32() {
+		t.Errorf("Translated %v to %v rather than only its note", m, messages)
+	}
+	if messages := tr.ToMIDI(Message{Address: "/midi", Arguments: []interface{}{MIDI{0, 0xC0, 5, 0}}}); len(messages) != 0 {
+		t.Errorf("Translated a program change to %v", messages)
+	}
+}
@@ -0,0 +1,191 @@
+package osc
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// The largest packet that is received.
+const maxPacketSize = 65507
+
+// A Handler serves the OSC messages that are dispatched to it.
+type Handler interface {
+	ServeOSC(m Message, from net.Addr)
+}
+
+// A HandlerFunc is a function that serves OSC messages.
+type HandlerFunc func(m Message, from net.Addr)
+
+func (f HandlerFunc) ServeOSC(m Message, from net.Addr) {
+	f(m, from)
+}
+
+// A Mux is a Handler that dispatches each message to the methods at the
+// addresses that the message's address pattern matches.
+type Mux struct {
+	mu      sync.Mutex
+	methods map[string]Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{methods: make(map[string]Handler)}
+}
+
+// Adds a method at an address, such as "/grid/led/set", replacing any other.
+func (x *Mux) Handle(address string, h Handler) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.methods[address] = h
+}
+
+// Adds a method at an address that is served by a function.
+func (x *Mux) HandleFunc(address string, f func(m Message, from net.Addr)) {
+	x.Handle(address, HandlerFunc(f))
+}
+
+// Dispatches a message to every method that its address pattern matches.
+func (x *Mux) ServeOSC(m Message, from net.Addr) {
+	x.mu.Lock()
+	var matched []Handler
+	for address, h := range x.methods {
+		if Match(m.Address, address) {
+			matched = append(matched, h)
+		}
+	}
+	x.mu.Unlock()
+	for _, h := range matched {
+		h.ServeOSC(m, from)
+	}
+}
+
+// A Server receives OSC packets over UDP and dispatches their messages to its
+// Handler, one at a time. The messages of bundles are dispatched at the bundles'
+// Timetags, or as soon as they are received if their Timetags have passed.
+type Server struct {
+	Addr    string // The address to listen on, e.g. ":8000".
+	Handler Handler
+	mu      sync.Mutex
+	conn    *net.UDPConn
+}
+
+// Listens on the server's address and serves the packets received until the server is closed.
+func (s *Server) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serves the packets received by a connection until the server is closed.
+// Packets that can not be decoded are dropped.
+func (s *Server) Serve(conn *net.UDPConn) error {
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	var dispatching sync.Mutex // Held by handlers, including those of bundles that are due later.
+	b := make([]byte, maxPacketSize)
+	for {
+		n, from, err := conn.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+		p, err := ParsePacket(b[:n])
+		if err != nil {
+			continue
+		}
+		s.dispatch(p, from, &dispatching)
+	}
+}
+
+func (s *Server) dispatch(p Packet, from net.Addr, dispatching *sync.Mutex) {
+	switch p := p.(type) {
+	case Message:
+		dispatching.Lock()
+		s.Handler.ServeOSC(p, from)
+		dispatching.Unlock()
+	case Bundle:
+		dispatch := func() {
+			for _, e := range p.Elements {
+				s.dispatch(e, from, dispatching)
+			}
+		}
+		if d := time.Until(p.Time.Time()); p.Time != Immediately && d > 0 {
+			time.AfterFunc(d, dispatch)
+			return
+		}
+		dispatch()
+	}
+}
+
+// Sends a packet from the server's port, such as in reply to a message.
+func (s *Server) SendTo(p Packet, addr net.Addr) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return errNotServing
+	}
+	_, err = conn.WriteTo(b, addr)
+	return err
+}
+
+// Returns the address that the server listens on, once it is serving.
+func (s *Server) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// Stops serving.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// A Client sends OSC packets over UDP to a server.
+type Client struct {
+	conn *net.UDPConn
+}
+
+// Creates a new client of the server at an address, e.g. "localhost:8000".
+func Dial(addr string) (*Client, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) Send(p Packet) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(b)
+	return err
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/transport"
 	"github.com/gordonklaus/portaudio"
 	"io/ioutil"
 )
@@ -65,6 +67,13 @@ func (b *RingBuffer) IncreaseLen(length int) {
 
 // A simple software sampler.
 type Sampler struct {
+	// If set, the transport is advanced by the frames of audio that the sampler
+	// outputs, which keeps the instruments that follow it in time with the audio,
+	// unless the transport follows a MIDI clock.
+	Transport *transport.Transport
+	// If set, Render mixes audio into each buffer of two interleaved channels
+	// that the sampler outputs, before the transport is advanced by it.
+	Render func(out []int16)
 	clips  map[int]*Clip
 	stream *portaudio.Stream
 	buffer RingBuffer
@@ -115,6 +124,12 @@ func (s *Sampler) RunAtRate(sampleRate int) error {
 	if err := portaudio.Initialize(); err != nil {
 		return err
 	}
+	if s.Transport != nil {
+		s.Transport.SetSampleRate(sampleRate)
+		if s.Transport.Source() != transport.MIDI {
+			s.Transport.SetSource(transport.Audio)
+		}
+	}
 	var err error
 	s.stream, err = portaudio.OpenDefaultStream(0, 2, float64(sampleRate), 0, s.processAudio)
 	if err != nil {
@@ -152,6 +167,17 @@ func (s *Sampler) Play(noteNum int, volume float32) {
 	}
 }
 
+// Creates a MIDI device that plays the sampler for every NoteOn it receives
+// at a volume scaled by the note's velocity, passing all MIDI data through.
+func (s *Sampler) Device(volume float32) *midi.Processor {
+	return midi.NewProcessor(func(m midi.Message) []midi.Message {
+		if n, ok := m.(midi.NoteOn); ok && n.Velocity > 0 {
+			go s.Play(n.Key, volume*float32(n.Velocity)/127)
+		}
+		return []midi.Message{m}
+	})
+}
+
 // Audio processing function needed by the audio device.
 // This method should be private, but needs to be exported for use by
 // the underlying audio device.
@@ -164,4 +190,10 @@ func (s *Sampler) processAudio(_, out []int16) {
 		s.buffer.Data[index] = 0
 		s.buffer.Next()
 	}
+	if s.Render != nil {
+		s.Render(out)
+	}
+	if s.Transport != nil {
+		s.Transport.Advance(len(out) / 2)
+	}
 }
@@ -0,0 +1,75 @@
+package sequencer
+
+import "sync"
+
+// A Step is a step of a track of a pattern, which plays a note when it is on.
+type Step struct {
+	On          bool
+	Channel     int
+	Note        int     // The key of the note, or the note number of a sampler's clip.
+	Velocity    int     // From 1 to 127.
+	Probability float64 // From 0 to 1, the chance that the step plays when it is on.
+	Gate        float64 // The length of the note, as a fraction of a step.
+}
+
+// A Pattern is a number of tracks of a number of steps, which may be changed
+// while it is played.
+type Pattern struct {
+	mu    sync.Mutex
+	steps [][]Step // By track and step.
+}
+
+// Creates a new pattern of tracks of steps that are off, whose notes are the
+// numbers of their tracks.
+func NewPattern(tracks, steps int) *Pattern {
+	p := &Pattern{steps: make([][]Step, tracks)}
+	for track := range p.steps {
+		p.steps[track] = make([]Step, steps)
+		for i := range p.steps[track] {
+			p.steps[track][i] = Step{Note: track, Velocity: 100, Probability: 1, Gate: 0.5}
+		}
+	}
+	return p
+}
+
+// Returns the number of tracks and of steps of the pattern.
+func (p *Pattern) Size() (tracks, steps int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.steps) == 0 {
+		return 0, 0
+	}
+	return len(p.steps), len(p.steps[0])
+}
+
+func (p *Pattern) Step(track, step int) Step {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.steps[track][step]
+}
+
+func (p *Pattern) SetStep(track, step int, s Step) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.steps[track][step] = s
+}
+
+// Turns a step on if it is off, or off if it is on, returning whether it is on.
+func (p *Pattern) Toggle(track, step int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &p.steps[track][step]
+	s.On = !s.On
+	return s.On
+}
+
+// Returns the steps of all tracks at a step.
+func (p *Pattern) column(step int) []Step {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	steps := make([]Step, len(p.steps))
+	for track := range p.steps {
+		steps[track] = p.steps[track][step]
+	}
+	return steps
+}
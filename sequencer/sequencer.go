@@ -0,0 +1,381 @@
+// Package sequencer provides a step sequencer of patterns of tracks of steps,
+// played at a tempo with swing as MIDI data or by triggering a sampler.
+package sequencer
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/transport"
+)
+
+// The tempo and number of steps per beat that sequencers are created with.
+const (
+	DefaultTempo        = 120
+	DefaultStepsPerBeat = 4
+)
+
+// A Position is a step of a pattern of a sequencer's chain of patterns.
+type Position struct {
+	Pattern int
+	Step    int
+}
+
+// A noteOff is a NoteOff scheduled at a time.
+type noteOff struct {
+	at time.Time
+	midi.NoteOff
+}
+
+// A Sequencer is a device that plays a chain of patterns in turn, sending the
+// notes of their steps out of it. Steps are scheduled at times from when playback
+// started, at the tempo, rather than from when the steps before them were played,
+// so that playback does not drift.
+type Sequencer struct {
+	*midi.Wires
+	Steps chan Position // Receives the position of each step as it is played, if it has room.
+	// If set, steps call Trigger, such as the Play method of an audio.Sampler,
+	// with their notes and velocities from 0 to 1, rather than sending MIDI data.
+	Trigger      func(note int, volume float32)
+	mu           sync.Mutex
+	chain        []*Pattern
+	queued       []*Pattern // A chain that is played once the pattern that is playing ends.
+	tempo        float64
+	swing        float64
+	stepsPerBeat int
+	playing      bool
+	anchor       time.Time // When the step of the anchor was (or will be) played, without swing.
+	anchorStep   int
+	step         int // The number of the next step to be played, counted from the first.
+	position     Position
+	offs         []noteOff // Sorted by time.
+	rand         *rand.Rand
+	transport    *transport.Transport // If set, steps are played as it reaches them.
+	released     int                  // The number of steps that the transport has reached.
+	clocked      chan time.Time
+	changed      chan bool
+	disconnect   chan bool
+}
+
+// Creates a new sequencer of a chain of patterns.
+func New(patterns ...*Pattern) *Sequencer {
+	return &Sequencer{
+		Wires:        midi.NewWires(),
+		Steps:        make(chan Position, 16),
+		chain:        patterns,
+		tempo:        DefaultTempo,
+		stepsPerBeat: DefaultStepsPerBeat,
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		clocked:      make(chan time.Time, 1),
+		changed:      make(chan bool, 1),
+		disconnect:   make(chan bool, 1),
+	}
+}
+
+func (s *Sequencer) Open() error {
+	return nil
+}
+
+// Stops playback and closes the sequencer.
+func (s *Sequencer) Close() error {
+	s.disconnect <- true
+	return nil
+}
+
+func (s *Sequencer) Wire() *midi.Wires {
+	return s.Wires
+}
+
+// Plays the patterns while the sequencer is playing, until it is closed.
+func (s *Sequencer) Connect() {
+	for {
+		s.mu.Lock()
+		var due <-chan time.Time
+		var timer *time.Timer
+		if s.active() || len(s.offs) > 0 {
+			timer = time.NewTimer(time.Until(s.next()))
+			due = timer.C
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-due:
+			if !s.play(time.Now()) {
+				return
+			}
+		case at := <-s.clocked:
+			s.mu.Lock()
+			if s.transport != nil && s.playing {
+				// Anchor the step at the transport, which it may be delayed from by swing.
+				s.tempo = s.transport.Tempo()
+				s.anchor, s.anchorStep = at, s.step
+				s.released = s.step + 1
+			}
+			s.mu.Unlock()
+		case <-s.changed:
+		case <-s.disconnect:
+			return
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Returns true if the sequencer is playing a chain, and the transport it follows
+// (if any) has reached the next step. The sequencer must be locked.
+func (s *Sequencer) active() bool {
+	return s.playing && len(s.chain) > 0 && (s.transport == nil || s.step < s.released)
+}
+
+// Returns when the next step or note off is due. The sequencer must be locked.
+func (s *Sequencer) next() time.Time {
+	if len(s.offs) > 0 && (!s.active() || s.offs[0].at.Before(s.stepTime(s.step))) {
+		return s.offs[0].at
+	}
+	return s.stepTime(s.step)
+}
+
+// Returns the length of a step at the tempo. The sequencer must be locked.
+func (s *Sequencer) stepLength() time.Duration {
+	return time.Duration(float64(time.Minute) / s.tempo / float64(s.stepsPerBeat))
+}
+
+// Returns when a step is played, delaying every second step by the swing.
+// The sequencer must be locked.
+func (s *Sequencer) stepTime(step int) time.Time {
+	length := s.stepLength()
+	t := s.anchor.Add(time.Duration(step-s.anchorStep) * length)
+	if step%2 == 1 {
+		t = t.Add(time.Duration(s.swing * float64(length)))
+	}
+	return t
+}
+
+// Plays the note offs and the step that are due by a time, returning false if
+// the sequencer was closed while sending them.
+func (s *Sequencer) play(now time.Time) bool {
+	s.mu.Lock()
+	var messages []midi.Message
+	i := 0
+	for ; i < len(s.offs) && !s.offs[i].at.After(now); i++ {
+		messages = append(messages, midi.Stamp(s.offs[i].NoteOff, midi.TimestampOf(s.offs[i].at)))
+	}
+	s.offs = s.offs[i:]
+	var played *Position
+	if s.active() && !s.stepTime(s.step).After(now) {
+		messages = append(messages, s.playStep()...)
+		p := s.position
+		played = &p
+		s.advance()
+	}
+	trigger := s.Trigger
+	s.mu.Unlock()
+
+	if played != nil {
+		select {
+		case s.Steps <- *played:
+		default:
+		}
+	}
+	for _, m := range messages {
+		if trigger != nil {
+			if n, ok := m.(midi.NoteOn); ok {
+				trigger(n.Key, float32(n.Velocity)/127)
+			}
+			continue
+		}
+		select {
+		case s.Out <- m:
+		case <-s.disconnect:
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the notes of the step that is due, scheduling their note offs.
+// The sequencer must be locked.
+func (s *Sequencer) playStep() (notes []midi.Message) {
+	at := s.stepTime(s.step)
+	p := s.chain[s.position.Pattern]
+	if _, steps := p.Size(); s.position.Step >= steps {
+		return nil
+	}
+	for _, step := range p.column(s.position.Step) {
+		if !step.On || s.rand.Float64() >= step.Probability {
+			continue
+		}
+		notes = append(notes, midi.NoteOn{
+			Channel:  step.Channel,
+			Key:      step.Note,
+			Velocity: step.Velocity,
+			Time:     midi.TimestampOf(at),
+		})
+		off := at.Add(time.Duration(step.Gate * float64(s.stepLength())))
+		s.offs = append(s.offs, noteOff{off, midi.NoteOff{Channel: step.Channel, Key: step.Note}})
+	}
+	sort.SliceStable(s.offs, func(i, j int) bool { return s.offs[i].at.Before(s.offs[j].at) })
+	return notes
+}
+
+// Moves to the next step, and to the next pattern of the chain (or the chain that
+// is queued) after the last step of a pattern. The sequencer must be locked.
+func (s *Sequencer) advance() {
+	s.step++
+	s.position.Step++
+	if _, steps := s.chain[s.position.Pattern].Size(); s.position.Step < steps {
+		return
+	}
+	s.position.Step = 0
+	if s.queued != nil {
+		s.chain, s.queued = s.queued, nil
+		s.position.Pattern = 0
+		return
+	}
+	s.position.Pattern = (s.position.Pattern + 1) % len(s.chain)
+}
+
+// Signals the Connect loop that playback was changed. The sequencer must be locked.
+func (s *Sequencer) notify() {
+	select {
+	case s.changed <- true:
+	default:
+	}
+}
+
+// Plays steps as a transport reaches them, at its tempo, rather than by the
+// sequencer's own clock, which keeps the sequencer in time with the audio or
+// MIDI clock that advances the transport. Steps start at the ticks that divide
+// each beat by the steps per beat, as they are set, and there is a step at most
+// every tick.
+func (s *Sequencer) Follow(t *transport.Transport) {
+	s.mu.Lock()
+	s.transport, s.released = t, s.step
+	s.mu.Unlock()
+	t.Every(1, func(tick int64) {
+		s.mu.Lock()
+		stepsPerBeat := s.stepsPerBeat
+		s.mu.Unlock()
+		if !t.StepStarts(tick, stepsPerBeat) {
+			return
+		}
+		select {
+		case s.clocked <- time.Now():
+		default:
+		}
+	})
+}
+
+// Starts (or resumes) playback from the current position.
+func (s *Sequencer) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.playing {
+		return
+	}
+	s.playing = true
+	s.anchor, s.anchorStep = time.Now(), s.step
+	s.notify()
+}
+
+// Stops (pauses) playback at the current position, releasing any sounding notes.
+func (s *Sequencer) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.playing = false
+	now := time.Now()
+	for i := range s.offs {
+		s.offs[i].at = now
+	}
+	s.notify()
+}
+
+// Moves playback to the first step of the first pattern of the chain.
+func (s *Sequencer) Rewind() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.position = Position{}
+	s.anchor = s.anchor.Add(time.Duration(s.step-s.anchorStep) * s.stepLength())
+	s.anchorStep, s.step, s.released = 0, 0, 0
+	s.notify()
+}
+
+// Sets the chain of patterns that are played in turn. While playing, the chain
+// is played once the pattern that is playing ends.
+func (s *Sequencer) Chain(patterns ...*Pattern) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.playing {
+		s.queued = patterns
+		return
+	}
+	s.chain, s.queued = patterns, nil
+	s.position = Position{}
+}
+
+// Returns the position of the next step to be played.
+func (s *Sequencer) Position() Position {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position
+}
+
+// Returns true if the sequencer is playing.
+func (s *Sequencer) Playing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.playing
+}
+
+// Returns the tempo in beats per minute.
+func (s *Sequencer) Tempo() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tempo
+}
+
+// Sets the tempo in beats per minute, from the next step.
+func (s *Sequencer) SetTempo(bpm float64) {
+	s.setTiming(bpm, s.StepsPerBeat())
+}
+
+func (s *Sequencer) StepsPerBeat() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stepsPerBeat
+}
+
+// Sets the number of steps per beat, such as 4 for steps of sixteenth notes.
+func (s *Sequencer) SetStepsPerBeat(steps int) {
+	s.setTiming(s.Tempo(), steps)
+}
+
+func (s *Sequencer) setTiming(bpm float64, stepsPerBeat int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bpm <= 0 || stepsPerBeat <= 0 {
+		return
+	}
+	// Anchor the next step where it would have been played, without swing.
+	length := s.stepLength()
+	s.anchor = s.anchor.Add(time.Duration(s.step-s.anchorStep) * length)
+	s.anchorStep = s.step
+	s.tempo, s.stepsPerBeat = bpm, stepsPerBeat
+	s.notify()
+}
+
+// Sets the swing, from 0 for none to less than 1, as the fraction of a step that
+// every second step is delayed by. A swing of 1/3 is of triplets.
+func (s *Sequencer) SetSwing(swing float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if swing < 0 || swing >= 1 {
+		return
+	}
+	s.swing = swing
+	s.notify()
+}
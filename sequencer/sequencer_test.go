@@ -0,0 +1,160 @@
+package sequencer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+	"github.com/aoeu/audio/transport"
+)
+
+func receive(t *testing.T, s *Sequencer) midi.Message {
+	select {
+	case m := <-s.Out:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("Did not receive a message")
+	}
+	return nil
+}
+
+func TestSequencer(t *testing.T) {
+	p := NewPattern(2, 4)
+	p.Toggle(0, 0)
+	p.Toggle(1, 1)
+	p.SetStep(0, 2, Step{On: true, Note: 60, Velocity: 90, Probability: 1, Gate: 1.5})
+	p.SetStep(1, 3, Step{On: true, Note: 61, Velocity: 90, Probability: 0, Gate: 0.5})
+	s := New(p)
+	s.SetTempo(600) // Steps of 25 milliseconds.
+	go s.Connect()
+	defer s.Close()
+	s.Start()
+
+	type note struct {
+		on   bool
+		key  int
+		step float64 // The time of the message, in steps from the first.
+	}
+	expected := []note{
+		{true, 0, 0}, {false, 0, 0.5},
+		{true, 1, 1}, {false, 1, 1.5},
+		{true, 60, 2}, {false, 60, 3.5},
+		{true, 0, 4}, {false, 0, 4.5},
+	}
+	var start midi.Timestamp
+	for i, e := range expected {
+		m := receive(t, s)
+		if i == 0 {
+			start = m.Timestamp()
+		}
+		on := false
+		var key int
+		switch n := m.(type) {
+		case midi.NoteOn:
+			on, key = true, n.Key
+		case midi.NoteOff:
+			key = n.Key
+		}
+		at := float64(m.Timestamp()-start) / float64(25*time.Millisecond)
+		if on != e.on || key != e.key || at != e.step {
+			t.Errorf("Received %v at step %v instead of %+v", m, at, e)
+		}
+	}
+	s.Stop()
+	if s.Playing() {
+		t.Errorf("The sequencer is playing after it was stopped")
+	}
+}
+
+func TestSwingAndTempo(t *testing.T) {
+	s := New(NewPattern(1, 16))
+	s.anchor = time.Unix(0, 0)
+	s.SetSwing(1.0 / 3)
+	length := 125 * time.Millisecond
+	for step, at := range []time.Duration{0, length * 4 / 3, 2 * length, length * 10 / 3} {
+		if got := s.stepTime(step).Sub(s.anchor); got != at {
+			t.Errorf("Step %v is at %v instead of %v", step, got, at)
+		}
+	}
+	s.step = 4
+	s.SetTempo(60)
+	if got := s.stepTime(5).Sub(time.Unix(0, 0)); got != 4*length+250*time.Millisecond*4/3 {
+		t.Errorf("Changing the tempo moved step 5 to %v", got)
+	}
+}
+
+func TestChain(t *testing.T) {
+	a, b := NewPattern(1, 2), NewPattern(1, 3)
+	a.Toggle(0, 0)
+	s := New(a, b)
+	s.SetTempo(300)
+	s.Trigger = func(note int, volume float32) {}
+	go s.Connect()
+	defer s.Close()
+	var played []Position
+	s.Start()
+	for len(played) < 7 {
+		select {
+		case p := <-s.Steps:
+			played = append(played, p)
+		case <-time.After(time.Second):
+			t.Fatal("Did not play a step")
+		}
+		if len(played) == 1 {
+			s.Chain(b)
+		}
+	}
+	expected := []Position{{0, 0}, {0, 1}, {0, 0}, {0, 1}, {0, 2}, {0, 0}, {0, 1}}
+	for i := range expected {
+		if played[i] != expected[i] {
+			t.Errorf("Played %v instead of %v", played, expected)
+			break
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	tr := transport.New(48000)
+	tr.SetSource(transport.Audio)
+	s := New(NewPattern(1, 4))
+	s.Trigger = func(note int, volume float32) {}
+	s.Follow(tr)
+	go s.Connect()
+	defer s.Close()
+	s.Start()
+	select {
+	case p := <-s.Steps:
+		t.Fatalf("Played %v before the transport started", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tr.Start()
+	for step := 0; step < 6; step++ {
+		tr.Advance(6000) // A sixteenth note at 120 BPM.
+		select {
+		case p := <-s.Steps:
+			if p.Step != step%4 {
+				t.Errorf("Played step %v instead of %v", p.Step, step%4)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Did not play step %v", step)
+		}
+	}
+	select {
+	case p := <-s.Steps:
+		t.Errorf("Played %v without the transport advancing", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Steps of eighth note triplets follow the transport once they are set.
+	s.SetStepsPerBeat(3)
+	tr.Locate(0)
+	for step := 0; step < 3; step++ {
+		tr.Advance(8000) // An eighth note triplet at 120 BPM.
+		select {
+		case <-s.Steps:
+		case <-time.After(time.Second):
+			t.Fatalf("Did not play triplet %v", step)
+		}
+	}
+}
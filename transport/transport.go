@@ -0,0 +1,387 @@
+// Package transport provides a transport of musical time, in bars, beats and
+// ticks, that is advanced by the sample counter of an audio callback, by the
+// system clock, or by the timing clock of a MIDI device, and that sends a timing
+// clock to MIDI devices when it is not following one.
+package transport
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+// The resolution, tempo and meter that transports are created with.
+const (
+	DefaultPPQN        = 96
+	DefaultTempo       = 120
+	DefaultBeatsPerBar = 4
+)
+
+// A Source is what advances a transport.
+type Source int
+
+const (
+	System Source = iota // The system clock, while the transport is connected.
+	Audio                // An audio callback, calling Advance with the frames it processes.
+	MIDI                 // The timing clock, start, continue and stop sent to the transport.
+)
+
+// A Position is a position in musical time, from bar 0, beat 0 and tick 0.
+type Position struct {
+	Bar, Beat, Tick int
+}
+
+// Returns the position as musicians count it, from bar 1 and beat 1.
+func (p Position) String() string {
+	return fmt.Sprintf("%v.%v.%v", p.Bar+1, p.Beat+1, p.Tick)
+}
+
+// An event is a function that is called at a tick, and again every number of ticks if any.
+type event struct {
+	tick  int64
+	every int64
+	f     func(tick int64)
+}
+
+// A Transport is a device that keeps musical time while it is playing, sending
+// a timing clock out of it as it does, unless it follows the timing clock sent to it.
+type Transport struct {
+	*midi.Wires
+	PPQN        int // Ticks per quarter note, a multiple of 24. It must not change once connected.
+	BeatsPerBar int
+	mu          sync.Mutex
+	source      Source
+	sampleRate  int
+	tempo       float64
+	playing     bool
+	ticks       float64 // The position, in ticks and a fraction of a tick.
+	events      []*event
+	lastClock   midi.Timestamp // When the last timing clock was sent to the transport.
+	clocks      chan midi.Message
+	disconnect  chan bool
+}
+
+// Creates a new transport that is advanced by the system clock, of audio of a sample rate.
+func New(sampleRate int) *Transport {
+	return &Transport{
+		Wires:       midi.NewWires(),
+		PPQN:        DefaultPPQN,
+		BeatsPerBar: DefaultBeatsPerBar,
+		sampleRate:  sampleRate,
+		tempo:       DefaultTempo,
+		clocks:      make(chan midi.Message, 256),
+		disconnect:  make(chan bool, 1),
+	}
+}
+
+func (t *Transport) Open() error {
+	return nil
+}
+
+func (t *Transport) Close() error {
+	t.disconnect <- true
+	return nil
+}
+
+func (t *Transport) Wire() *midi.Wires {
+	return t.Wires
+}
+
+// Follows the MIDI data sent to the transport, advances the transport by the
+// system clock, and sends the timing clock out of the transport, until it is closed.
+func (t *Transport) Connect() {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	// The clock is sent apart from advancing, which must not wait for it to be received.
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case m := <-t.clocks:
+				select {
+				case t.Out <- m:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	last := time.Now()
+	for {
+		select {
+		case m := <-t.In:
+			if r, ok := m.(midi.Realtime); ok {
+				t.receive(r)
+			}
+		case now := <-ticker.C:
+			if t.Source() == System {
+				t.advance(now.Sub(last).Seconds())
+			}
+			last = now
+		case <-t.disconnect:
+			return
+		}
+	}
+}
+
+// Follows a system real-time message, if the transport follows MIDI.
+func (t *Transport) receive(r midi.Realtime) {
+	t.mu.Lock()
+	if t.source != MIDI {
+		t.mu.Unlock()
+		return
+	}
+	switch r.Status {
+	case midi.TIMING_CLOCK:
+		at := r.Time
+		if at == 0 {
+			at = midi.Now()
+		}
+		if t.lastClock != 0 && at > t.lastClock {
+			// Smooth the tempo of the intervals between clocks, which jitter.
+			tempo := float64(time.Minute) / float64(at-t.lastClock) / midi.ClocksPerQuarter
+			t.tempo += (tempo - t.tempo) / 8
+		}
+		t.lastClock = at
+		t.mu.Unlock()
+		t.advanceTicks(float64(t.PPQN) / midi.ClocksPerQuarter)
+		return
+	case midi.START:
+		t.ticks, t.playing = 0, true
+		t.align()
+	case midi.CONTINUE:
+		t.playing = true
+	case midi.STOP:
+		t.playing = false
+	}
+	t.lastClock = 0
+	t.mu.Unlock()
+}
+
+// Advances the transport by the frames of audio processed by an audio callback,
+// if the transport is advanced by audio. Functions scheduled at the ticks that
+// the transport reaches are called before it returns.
+func (t *Transport) Advance(frames int) {
+	t.mu.Lock()
+	source, sampleRate := t.source, t.sampleRate
+	t.mu.Unlock()
+	if source == Audio && sampleRate > 0 {
+		t.advance(float64(frames) / float64(sampleRate))
+	}
+}
+
+// Advances the transport by a number of seconds at its tempo.
+func (t *Transport) advance(seconds float64) {
+	t.mu.Lock()
+	ticks := seconds * t.tempo / 60 * float64(t.PPQN)
+	t.mu.Unlock()
+	t.advanceTicks(ticks)
+}
+
+// Advances the transport by a number of ticks while it is playing, sending the
+// timing clocks that it passes and calling the functions that are due.
+func (t *Transport) advanceTicks(ticks float64) {
+	t.mu.Lock()
+	if !t.playing {
+		t.mu.Unlock()
+		return
+	}
+	from, to := t.ticks, t.ticks+ticks
+	t.ticks = to
+	clocks := 0
+	if t.source != MIDI {
+		perClock := float64(t.PPQN) / midi.ClocksPerQuarter
+		for c := math.Ceil(from / perClock); c*perClock < to; c++ {
+			clocks++
+		}
+	}
+	type call struct {
+		f    func(int64)
+		tick int64
+	}
+	var calls []call
+	remaining := t.events[:0]
+	for _, e := range t.events {
+		for float64(e.tick) < to {
+			calls = append(calls, call{e.f, e.tick})
+			if e.every <= 0 {
+				break
+			}
+			e.tick += e.every
+		}
+		if e.every > 0 || float64(e.tick) >= to {
+			remaining = append(remaining, e)
+		}
+	}
+	for i := len(remaining); i < len(t.events); i++ {
+		t.events[i] = nil
+	}
+	t.events = remaining
+	t.mu.Unlock()
+
+	for i := 0; i < clocks; i++ {
+		t.sendClock(midi.Realtime{Status: midi.TIMING_CLOCK, Time: midi.Now()})
+	}
+	for _, c := range calls {
+		c.f(c.tick)
+	}
+}
+
+// Queues a message to be sent out of the transport, dropping it if the queue is full.
+func (t *Transport) sendClock(m midi.Message) {
+	select {
+	case t.clocks <- m:
+	default:
+	}
+}
+
+// Moves the events that repeat to the first of their ticks from the position.
+// The transport must be locked.
+func (t *Transport) align() {
+	for _, e := range t.events {
+		if e.every > 0 {
+			e.tick = int64(math.Ceil(t.ticks/float64(e.every))) * e.every
+		}
+	}
+}
+
+// Returns what advances the transport.
+func (t *Transport) Source() Source {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.source
+}
+
+func (t *Transport) SetSource(s Source) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.source = s
+	t.lastClock = 0
+}
+
+// Sets the sample rate of the audio that advances the transport.
+func (t *Transport) SetSampleRate(sampleRate int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sampleRate = sampleRate
+}
+
+// Starts (or continues) the transport from its position, sending a start (or continue).
+func (t *Transport) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.playing {
+		return
+	}
+	t.playing = true
+	if t.source == MIDI {
+		return
+	}
+	if t.ticks == 0 {
+		t.sendClock(midi.Realtime{Status: midi.START, Time: midi.Now()})
+	} else {
+		t.sendClock(midi.Realtime{Status: midi.CONTINUE, Time: midi.Now()})
+	}
+}
+
+// Stops the transport at its position, sending a stop.
+func (t *Transport) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.playing {
+		return
+	}
+	t.playing = false
+	if t.source != MIDI {
+		t.sendClock(midi.Realtime{Status: midi.STOP, Time: midi.Now()})
+	}
+}
+
+// Moves (locates) the transport to a position in ticks.
+func (t *Transport) Locate(ticks int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ticks = float64(ticks)
+	t.align()
+}
+
+func (t *Transport) Playing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.playing
+}
+
+// Returns the tempo in beats per minute, which is of the timing clock when following MIDI.
+func (t *Transport) Tempo() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tempo
+}
+
+func (t *Transport) SetTempo(bpm float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bpm > 0 {
+		t.tempo = bpm
+	}
+}
+
+// Returns the position in ticks.
+func (t *Transport) Ticks() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int64(t.ticks)
+}
+
+// Returns the position in bars, beats and ticks.
+func (t *Transport) Position() Position {
+	return t.PositionOf(t.Ticks())
+}
+
+// Returns the position of a number of ticks.
+func (t *Transport) PositionOf(ticks int64) Position {
+	beats := ticks / int64(t.PPQN)
+	return Position{
+		Bar:  int(beats / int64(t.BeatsPerBar)),
+		Beat: int(beats % int64(t.BeatsPerBar)),
+		Tick: int(ticks % int64(t.PPQN)),
+	}
+}
+
+// Returns the number of ticks of a position.
+func (t *Transport) TicksOf(p Position) int64 {
+	return (int64(p.Bar)*int64(t.BeatsPerBar)+int64(p.Beat))*int64(t.PPQN) + int64(p.Tick)
+}
+
+// Returns whether a tick is the first of a step, of a number of steps per beat
+// that need not divide the ticks per quarter note: step n starts at the tick
+// n*PPQN/stepsPerBeat, rounded up, so that steps do not drift from the beat.
+// There is a step at most every tick.
+func (t *Transport) StepStarts(tick int64, stepsPerBeat int) bool {
+	step := func(tick int64) int64 { return tick * int64(stepsPerBeat) / int64(t.PPQN) }
+	return tick <= 0 || step(tick) != step(tick-1)
+}
+
+// Calls a function when the transport reaches a tick, or as soon as it advances
+// if it is past it. Functions are called by what advances the transport, such as
+// an audio callback, so they must return quickly.
+func (t *Transport) At(tick int64, f func(tick int64)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, &event{tick: tick, f: f})
+}
+
+// Calls a function every number of ticks, at the multiples of that number, like At.
+func (t *Transport) Every(ticks int64, f func(tick int64)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := &event{every: ticks, f: f}
+	t.events = append(t.events, e)
+	t.align()
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aoeu/audio/midi"
+)
+
+func TestAdvance(t *testing.T) {
+	tr := New(48000)
+	tr.SetSource(Audio)
+	var beats []int64
+	tr.Every(int64(tr.PPQN), func(tick int64) { beats = append(beats, tick) })
+	var at int64 = -1
+	tr.At(tr.TicksOf(Position{Bar: 1}), func(tick int64) { at = tick })
+
+	tr.Advance(24000)
+	if tr.Ticks() != 0 {
+		t.Errorf("The transport advanced to %v while stopped", tr.Position())
+	}
+	tr.Start()
+	for i := 0; i < 5*48000/256; i++ { // 5 seconds of buffers of 256 frames, of 10 beats.
+		tr.Advance(256)
+	}
+	if p := tr.Position(); p != (Position{Bar: 2, Beat: 1, Tick: 95}) {
+		t.Errorf("Advanced to %v instead of 3.2.95", p)
+	}
+	if len(beats) != 10 || beats[9] != 9*int64(tr.PPQN) {
+		t.Errorf("Called a function at the beats %v", beats)
+	}
+	if at != int64(4*tr.PPQN) {
+		t.Errorf("Called a function at tick %v instead of the second bar", at)
+	}
+	tr.Stop()
+
+	clocks := 0
+	var statuses []int
+	for len(tr.clocks) > 0 {
+		r := (<-tr.clocks).(midi.Realtime)
+		if r.Status == midi.TIMING_CLOCK {
+			clocks++
+		} else {
+			statuses = append(statuses, r.Status)
+		}
+	}
+	if clocks != 10*24 {
+		t.Errorf("Sent %v timing clocks instead of 240", clocks)
+	}
+	if len(statuses) != 2 || statuses[0] != midi.START || statuses[1] != midi.STOP {
+		t.Errorf("Sent %#x instead of a start and a stop", statuses)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	tr := New(48000)
+	tr.SetSource(Audio)
+	var ticks []int64
+	tr.Every(48, func(tick int64) { ticks = append(ticks, tick) })
+	tr.Locate(100)
+	tr.Start()
+	tr.Advance(12000) // Half a beat, of 48 ticks.
+	if len(ticks) != 1 || ticks[0] != 144 {
+		t.Errorf("Called a function at %v instead of 144 after locating 100", ticks)
+	}
+}
+
+func TestFollowMIDI(t *testing.T) {
+	tr := New(48000)
+	tr.SetSource(MIDI)
+	go tr.Connect()
+	defer tr.Close()
+
+	interval := time.Minute / 90 / 24
+	start := midi.Now()
+	tr.In <- midi.Realtime{Status: midi.START}
+	for i := 0; i < 2*24; i++ {
+		tr.In <- midi.Realtime{Status: midi.TIMING_CLOCK, Time: start + midi.Timestamp(time.Duration(i)*interval)}
+	}
+	tr.In <- midi.Realtime{Status: midi.STOP}
+	tr.In <- midi.NoteOn{} // Received once the stop has been followed.
+	if tr.Playing() {
+		t.Errorf("The transport is playing after a stop")
+	}
+	if p := tr.Position(); p != (Position{Beat: 2}) {
+		t.Errorf("Followed 48 timing clocks to %v instead of 1.3.0", p)
+	}
+	if tempo := tr.Tempo(); tempo < 89 || tempo > 91 {
+		t.Errorf("Followed timing clocks of 90 BPM at %v BPM", tempo)
+	}
+	select {
+	case m := <-tr.Out:
+		t.Errorf("Sent %v while following MIDI", m)
+	default:
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	tr := New(48000)
+	tr.SetTempo(600) // Beats of 100 milliseconds.
+	go tr.Connect()
+	defer tr.Close()
+	tr.Start()
+	time.Sleep(250 * time.Millisecond) // While nothing receives the clock that is sent.
+	if p := tr.Position(); p.Beat < 2 {
+		t.Errorf("The system clock advanced the transport to %v instead of past 1.3.0", p)
+	}
+}
+
+func TestStepStarts(t *testing.T) {
+	tr := New(48000)
+	var starts []int64
+	for tick := int64(0); tick <= int64(tr.PPQN); tick++ {
+		if tr.StepStarts(tick, 5) {
+			starts = append(starts, tick)
+		}
+	}
+	if expected := []int64{0, 20, 39, 58, 77, 96}; !reflect.DeepEqual(starts, expected) {
+		t.Errorf("Started steps of 5 per beat at %v instead of %v", starts, expected)
+	}
+}